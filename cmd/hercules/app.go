@@ -2,12 +2,14 @@ package main
 
 import (
 	"context"
-	"database/sql"
 	"errors"
+	"fmt"
+	"maps"
 	"net/http"
 	"os"
 	"os/signal"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -16,87 +18,116 @@ import (
 	herculespackage "github.com/jakthom/hercules/pkg/herculesPackage"
 	"github.com/jakthom/hercules/pkg/metric"
 	registry "github.com/jakthom/hercules/pkg/metricRegistry"
-	"github.com/jakthom/hercules/pkg/middleware"
+	"github.com/jakthom/hercules/pkg/openmetrics"
+	"github.com/jakthom/hercules/pkg/otlp"
+	remotewrite "github.com/jakthom/hercules/pkg/remoteWrite"
+	"github.com/jakthom/hercules/pkg/scheduler"
+	"github.com/jakthom/hercules/pkg/telemetry"
+	herculestypes "github.com/jakthom/hercules/pkg/types"
+	"github.com/jakthom/hercules/pkg/watcher"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
 type Hercules struct {
 	config           config.Config
-	db               *sql.DB
+	connections      *flock.ConnectionManager
 	packages         []herculespackage.Package
-	conn             *sql.Conn
 	metricRegistries []*registry.MetricRegistry
+	scheduler        *scheduler.Scheduler
+	remoteWriter     *remotewrite.Writer // Nil unless remote write is configured.
+	otlpExporters    []*otlp.Exporter
+	telemetry        *telemetry.Telemetry
+	handlers         atomic.Pointer[handlers] // Replaced on every reload.
+	watcher          *watcher.Watcher
+	reloadMu         sync.Mutex // Serializes reloads, and guards closing.
+	closing          bool
 	debug            bool
 	version          string // Added version field to the struct
 }
 
-func (d *Hercules) configure() {
+// handlers serve the metrics of the packages that are currently loaded.
+type handlers struct {
+	gatherer      prometheus.Gatherer
+	otlpResources []otlp.Resource
+	metrics       http.Handler
+	packages      map[herculestypes.PackageName]http.Handler
+}
+
+// configure sets the log level and loads the configuration. If the configuration cannot be
+// loaded, the defaults are used and the error is returned.
+func (d *Hercules) configure() error {
 	log.Debug().Msg("configuring Hercules")
-	zerolog.SetGlobalLevel(zerolog.InfoLevel)
-	debug := config.IsDebugMode()
-	if debug {
-		d.debug = true
-		zerolog.SetGlobalLevel(zerolog.DebugLevel)
-	}
-	trace := config.IsTraceMode()
-	if trace {
-		zerolog.SetGlobalLevel(zerolog.TraceLevel)
-	}
+	d.configureLogging()
 
 	// Load configuration and handle errors
 	var err error
 	d.config, err = config.GetConfig()
-	if err != nil {
-		log.Warn().Err(err).Msg("using default configuration due to error")
-	} else if !debug && d.config.Debug {
+	if err == nil && !d.debug && d.config.Debug {
 		d.debug = true
 		zerolog.SetGlobalLevel(zerolog.DebugLevel)
 		log.Debug().Msg("debug mode enabled via config file")
 	}
+	return err
+}
+
+// configureLogging sets the log level from the environment.
+func (d *Hercules) configureLogging() {
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	if config.IsDebugMode() {
+		d.debug = true
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	}
+	if config.IsTraceMode() {
+		zerolog.SetGlobalLevel(zerolog.TraceLevel)
+	}
 }
 
 func (d *Hercules) initializeFlock() {
 	log.Debug().Str("db", d.config.DB).Msg("initializing database")
-	d.db, d.conn = flock.InitializeDB(d.config)
+	d.connections = flock.InitializeDB(d.config)
+}
+
+// loadPackages loads and validates every package of the configuration.
+func (d *Hercules) loadPackages() error {
+	var err error
+	d.packages, err = d.getPackages(d.config)
+	return d.validate(d.config, d.packages, err)
 }
 
-func (d *Hercules) loadPackages() {
+// getPackages loads every package configured in conf, followed by the core package. Packages
+// that cannot be loaded are returned empty, and their errors are joined.
+func (d *Hercules) getPackages(conf config.Config) ([]herculespackage.Package, error) {
 	pkgs := []herculespackage.Package{}
-	for _, pkgConfig := range d.config.Packages {
+	var errs []error
+	for i, pkgConfig := range conf.Packages {
 		pkg, err := pkgConfig.GetPackage()
 		pkg.Metadata = metric.Metadata{
 			PackageName: string(pkg.Name),
 			Prefix:      pkgConfig.MetricPrefix,
-			Labels:      d.config.InstanceLabels(),
+			Labels:      conf.InstanceLabels(),
 		}
 		if err != nil {
-			log.Error().Err(err).Msg("could not get package")
+			// Packages that cannot be read are located within the configuration file
+			errs = append(errs, herculestypes.InFile(herculestypes.Nest(err, fmt.Sprintf("packages[%d]", i)), config.Path()))
 		}
 		pkgs = append(pkgs, pkg)
 	}
 	// Represent core configuration via a package
-	pkgs = append(pkgs, herculespackage.Package{
-		Name:       "core",
-		Version:    "1.0.0",
-		Extensions: d.config.Extensions,
-		Macros:     d.config.Macros,
-		Sources:    d.config.Sources,
-		Metrics:    d.config.Metrics,
-		Metadata: metric.Metadata{
-			PackageName: "core",
-			Labels:      d.config.InstanceLabels(),
-		},
-	})
-	d.packages = pkgs
+	pkgs = append(pkgs, conf.CorePackage())
+	for i := range pkgs {
+		pkgs[i].DefaultTimeouts(conf.SourceTimeout.Duration(), conf.QueryTimeout.Duration())
+		pkgs[i].Instrument(d.telemetry)
+	}
+	return pkgs, errors.Join(errs...)
 }
 
 func (d *Hercules) initializePackages() {
 	// Use our new parallel package initialization function
-	err := herculespackage.InitializePackagesWithConnection(d.packages, d.conn)
+	err := herculespackage.InitializePackagesWithPool(d.packages, d.connections.Writer())
 	if err != nil {
 		log.Error().Err(err).Msg("error initializing packages")
 	}
@@ -105,29 +136,187 @@ func (d *Hercules) initializePackages() {
 func (d *Hercules) initializeRegistries() {
 	// Register a registry for each package
 	for _, pkg := range d.packages {
-		if d.metricRegistries == nil {
-			d.metricRegistries = []*registry.MetricRegistry{registry.NewMetricRegistry(pkg.Metrics)}
-		} else {
-			d.metricRegistries = append(d.metricRegistries, registry.NewMetricRegistry(pkg.Metrics))
+		r := registry.NewMetricRegistry(pkg.Metrics)
+		r.Instrument(d.telemetry)
+		d.metricRegistries = append(d.metricRegistries, r)
+	}
+}
+
+func (d *Hercules) initializeScheduler() {
+	// Registries are built in package order, so each registry materializes on its package's interval
+	d.scheduler = scheduler.New(d.connections.Reader())
+	for i, pkg := range d.packages {
+		d.scheduler.Schedule(d.metricRegistries[i], d.config.MaterializeInterval(pkg.MaterializeIntervalSeconds))
+	}
+}
+
+// initializeRemoteWrite prepares pushing every metric to the remote write endpoint, if one is
+// configured. Samples are gathered from the packages loaded at the time of every push.
+func (d *Hercules) initializeRemoteWrite() error {
+	if !d.config.RemoteWrite.Enabled() {
+		return nil
+	}
+	gatherer := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		return d.handlers.Load().gatherer.Gather()
+	})
+	var err error
+	d.remoteWriter, err = remotewrite.New(d.config.RemoteWrite, gatherer)
+	if err != nil {
+		return err
+	}
+	d.remoteWriter.Instrument(d.telemetry)
+	return nil
+}
+
+// initializeOTLP prepares exporting every metric to each configured OTLP endpoint. Metrics are
+// gathered from the packages loaded at the time of every export.
+func (d *Hercules) initializeOTLP() error {
+	resources := func() []otlp.Resource {
+		return d.handlers.Load().otlpResources
+	}
+	for _, exporterConfig := range d.config.OTLP {
+		exporter, err := otlp.New(exporterConfig, resources)
+		if err != nil {
+			return err
 		}
+		exporter.Instrument(d.telemetry)
+		d.otlpExporters = append(d.otlpExporters, exporter)
 	}
+	return nil
 }
 
 func (d *Hercules) Initialize() {
 	log.Debug().Msg("initializing Hercules")
-	d.configure()
+	if err := d.configure(); err != nil {
+		log.Warn().Err(err).Msg("using default configuration due to error")
+	}
+	d.telemetry = telemetry.New()
+	if err := d.loadPackages(); err != nil {
+		logInvalid(err)
+		log.Fatal().Msg("invalid configuration")
+	}
 	d.initializeFlock()
-	d.loadPackages()
 	d.initializePackages()
 	d.initializeRegistries()
+	d.initializeScheduler()
 	log.Debug().Interface("config", d.config).Msg("running with config")
 }
 
+// packageRegistries groups registries by package name. The same package may be loaded
+// more than once (with different metric prefixes), in which case its registries are merged.
+func (d *Hercules) packageRegistries() map[herculestypes.PackageName][]*registry.MetricRegistry {
+	registries := make(map[herculestypes.PackageName][]*registry.MetricRegistry)
+	for i, pkg := range d.packages {
+		registries[pkg.Name] = append(registries[pkg.Name], d.metricRegistries[i])
+	}
+	return registries
+}
+
+// gatherer merges the registries of every package along with Hercules' own telemetry.
+func (d *Hercules) gatherer() prometheus.Gatherer {
+	return append(prometheus.Gatherers{d.selfGatherer()}, gatherers(d.metricRegistries)...)
+}
+
+// selfGatherer gathers Hercules' own telemetry.
+func (d *Hercules) selfGatherer() prometheus.Gatherer {
+	self := prometheus.NewRegistry()
+	if err := self.Register(d.telemetry); err != nil {
+		log.Error().Err(err).Msg("could not register telemetry")
+	}
+	return self
+}
+
+// otlpResources exports the metrics of every package as a resource identified by the package
+// and the instance, followed by Hercules' own telemetry as a resource identified by the instance.
+func (d *Hercules) otlpResources() []otlp.Resource {
+	instance := map[string]string{"service.name": "hercules"}
+	if d.version != "" {
+		instance["service.version"] = d.version
+	}
+	maps.Copy(instance, d.config.InstanceLabels())
+
+	var resources []otlp.Resource
+	registries := d.packageRegistries()
+	for _, pkg := range d.packages {
+		if _, ok := registries[pkg.Name]; !ok {
+			continue
+		}
+		attributes := maps.Clone(instance)
+		attributes["hercules.package.name"] = string(pkg.Name)
+		attributes["hercules.package.version"] = pkg.Version
+		resources = append(resources, otlp.Resource{Attributes: attributes, Gatherer: gatherers(registries[pkg.Name])})
+		// Packages loaded more than once are exported as a single resource
+		delete(registries, pkg.Name)
+	}
+	return append(resources, otlp.Resource{Attributes: instance, Gatherer: d.selfGatherer()})
+}
+
+func (d *Hercules) newHandlers() *handlers {
+	gatherer := d.gatherer()
+	h := &handlers{
+		gatherer:      gatherer,
+		otlpResources: d.otlpResources(),
+		metrics:       metricsHandler(gatherer, openMetricsFamilies(d.metricRegistries)),
+		packages:      make(map[herculestypes.PackageName]http.Handler),
+	}
+	for name, registries := range d.packageRegistries() {
+		h.packages[name] = metricsHandler(gatherers(registries), openMetricsFamilies(registries))
+	}
+	return h
+}
+
+func (d *Hercules) metricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d.handlers.Load().metrics.ServeHTTP(w, r)
+	})
+}
+
+func (d *Hercules) packageMetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler, ok := d.handlers.Load().packages[herculestypes.PackageName(r.PathValue("package"))]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func gatherers(registries []*registry.MetricRegistry) prometheus.Gatherers {
+	var g prometheus.Gatherers
+	for _, r := range registries {
+		g = append(g, r.Gatherer())
+	}
+	return g
+}
+
+func openMetricsFamilies(registries []*registry.MetricRegistry) openmetrics.Families {
+	families := openmetrics.Families{}
+	for _, r := range registries {
+		families.Merge(r.OpenMetricsFamilies())
+	}
+	return families
+}
+
+func metricsHandler(gatherer prometheus.Gatherer, families openmetrics.Families) http.Handler {
+	// Continue on error so a single broken metric does not fail the entire scrape
+	return openmetrics.Handler(promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{
+		ErrorHandling:     promhttp.ContinueOnError,
+		EnableOpenMetrics: true,
+	}), gatherer, families)
+}
+
 func (d *Hercules) Run() {
 	// Create server mux and configure routes
 	mux := http.NewServeMux()
-	prometheus.Unregister(collectors.NewGoCollector()) // Remove golang node defaults
-	mux.Handle("/metrics", middleware.MetricsMiddleware(d.conn, d.metricRegistries, promhttp.Handler()))
+	d.handlers.Store(d.newHandlers())
+	mux.Handle("/metrics", d.metricsHandler())
+	mux.Handle("/metrics/{package}", d.packageMetricsHandler())
+	mux.Handle("POST /-/reload", d.reloadHandler())
+	mux.HandleFunc("/-/reload", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "reloads must be requested with POST", http.StatusMethodNotAllowed)
+	})
 	mux.Handle("/", http.RedirectHandler("/metrics", http.StatusSeeOther))
 
 	// Server timeout constants
@@ -151,6 +340,37 @@ func (d *Hercules) Run() {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	// Materialize registries in the background so scrapes are served from snapshots
+	d.scheduler.Start()
+
+	// Push every metric for deployments Prometheus cannot scrape
+	if err := d.initializeRemoteWrite(); err != nil {
+		log.Fatal().Err(err).Msg("could not initialize remote write")
+	}
+	if d.remoteWriter != nil {
+		log.Info().Str("url", d.config.RemoteWrite.URL).Msg("pushing metrics via remote write")
+		d.remoteWriter.Start(d.config.RemoteWriteInterval())
+	}
+	if err := d.initializeOTLP(); err != nil {
+		log.Fatal().Err(err).Msg("could not initialize OTLP export")
+	}
+	for i, exporter := range d.otlpExporters {
+		exporterConfig := d.config.OTLP[i]
+		log.Info().Str("endpoint", exporterConfig.Endpoint).Msg("exporting metrics via OTLP")
+		exporter.Start(d.config.MaterializeInterval(exporterConfig.IntervalSeconds))
+	}
+
+	// Reload on SIGHUP and whenever the configuration or a local package file changes
+	reloads := make(chan os.Signal, 1)
+	signal.Notify(reloads, syscall.SIGHUP)
+	defer signal.Stop(reloads)
+	go func() {
+		for range reloads {
+			d.reload("signal")
+		}
+	}()
+	d.startWatching()
+
 	// Start server in a goroutine
 	serverErrors := make(chan error, 1)
 	go func() {
@@ -175,6 +395,23 @@ func (d *Hercules) Run() {
 	// Use a WaitGroup to ensure proper cleanup of resources
 	var wg sync.WaitGroup
 
+	// Stop reloading and background materialization before the database goes away
+	d.reloadMu.Lock()
+	d.closing = true
+	d.reloadMu.Unlock()
+	if d.watcher != nil {
+		if err := d.watcher.Close(); err != nil {
+			log.Error().Err(err).Msg("error closing file watcher")
+		}
+	}
+	if d.remoteWriter != nil {
+		d.remoteWriter.Stop()
+	}
+	for _, exporter := range d.otlpExporters {
+		exporter.Stop()
+	}
+	d.scheduler.Stop()
+
 	// Gracefully shut down the server
 	wg.Add(1)
 	go func() {
@@ -188,15 +425,7 @@ func (d *Hercules) Run() {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		log.Debug().Msg("closing database connection")
-		if err := d.db.Close(); err != nil {
-			log.Error().Err(err).Msg("error closing database connection")
-		}
-		if !d.debug {
-			if err := os.Remove(d.config.DB); err != nil {
-				log.Error().Err(err).Str("db", d.config.DB).Msg("error removing database file")
-			}
-		}
+		d.closeDatabase()
 	}()
 
 	// Wait for all cleanup operations to complete or timeout
@@ -213,3 +442,16 @@ func (d *Hercules) Run() {
 		log.Warn().Msg("shutdown timed out, forcing exit")
 	}
 }
+
+// closeDatabase closes the database, removing its file unless debugging.
+func (d *Hercules) closeDatabase() {
+	log.Debug().Msg("closing database connections")
+	if err := d.connections.Close(); err != nil {
+		log.Error().Err(err).Msg("error closing database connection")
+	}
+	if !d.debug {
+		if err := os.Remove(d.config.DB); err != nil {
+			log.Error().Err(err).Str("db", d.config.DB).Msg("error removing database file")
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	herculespackage "github.com/jakthom/hercules/pkg/herculesPackage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/rs/zerolog/log"
+)
+
+// exportOptions configure hercules export.
+type exportOptions struct {
+	Output   string        // The file the exposition is written to.
+	Interval time.Duration // How often the exposition is rewritten. Written once if zero.
+}
+
+// Export initializes every package in an in-memory database and writes the exposition of every registry to opts.Output
+// without starting the server, for the textfile collector of node_exporter to serve. Every
+// registry is materialized before each write. The exposition is written once, or on every
+// interval until Hercules is interrupted.
+func (d *Hercules) Export(opts exportOptions) error {
+	if opts.Output == "" {
+		return errors.New("an output file is required")
+	}
+	if err := d.Validate(); err != nil {
+		return err
+	}
+
+	// The database metrics are served from may be locked by a running server
+	d.config.DB = ""
+	d.initializeFlock()
+	defer d.connections.Close()
+	if err := herculespackage.InitializePackagesWithPool(d.packages, d.connections.Writer()); err != nil {
+		return err
+	}
+	defer func() {
+		for i := range d.packages {
+			d.packages[i].Cleanup()
+		}
+	}()
+	d.initializeRegistries()
+	d.initializeScheduler()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	ticker := time.NewTicker(max(opts.Interval, time.Second))
+	defer ticker.Stop()
+	for {
+		d.scheduler.Materialize(ctx)
+		err := writeExposition(opts.Output, d.gatherer())
+		if opts.Interval <= 0 {
+			return err
+		}
+		if err != nil {
+			log.Error().Err(err).Str("output", opts.Output).Msg("could not export metrics")
+		} else {
+			log.Debug().Str("output", opts.Output).Msg("metrics exported")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// writeExposition atomically replaces the file at path with the text exposition of gatherer.
+// Sample timestamps are dropped, as the textfile collector rejects them.
+func writeExposition(path string, gatherer prometheus.Gatherer) error {
+	families, err := gatherer.Gather()
+	if err != nil {
+		// Gathering continues past errors, so a single broken metric does not fail the export
+		log.Error().Err(err).Msg("could not gather every metric")
+	}
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			m.TimestampMs = nil
+		}
+	}
+
+	// The file is written next to path and renamed over it, so it is never read half-written
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	encoder := expfmt.NewEncoder(tmp, expfmt.NewFormat(expfmt.TypeTextPlain))
+	for _, family := range families {
+		if err = encoder.Encode(family); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err = tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
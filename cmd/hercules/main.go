@@ -2,16 +2,59 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
+	"strings"
+
+	"github.com/jakthom/hercules/pkg/config"
+	packagetest "github.com/jakthom/hercules/pkg/packageTest"
 )
 
 // version is set during build.
 var version string
 
+const usage = `Usage: hercules [command] [-config path] [flags] [package files]
+
+Commands:
+  serve      Serve metrics (default)
+  validate   Validate the configuration and every package, exiting non-zero if any are invalid
+  render     Print the configuration with every package resolved
+  query      Print the exposition of a package's metric, or query a package's sources interactively
+  test       Run the tests of the given package files, or of every configured package
+  export     Write the exposition of every package to a file, once or on an interval
+
+Flags:
+`
+
 func main() {
+	// Commands precede flags, and hercules serves metrics if no command is given
+	command, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
 	// Parse command line flags
-	configPath := flag.String("config", "", "Path to the configuration file")
-	flag.Parse()
+	flags := flag.NewFlagSet("hercules", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	configPath := flags.String("config", "", "Path to the configuration file")
+	var query queryOptions
+	if command == "query" {
+		flags.StringVar(&query.Package, "package", string(config.CorePackageName), "Package to query")
+		flags.StringVar(&query.Metric, "metric", "", "Metric to print the exposition of, instead of querying interactively")
+	}
+	var export exportOptions
+	if command == "export" {
+		flags.StringVar(&export.Output, "output", "", "File to write the exposition to, e.g. /var/lib/node_exporter/textfile_collector/hercules.prom")
+		flags.DurationVar(&export.Interval, "interval", 0, "How often to rewrite the file, e.g. 30s. Written once if zero")
+	}
+	var test packagetest.Options
+	if command == "test" {
+		flags.BoolVar(&test.Update, "update", false, "Write the exposition of every metric to its golden file instead of comparing them")
+	}
+	_ = flags.Parse(args) // Exits on error.
 
 	// Set environment variable for config path if specified via flag
 	if *configPath != "" {
@@ -23,6 +66,39 @@ func main() {
 	app := Hercules{
 		version: version,
 	}
-	app.Initialize()
-	app.Run()
+	switch command {
+	case "serve":
+		app.Initialize()
+		app.Run()
+	case "validate":
+		if err := app.Validate(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println("configuration is valid")
+	case "render":
+		if err := app.Render(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, "could not render configuration:", err)
+			os.Exit(1)
+		}
+	case "query":
+		if err := app.Query(query, os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case "test":
+		if err := app.Test(test, flags.Args(), os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case "export":
+		if err := app.Export(export); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", command)
+		flags.Usage()
+		os.Exit(2)
+	}
 }
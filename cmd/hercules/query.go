@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+
+	"github.com/jakthom/hercules/pkg/db"
+	"github.com/jakthom/hercules/pkg/flock"
+	herculespackage "github.com/jakthom/hercules/pkg/herculesPackage"
+	registry "github.com/jakthom/hercules/pkg/metricRegistry"
+	herculestypes "github.com/jakthom/hercules/pkg/types"
+)
+
+// queryOptions selects what hercules query runs.
+type queryOptions struct {
+	Package string // The name of the package to initialize.
+	Metric  string // The metric to print the exposition of. SQL is read interactively if empty.
+}
+
+// Query initializes the extensions, macros, and sources of a single package in an in-memory
+// database without starting the server. It then writes the exposition of the metric named by
+// opts.Metric to out, or, if no metric is named, reads SQL statements from in until it is
+// closed and writes the samples each statement materializes to out.
+func (d *Hercules) Query(opts queryOptions, in io.Reader, out io.Writer) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+	pkg, err := d.queryPackage(herculestypes.PackageName(opts.Package))
+	if err != nil {
+		return err
+	}
+
+	// The database metrics are served from may be locked by a running server
+	d.config.DB = ""
+	d.connections = flock.InitializeDB(d.config)
+	defer d.connections.Close()
+	if err = pkg.InitializeWithPool(d.connections.Writer()); err != nil {
+		return err
+	}
+	defer pkg.Cleanup()
+
+	ctx := context.Background()
+	conn, err := d.connections.Reader().Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if opts.Metric != "" {
+		return d.queryMetric(ctx, conn, pkg, opts.Metric, out)
+	}
+	return d.repl(ctx, conn, pkg, in, out)
+}
+
+// queryPackage returns the loaded package named name.
+func (d *Hercules) queryPackage(name herculestypes.PackageName) (*herculespackage.Package, error) {
+	var names []string
+	for i := range d.packages {
+		if d.packages[i].Name == name {
+			return &d.packages[i], nil
+		}
+		names = append(names, string(d.packages[i].Name))
+	}
+	return nil, fmt.Errorf("no package named %q, expected one of %s", name, strings.Join(names, ", "))
+}
+
+// queryMetric materializes the metric of pkg exposed under name and writes its exposition to out,
+// exactly as it would be scraped from /metrics.
+func (d *Hercules) queryMetric(ctx context.Context, conn *sql.Conn, pkg *herculespackage.Package, name string, out io.Writer) error {
+	definitions := pkg.Metrics.Only(name)
+	typed := definitions.Typed()
+	switch {
+	case len(typed) == 0:
+		return fmt.Errorf("package %s has no metric %s", pkg.Name, name)
+	case !typed[0].Definition.IsEnabled():
+		return fmt.Errorf("metric %s is disabled", name)
+	}
+
+	r := registry.NewMetricRegistry(definitions)
+	r.Instrument(d.telemetry)
+	materializeErr := r.Materialize(ctx, conn)
+
+	recorder := httptest.NewRecorder()
+	metricsHandler(r.Gatherer(), r.OpenMetricsFamilies()).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if _, err := recorder.Body.WriteTo(out); err != nil {
+		return err
+	}
+	return materializeErr
+}
+
+const replHelp = `End SQL statements with a semicolon. Each row is printed as the labels and value Hercules reads from it.
+  .metrics          List the metrics of the package
+  .metric <name>    Print the exposition of a metric
+  .quit             Exit
+`
+
+// repl reads SQL statements from in and writes the samples each materializes to out.
+func (d *Hercules) repl(ctx context.Context, conn *sql.Conn, pkg *herculespackage.Package, in io.Reader, out io.Writer) error {
+	fmt.Fprintf(out, "Querying the %s package.\n%s", pkg.Name, replHelp)
+	scanner := bufio.NewScanner(in)
+	var statement strings.Builder
+	for {
+		if statement.Len() == 0 {
+			fmt.Fprint(out, "hercules> ")
+		} else {
+			fmt.Fprint(out, "      ..> ")
+		}
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return scanner.Err()
+		}
+		line := strings.TrimSpace(scanner.Text())
+
+		// Commands are only recognized between statements
+		command, argument, _ := strings.Cut(line, " ")
+		switch {
+		case statement.Len() > 0 || !strings.HasPrefix(line, "."):
+		case command == ".quit":
+			return nil
+		case command == ".metrics":
+			for _, typed := range pkg.Metrics.Typed() {
+				fmt.Fprintf(out, "%s\t%s\n", typed.Type, strings.Join(typed.Definition.FullNames(), ", "))
+			}
+			continue
+		case command == ".metric":
+			if err := d.queryMetric(ctx, conn, pkg, strings.TrimSpace(argument), out); err != nil {
+				fmt.Fprintln(out, "Error:", err)
+			}
+			continue
+		default:
+			fmt.Fprint(out, replHelp)
+			continue
+		}
+
+		statement.WriteString(line + "\n")
+		if !strings.HasSuffix(line, ";") {
+			continue
+		}
+		results, err := db.Materialize(ctx, conn, db.SQL(statement.String()))
+		statement.Reset()
+		if err != nil {
+			fmt.Fprintln(out, "Error:", err)
+			continue
+		}
+		for _, result := range results {
+			fmt.Fprintln(out, formatSample(result))
+		}
+		fmt.Fprintf(out, "(%d rows)\n", len(results))
+	}
+}
+
+// formatSample formats a query result as a sample of the text exposition format.
+func formatSample(result db.QueryResult) string {
+	labels := make([]string, 0, len(result.Labels))
+	for _, name := range slices.Sorted(maps.Keys(result.Labels)) {
+		labels = append(labels, fmt.Sprintf("%s=%q", name, result.Labels[name]))
+	}
+	return fmt.Sprintf("{%s} %g", strings.Join(labels, ","), result.Value)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/jakthom/hercules/pkg/config"
+	herculespackage "github.com/jakthom/hercules/pkg/herculesPackage"
+	registry "github.com/jakthom/hercules/pkg/metricRegistry"
+	"github.com/jakthom/hercules/pkg/watcher"
+	"github.com/rs/zerolog/log"
+)
+
+// Reload re-reads the configuration and every package and applies only what changed. Packages
+// are matched to their running version by name and metric prefix. Unchanged sources keep
+// refreshing, changed sources are refreshed, and removed sources stop refreshing. Registries are
+// only rebuilt for packages whose metrics or materialization interval changed. If the
+// configuration or any package cannot be loaded or reloaded, the running configuration is kept,
+// and the macros and sources already reloaded are restored to their running definitions.
+func (d *Hercules) Reload() error {
+	d.reloadMu.Lock()
+	defer d.reloadMu.Unlock()
+	if d.closing {
+		return errors.New("hercules is shutting down")
+	}
+
+	conf, err := config.GetConfig()
+	if err != nil {
+		return fmt.Errorf("could not reload config: %w", err)
+	}
+	conf = d.retainRestartSettings(conf)
+	packages, err := d.getPackages(conf)
+	if err = d.validate(conf, packages, err); err != nil {
+		return fmt.Errorf("could not reload packages: %w", err)
+	}
+
+	// Every package is reloaded alongside its running version before any is replaced, so a package
+	// that fails to reload can be discarded along with those reloaded before it
+	previous := make([]*herculespackage.Package, len(packages))
+	previousRegistries := make([]*registry.MetricRegistry, len(packages))
+	diffs := make([]herculespackage.Diff, len(packages))
+	matched := make([]bool, len(d.packages))
+	for i := range packages {
+		pkg := &packages[i]
+		previous[i] = &herculespackage.Package{}
+		if j := d.runningPackage(pkg, matched); j >= 0 {
+			matched[j] = true
+			previous[i], previousRegistries[i] = &d.packages[j], d.metricRegistries[j]
+		}
+		diffs[i], err = pkg.Reload(previous[i], d.connections.Writer())
+		if err != nil {
+			for k := range i {
+				if discardErr := packages[k].Discard(previous[k], d.connections.Writer()); discardErr != nil {
+					log.Error().Err(discardErr).Interface("package", packages[k].Name).Msg("could not restore package")
+				}
+			}
+			return fmt.Errorf("could not reload package %s: %w", pkg.Name, err)
+		}
+	}
+
+	registries := make([]*registry.MetricRegistry, len(packages))
+	for i := range packages {
+		pkg, previousRegistry := &packages[i], previousRegistries[i]
+		pkg.Replace(previous[i])
+		if !diffs[i].Empty() {
+			log.Info().Interface("package", pkg.Name).Interface("diff", diffs[i]).Msg(string(pkg.Name) + " package reloaded")
+		}
+
+		interval := conf.MaterializeInterval(pkg.MaterializeIntervalSeconds)
+		if previousRegistry != nil && diffs[i].Metrics.Empty() &&
+			interval == d.config.MaterializeInterval(previous[i].MaterializeIntervalSeconds) {
+			registries[i] = previousRegistry
+			continue
+		}
+		if previousRegistry != nil {
+			// The previous registry is stopped first as both may share stateful metrics
+			d.scheduler.Unschedule(previousRegistry)
+			registries[i] = previousRegistry.Reload(pkg.Metrics)
+		} else {
+			registries[i] = registry.NewMetricRegistry(pkg.Metrics)
+		}
+		registries[i].Instrument(d.telemetry)
+		d.scheduler.Schedule(registries[i], interval)
+	}
+	for j := range d.packages {
+		if matched[j] {
+			continue
+		}
+		log.Info().Interface("package", d.packages[j].Name).Msg(string(d.packages[j].Name) + " package removed")
+		d.packages[j].Cleanup()
+		d.scheduler.Unschedule(d.metricRegistries[j])
+		d.metricRegistries[j].Remove()
+	}
+
+	d.config, d.packages, d.metricRegistries = conf, packages, registries
+	d.handlers.Store(d.newHandlers())
+	d.watch()
+	log.Info().Msg("configuration reloaded")
+	return nil
+}
+
+// runningPackage returns the index of the running version of pkg that has not been matched yet,
+// or -1 if pkg is new.
+func (d *Hercules) runningPackage(pkg *herculespackage.Package, matched []bool) int {
+	for j := range d.packages {
+		running := &d.packages[j]
+		if !matched[j] && running.Name == pkg.Name && running.Metadata.Prefix == pkg.Metadata.Prefix {
+			return j
+		}
+	}
+	return -1
+}
+
+// retainRestartSettings keeps the running value of every setting in conf that cannot change
+// without a restart, and warns about each one that was changed.
+func (d *Hercules) retainRestartSettings(conf config.Config) config.Config {
+	if conf.Port != d.config.Port {
+		log.Warn().Str("port", conf.Port).Msg("changing the port requires a restart")
+		conf.Port = d.config.Port
+	}
+	if conf.DB != d.config.DB {
+		log.Warn().Str("db", conf.DB).Msg("changing the database requires a restart")
+		conf.DB = d.config.DB
+	}
+	if conf.Connections != d.config.Connections {
+		log.Warn().Interface("connections", conf.Connections).Msg("changing connection pool sizes requires a restart")
+		conf.Connections = d.config.Connections
+	}
+	if !reflect.DeepEqual(conf.RemoteWrite, d.config.RemoteWrite) {
+		log.Warn().Msg("changing remote write requires a restart")
+		conf.RemoteWrite = d.config.RemoteWrite
+	}
+	if !reflect.DeepEqual(conf.OTLP, d.config.OTLP) {
+		log.Warn().Msg("changing OTLP export requires a restart")
+		conf.OTLP = d.config.OTLP
+	}
+	return conf
+}
+
+// reload reloads the configuration, logging the outcome.
+func (d *Hercules) reload(trigger string) {
+	log.Info().Str("trigger", trigger).Msg("reloading configuration")
+	if err := d.Reload(); err != nil {
+		log.Error().Err(err).Str("trigger", trigger).Msg("could not reload configuration")
+	}
+}
+
+func (d *Hercules) reloadHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		log.Info().Str("trigger", "endpoint").Msg("reloading configuration")
+		if err := d.Reload(); err != nil {
+			log.Error().Err(err).Str("trigger", "endpoint").Msg("could not reload configuration")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// watchedFiles returns the configuration file and every package loaded from a local file.
+func (d *Hercules) watchedFiles() []string {
+	files := []string{config.Path()}
+	for _, pkgConfig := range d.config.Packages {
+		// Packages are loaded from endpoints and object storage by prefix, as in GetPackage
+		if strings.HasPrefix(pkgConfig.Package, "http") || strings.HasPrefix(pkgConfig.Package, "s3:/") ||
+			strings.HasPrefix(pkgConfig.Package, "gcs:") {
+			continue
+		}
+		files = append(files, pkgConfig.Package)
+	}
+	return files
+}
+
+// startWatching reloads the configuration whenever the configuration or a local package file changes.
+func (d *Hercules) startWatching() {
+	w, err := watcher.New(watcher.DefaultDebounce, func() { d.reload("file change") })
+	if err != nil {
+		log.Warn().Err(err).Msg("could not watch configuration files - reload with SIGHUP or POST /-/reload instead")
+		return
+	}
+	d.watcher = w
+	d.watch()
+}
+
+// watch updates the watched files after the configuration changed.
+func (d *Hercules) watch() {
+	if d.watcher == nil {
+		return
+	}
+	if err := d.watcher.Watch(d.watchedFiles()); err != nil {
+		log.Warn().Err(err).Msg("could not watch configuration files")
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"io"
+
+	"github.com/jakthom/hercules/pkg/config"
+	"github.com/jakthom/hercules/pkg/db"
+	herculespackage "github.com/jakthom/hercules/pkg/herculesPackage"
+	"github.com/jakthom/hercules/pkg/labels"
+	"github.com/jakthom/hercules/pkg/metric"
+	"github.com/jakthom/hercules/pkg/source"
+	herculestypes "github.com/jakthom/hercules/pkg/types"
+	"sigs.k8s.io/yaml"
+)
+
+// renderedConfig is the configuration Hercules runs with once every package is loaded.
+type renderedConfig struct {
+	Name          string                  `json:"name"`
+	Port          string                  `json:"port"`
+	DB            string                  `json:"db"`
+	Connections   config.ConnectionConfig `json:"connections"`
+	QueryTimeout  herculestypes.Duration  `json:"queryTimeout"`
+	SourceTimeout herculestypes.Duration  `json:"sourceTimeout"`
+	GlobalLabels  labels.Labels           `json:"globalLabels"` // Resolved from the environment, including the instance name.
+	Packages      []renderedPackage       `json:"packages"`     // Followed by the core package.
+}
+
+// renderedPackage is a package with its variables substituted and its metric labels inferred.
+type renderedPackage struct {
+	Name                herculestypes.PackageName  `json:"name"`
+	Version             string                     `json:"version"`
+	Location            string                     `json:"location"`
+	MetricPrefix        herculestypes.MetricPrefix `json:"metricPrefix,omitempty"`
+	MaterializeInterval herculestypes.Duration     `json:"materializeInterval"`
+	Variables           herculespackage.Variables  `json:"variables,omitempty"`
+	Extensions          db.Extensions              `json:"extensions"`
+	Macros              []db.Macro                 `json:"macros"`
+	Sources             []source.Source            `json:"sources"`
+	Metrics             []renderedMetric           `json:"metrics"`
+}
+
+// renderedMetric is a metric definition along with the names it is exposed under.
+type renderedMetric struct {
+	Type      metric.Type `json:"type"`
+	FullNames []string    `json:"fullNames"`
+	metric.Definition
+}
+
+// Render loads and validates the configuration and every package without starting the server,
+// and writes the resolved configuration to w as YAML.
+func (d *Hercules) Render(w io.Writer) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+	parser, err := db.NewParser()
+	if err != nil {
+		return err
+	}
+	defer parser.Close()
+	conn, err := parser.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	rendered := renderedConfig{
+		Name:          d.config.Name,
+		Port:          d.config.Port,
+		DB:            d.config.DB,
+		Connections:   d.config.Connections,
+		QueryTimeout:  d.config.QueryTimeout,
+		SourceTimeout: d.config.SourceTimeout,
+		GlobalLabels:  d.config.InstanceLabels(),
+	}
+	for _, pkg := range d.packages {
+		// Labels are inferred from metric queries as the package is initialized
+		if err = pkg.Metrics.InjectMetadata(conn, pkg.Metadata); err != nil {
+			return err
+		}
+		r := renderedPackage{
+			Name:                pkg.Name,
+			Version:             pkg.Version,
+			Location:            pkg.Location,
+			MetricPrefix:        pkg.Metadata.Prefix,
+			MaterializeInterval: herculestypes.Duration(d.config.MaterializeInterval(pkg.MaterializeIntervalSeconds)),
+			Variables:           pkg.Variables,
+			Extensions:          pkg.Extensions,
+			Macros:              pkg.Macros,
+			Sources:             pkg.Sources,
+		}
+		for _, typed := range pkg.Metrics.Typed() {
+			// Disabled metrics are not injected with metadata, but are named the same way
+			definition := *typed.Definition
+			definition.Metadata = pkg.Metadata
+			fullNames := definition.FullNames()
+			// The package metadata is rendered once, as the global labels and the package's prefix
+			definition.Metadata = metric.Metadata{}
+			r.Metrics = append(r.Metrics, renderedMetric{Type: typed.Type, FullNames: fullNames, Definition: definition})
+		}
+		rendered.Packages = append(rendered.Packages, r)
+	}
+
+	out, err := yaml.Marshal(rendered)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	herculespackage "github.com/jakthom/hercules/pkg/herculesPackage"
+	packagetest "github.com/jakthom/hercules/pkg/packageTest"
+	"github.com/jakthom/hercules/pkg/telemetry"
+)
+
+// Test runs the tests of the packages at files, or of every configured package if no files are
+// given, and writes a line for every test to out followed by why each failed test failed. It
+// returns an error if any package cannot be loaded or any test failed.
+func (d *Hercules) Test(opts packagetest.Options, files []string, out io.Writer) error {
+	packages, err := d.testPackages(files)
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for _, pkg := range packages {
+		for _, result := range packagetest.Run(context.Background(), pkg, opts) {
+			status := "PASS"
+			if !result.Passed() {
+				status = "FAIL"
+				failed++
+			}
+			fmt.Fprintf(out, "--- %s: %s/%s\n", status, result.Package, result.Test)
+			for _, failure := range result.Failures {
+				fmt.Fprintln(out, "    "+strings.ReplaceAll(failure, "\n", "\n    "))
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d package tests failed", failed)
+	}
+	return nil
+}
+
+// testPackages loads the packages at files, or every configured package if no files are given.
+func (d *Hercules) testPackages(files []string) ([]herculespackage.Package, error) {
+	if len(files) == 0 {
+		if err := d.Validate(); err != nil {
+			return nil, err
+		}
+		return d.packages, nil
+	}
+	d.configureLogging()
+	var packages []herculespackage.Package
+	var errs []error
+	for _, file := range files {
+		pkgConfig := herculespackage.PackageConfig{Package: file}
+		pkg, err := pkgConfig.GetPackage()
+		errs = append(errs, err)
+		pkg.Instrument(telemetry.New())
+		packages = append(packages, pkg)
+	}
+	return packages, errors.Join(errs...)
+}
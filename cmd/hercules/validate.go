@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jakthom/hercules/pkg/config"
+	"github.com/jakthom/hercules/pkg/db"
+	herculespackage "github.com/jakthom/hercules/pkg/herculesPackage"
+	"github.com/jakthom/hercules/pkg/telemetry"
+	herculestypes "github.com/jakthom/hercules/pkg/types"
+	"github.com/rs/zerolog/log"
+)
+
+// validate checks conf and the packages loaded from it, returning every problem found joined.
+// loadErr is the error packages were loaded with.
+func (d *Hercules) validate(conf config.Config, packages []herculespackage.Package, loadErr error) error {
+	errs := []error{herculestypes.InFile(conf.Validate(), config.Path()), loadErr, herculespackage.ValidatePackages(packages)}
+
+	parser, err := db.NewParser()
+	if err != nil {
+		errs = append(errs, err)
+	} else {
+		defer parser.Close()
+		for i := range packages {
+			errs = append(errs, packages[i].ParseSQL(context.Background(), parser))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// logInvalid logs every problem joined in err with the file and field it was found at.
+func logInvalid(err error) {
+	for _, fieldError := range herculestypes.FieldErrors(err) {
+		log.Error().Str("file", fieldError.File).Str("field", fieldError.Path).Err(fieldError.Err).Msg("invalid configuration")
+	}
+}
+
+// Validate loads the configuration and every package without starting the server, and returns
+// every problem found.
+func (d *Hercules) Validate() error {
+	if err := d.configure(); err != nil {
+		return err
+	}
+	d.telemetry = telemetry.New()
+	return d.loadPackages()
+}
@@ -1,14 +1,26 @@
 package config
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"time"
 
+	"github.com/go-viper/mapstructure/v2"
 	"github.com/jakthom/hercules/pkg/db"
 	herculespackage "github.com/jakthom/hercules/pkg/herculesPackage"
 	"github.com/jakthom/hercules/pkg/labels"
 	"github.com/jakthom/hercules/pkg/metric"
+	"github.com/jakthom/hercules/pkg/otlp"
+	remotewrite "github.com/jakthom/hercules/pkg/remoteWrite"
 	"github.com/jakthom/hercules/pkg/source"
+	herculestypes "github.com/jakthom/hercules/pkg/types"
+	"github.com/prometheus/common/model"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
 )
@@ -31,22 +43,46 @@ const (
 	DefaultPort string = "9999"
 	// DefaultDB is the default database file path.
 	DefaultDB string = "h.db"
+	// DefaultReadConnections is the default size of the connection pool used for metric queries.
+	DefaultReadConnections int = 4
+	// DefaultWriteConnections is the default size of the connection pool used for source refreshes.
+	DefaultWriteConnections int = 2
+	// DefaultMaterializeIntervalSeconds is the default interval at which metric registries are materialized.
+	DefaultMaterializeIntervalSeconds int = 15
+	// DefaultQueryTimeout is how long metric queries, and source refreshes unless sourceTimeout is
+	// set, may run unless configured otherwise.
+	DefaultQueryTimeout = 30 * time.Second
+
+	// maxPort is the highest TCP port.
+	maxPort = 65535
 
 	// HerculesNameLabel is the label name used for Hercules instance identification.
 	HerculesNameLabel = "hercules"
 )
 
+// ConnectionConfig sizes the DuckDB connection pools.
+type ConnectionConfig struct {
+	Read  int `json:"read"`
+	Write int `json:"write"`
+}
+
 type Config struct {
-	Name         string                          `json:"name"`
-	Debug        bool                            `json:"debug"`
-	Port         string                          `json:"port"`
-	DB           string                          `json:"db"`
-	GlobalLabels labels.Labels                   `json:"globalLabels"`
-	Packages     []herculespackage.PackageConfig `json:"packages"`
-	Extensions   db.Extensions                   `json:"extensions"`
-	Macros       []db.Macro                      `json:"macros"`
-	Sources      []source.Source                 `json:"sources"`
-	Metrics      metric.Definitions              `json:"metrics"`
+	Name                       string                          `json:"name"`
+	Debug                      bool                            `json:"debug"`
+	Port                       string                          `json:"port"`
+	DB                         string                          `json:"db"`
+	Connections                ConnectionConfig                `json:"connections"`
+	MaterializeIntervalSeconds int                             `json:"materializeIntervalSeconds"`
+	QueryTimeout               herculestypes.Duration          `json:"queryTimeout"`
+	SourceTimeout              herculestypes.Duration          `json:"sourceTimeout"` // Defaults to the query timeout.
+	GlobalLabels               labels.Labels                   `json:"globalLabels"`
+	Packages                   []herculespackage.PackageConfig `json:"packages"`
+	Extensions                 db.Extensions                   `json:"extensions"`
+	Macros                     []db.Macro                      `json:"macros"`
+	Sources                    []source.Source                 `json:"sources"`
+	Metrics                    metric.Definitions              `json:"metrics"`
+	RemoteWrite                remotewrite.Config              `json:"remoteWrite"`
+	OTLP                       []otlp.Config                   `json:"otlp"`
 }
 
 func (c *Config) InstanceLabels() labels.Labels {
@@ -58,18 +94,127 @@ func (c *Config) InstanceLabels() labels.Labels {
 	return globalLabels
 }
 
-func (c *Config) Validate() {
-	// Passthrough for now - stubbed for config validation
+// MaterializeInterval returns how often a package's metrics are materialized. A positive
+// package-level interval takes precedence over the instance-wide setting.
+func (c *Config) MaterializeInterval(packageIntervalSeconds int) time.Duration {
+	seconds := c.MaterializeIntervalSeconds
+	if packageIntervalSeconds > 0 {
+		seconds = packageIntervalSeconds
+	}
+	if seconds <= 0 {
+		seconds = DefaultMaterializeIntervalSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// RemoteWriteInterval returns how often samples are pushed to the remote write endpoint, which
+// defaults to the instance-wide materialization interval.
+func (c *Config) RemoteWriteInterval() time.Duration {
+	return c.MaterializeInterval(c.RemoteWrite.IntervalSeconds)
+}
+
+// CorePackageName is the name of the package holding the extensions, macros, sources, and
+// metrics configured in hercules.yml rather than in a package.
+const CorePackageName herculestypes.PackageName = "core"
+
+// CorePackage represents the extensions, macros, sources, and metrics configured outside of
+// packages as a package.
+func (c *Config) CorePackage() herculespackage.Package {
+	return herculespackage.Package{
+		Name:       CorePackageName,
+		Version:    "1.0.0",
+		Extensions: c.Extensions,
+		Macros:     c.Macros,
+		Sources:    c.Sources,
+		Metrics:    c.Metrics,
+		Metadata: metric.Metadata{
+			PackageName: string(CorePackageName),
+			Labels:      c.InstanceLabels(),
+		},
+		Location: Path(),
+	}
+}
+
+// Validate checks the configuration for settings Hercules cannot run with, including the
+// extensions, macros, sources, and metrics configured outside of packages. Every problem is
+// reported as a *herculestypes.FieldError locating the field within the configuration file.
+func (c *Config) Validate() error {
+	var errs []error
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > maxPort {
+		errs = append(errs, herculestypes.FieldErrorf("port", "%q is not a valid port", c.Port))
+	}
+	if c.Connections.Read < 0 {
+		errs = append(errs, herculestypes.FieldErrorf("connections.read", "connections must not be negative"))
+	}
+	if c.Connections.Write < 0 {
+		errs = append(errs, herculestypes.FieldErrorf("connections.write", "connections must not be negative"))
+	}
+	if c.MaterializeIntervalSeconds < 0 {
+		errs = append(errs, herculestypes.FieldErrorf("materializeIntervalSeconds", "materializeIntervalSeconds must not be negative"))
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.GlobalLabels)) {
+		if !model.LabelName(name).IsValidLegacy() {
+			errs = append(errs, herculestypes.FieldErrorf("globalLabels."+name, "%q is not a valid label name", name))
+		}
+	}
+	for i, pkgConfig := range c.Packages {
+		path := fmt.Sprintf("packages[%d]", i)
+		if pkgConfig.Package == "" {
+			errs = append(errs, herculestypes.FieldErrorf(path+".package", "package is required"))
+		}
+		if pkgConfig.MetricPrefix != "" && !model.IsValidLegacyMetricName(string(pkgConfig.MetricPrefix)) {
+			errs = append(errs, herculestypes.FieldErrorf(path+".metricPrefix", "%q is not a valid metric prefix", pkgConfig.MetricPrefix))
+		}
+		if pkgConfig.MaterializeIntervalSeconds < 0 {
+			errs = append(errs, herculestypes.FieldErrorf(path+".materializeIntervalSeconds", "materializeIntervalSeconds must not be negative"))
+		}
+	}
+	errs = append(errs, herculestypes.Nest(c.RemoteWrite.Validate(), "remoteWrite"))
+	for i, exporter := range c.OTLP {
+		errs = append(errs, herculestypes.Nest(exporter.Validate(), fmt.Sprintf("otlp[%d]", i)))
+	}
+	// Settings of the core package are configured at the same paths as in a package
+	core := c.CorePackage()
+	errs = append(errs, core.Validate())
+	return errors.Join(errs...)
+}
+
+// Path returns the path of the configuration file.
+func Path() string {
+	if path := os.Getenv(HerculesConfigPath); path != "" {
+		return path
+	}
+	return DefaultHerculesConfigPath
+}
+
+// jsonUnmarshalerHookFunc decodes values into types implementing json.Unmarshaler through their
+// UnmarshalJSON method, so settings embedded in hercules.yml are parsed the same way as in packages.
+func jsonUnmarshalerHookFunc() mapstructure.DecodeHookFuncType {
+	return func(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+		if from == to {
+			return data, nil
+		}
+		target := reflect.New(to)
+		unmarshaler, ok := target.Interface().(json.Unmarshaler)
+		if !ok {
+			return data, nil
+		}
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		if err = unmarshaler.UnmarshalJSON(raw); err != nil {
+			return nil, err
+		}
+		return target.Elem().Interface(), nil
+	}
 }
 
 // GetConfig retrieves the application configuration from file or returns defaults.
-// If the specified file cannot be read, it will fall back to sane defaults.
+// If the specified file cannot be read, it will fall back to sane defaults and return an error.
 func GetConfig() (Config, error) {
 	// Load app config from file
-	confPath := os.Getenv(HerculesConfigPath)
-	if confPath == "" {
-		confPath = DefaultHerculesConfigPath
-	}
+	confPath := Path()
 
 	config := &Config{}
 
@@ -79,6 +224,9 @@ func GetConfig() (Config, error) {
 		config.Debug = DefaultDebug
 		config.Port = DefaultPort
 		config.DB = DefaultDB
+		config.MaterializeIntervalSeconds = DefaultMaterializeIntervalSeconds
+		config.QueryTimeout = herculestypes.Duration(DefaultQueryTimeout)
+		config.SourceTimeout = config.QueryTimeout
 		return *config, fmt.Errorf("config file not found at path: %s", confPath)
 	}
 
@@ -98,10 +246,30 @@ func GetConfig() (Config, error) {
 
 	// Mandatory defaults
 	config.DB = DefaultDB
-	unmarshalErr := viper.Unmarshal(config)
+	unmarshalErr := viper.Unmarshal(config, viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		jsonUnmarshalerHookFunc(),
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+	)))
 	if unmarshalErr != nil {
 		log.Error().Err(unmarshalErr).Msg("error unmarshaling config")
 	}
+	if config.MaterializeIntervalSeconds <= 0 {
+		config.MaterializeIntervalSeconds = DefaultMaterializeIntervalSeconds
+	}
+	if config.QueryTimeout <= 0 {
+		config.QueryTimeout = herculestypes.Duration(DefaultQueryTimeout)
+	}
+	if config.SourceTimeout <= 0 {
+		config.SourceTimeout = config.QueryTimeout
+	}
 
+	// The returned config is still usable, but does not reflect the file
+	if err != nil {
+		return *config, fmt.Errorf("could not read config at path %s: %w", confPath, err)
+	}
+	if unmarshalErr != nil {
+		return *config, fmt.Errorf("could not decode config at path %s: %w", confPath, unmarshalErr)
+	}
 	return *config, nil
 }
@@ -3,14 +3,22 @@ package config_test
 
 import (
 	"bytes"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/jakthom/hercules/pkg/config"
 	herculespackage "github.com/jakthom/hercules/pkg/herculesPackage"
 	"github.com/jakthom/hercules/pkg/labels"
+	"github.com/jakthom/hercules/pkg/otlp"
+	remotewrite "github.com/jakthom/hercules/pkg/remoteWrite"
+	"github.com/jakthom/hercules/pkg/source"
+	herculestypes "github.com/jakthom/hercules/pkg/types"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func buildTestConfig() config.Config {
@@ -66,6 +74,126 @@ func TestGetConfigNoFile(t *testing.T) {
 	assert.Equal(t, config.DefaultPort, conf.Port)
 	assert.Equal(t, config.DefaultDB, conf.DB)
 
-	// Validate should not panic even with default config.
-	conf.Validate()
+	// The default config is valid.
+	assert.NoError(t, conf.Validate())
+}
+
+func TestValidate(t *testing.T) {
+	conf := buildTestConfig()
+	conf.Port = "99999"
+	conf.GlobalLabels["not-valid"] = "value"
+	conf.Packages = []herculespackage.PackageConfig{{MetricPrefix: "1_"}}
+	conf.Sources = []source.Source{{Name: "nyc_yellow_taxi", Type: "sql", Source: "select 1"}, {Name: "nyc_yellow_taxi", Type: "xlsx", Source: "taxi.xlsx"}}
+	conf.RemoteWrite = remotewrite.Config{URL: "localhost:9090/api/v1/write"}
+	conf.OTLP = []otlp.Config{{Endpoint: "http://collector:4317"}, {Protocol: "http/protobuf"}}
+
+	var messages []string
+	for _, fieldError := range herculestypes.FieldErrors(conf.Validate()) {
+		messages = append(messages, fieldError.Error())
+	}
+	assert.Equal(t, []string{
+		`port: "99999" is not a valid port`,
+		`globalLabels.not-valid: "not-valid" is not a valid label name`,
+		`packages[0].package: package is required`,
+		`packages[0].metricPrefix: "1_" is not a valid metric prefix`,
+		`remoteWrite.url: "localhost:9090/api/v1/write" is not an http or https URL`,
+		`otlp[1].endpoint: endpoint is required`,
+		`sources[1].type: unknown source type "xlsx", expected one of sql, parquet, json, or csv`,
+		`sources[1].name: duplicate source name "nyc_yellow_taxi", also defined at sources[0]`,
+	}, messages)
+}
+
+func TestMaterializeInterval(t *testing.T) {
+	conf := buildTestConfig()
+	assert.Equal(t, time.Duration(config.DefaultMaterializeIntervalSeconds)*time.Second, conf.MaterializeInterval(0))
+
+	conf.MaterializeIntervalSeconds = 30
+	assert.Equal(t, 30*time.Second, conf.MaterializeInterval(0))
+	assert.Equal(t, 5*time.Second, conf.MaterializeInterval(5))
+}
+
+func TestGetConfigDecodesMetricSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hercules.yml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+name: test
+metrics:
+  summary:
+    - name: durations
+      sql: select 1 as value
+      objectives: [0.5, 0.99]
+      maxAge: 10m
+      ageBuckets: 3
+`), 0o600))
+	t.Setenv(config.HerculesConfigPath, path)
+
+	conf, err := config.GetConfig()
+	require.NoError(t, err)
+	require.Len(t, conf.Metrics.Summary, 1)
+	summary := conf.Metrics.Summary[0]
+	require.Len(t, summary.Objectives, 2)
+	assert.InDelta(t, 0.05, summary.Objectives[0.5], 1e-12)
+	assert.InDelta(t, 0.001, summary.Objectives[0.99], 1e-12)
+	assert.Equal(t, 10*time.Minute, summary.MaxAge.Duration())
+	assert.Equal(t, uint32(3), summary.AgeBuckets)
+}
+
+func TestGetConfigDecodesRemoteWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hercules.yml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+name: test
+port: "9100"
+materializeIntervalSeconds: 20
+remoteWrite:
+  url: https://prometheus.example.com/api/v1/write
+  timeout: 5s
+  maxRetries: 0
+  basicAuth:
+    username: hercules
+    password: $REMOTE_WRITE_PASSWORD
+  queue:
+    directory: /var/lib/hercules/queue
+    maxRequests: 10
+`), 0o600))
+	t.Setenv(config.HerculesConfigPath, path)
+	t.Setenv("REMOTE_WRITE_PASSWORD", "hunter2")
+
+	conf, err := config.GetConfig()
+	require.NoError(t, err)
+	require.NoError(t, conf.Validate())
+	remoteWrite := conf.RemoteWrite
+	assert.True(t, remoteWrite.Enabled())
+	assert.Equal(t, 5*time.Second, remoteWrite.Timeout.Duration())
+	require.NotNil(t, remoteWrite.MaxRetries)
+	assert.Equal(t, 0, *remoteWrite.MaxRetries)
+	assert.Equal(t, "hunter2", remoteWrite.BasicAuth.Password.Value())
+	assert.Equal(t, remotewrite.QueueConfig{Directory: "/var/lib/hercules/queue", MaxRequests: 10}, remoteWrite.Queue)
+	assert.Equal(t, 20*time.Second, conf.RemoteWriteInterval())
+}
+
+func TestGetConfigDefaultsTimeouts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hercules.yml")
+	require.NoError(t, os.WriteFile(path, []byte("name: test\nqueryTimeout: 45s\n"), 0o600))
+	t.Setenv(config.HerculesConfigPath, path)
+
+	conf, err := config.GetConfig()
+	require.NoError(t, err)
+	assert.Equal(t, 45*time.Second, conf.QueryTimeout.Duration())
+	assert.Equal(t, 45*time.Second, conf.SourceTimeout.Duration(), "sources default to the query timeout")
+
+	require.NoError(t, os.WriteFile(path, []byte("name: test\nsourceTimeout: 10m\n"), 0o600))
+	conf, err = config.GetConfig()
+	require.NoError(t, err)
+	assert.Equal(t, config.DefaultQueryTimeout, conf.QueryTimeout.Duration())
+	assert.Equal(t, 10*time.Minute, conf.SourceTimeout.Duration())
+}
+
+func TestGetConfigInvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hercules.yml")
+	require.NoError(t, os.WriteFile(path, []byte("name: [unterminated"), 0o600))
+	t.Setenv(config.HerculesConfigPath, path)
+
+	conf, err := config.GetConfig()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "could not read config")
+	assert.Equal(t, config.DefaultDB, conf.DB)
 }
@@ -2,6 +2,7 @@ package db
 
 import (
 	"database/sql"
+	"fmt"
 
 	"github.com/rs/zerolog/log"
 )
@@ -14,7 +15,7 @@ const (
 	CoreExtensionType string = "core"
 )
 
-func ensureExtension(conn *sql.Conn, extensionName string, extensionType string) {
+func ensureExtension(conn *sql.Conn, extensionName string, extensionType string) error {
 	var installSQL SQL
 	var loadSQL = SQL("load " + extensionName + ";")
 	if extensionType == CommunityExtensionType {
@@ -26,11 +27,7 @@ func ensureExtension(conn *sql.Conn, extensionName string, extensionType string)
 	// Run installation query
 	rows, err := RunSQLQuery(conn, installSQL)
 	if err != nil {
-		// Assume that the world depends on indicated extensions installing and loading properly
-		log.Error().Err(err).
-			Interface("extension", extensionName).
-			Msg("unable to install " + extensionType + " extension")
-		panic("Failed to install extension: " + err.Error())
+		return fmt.Errorf("unable to install %s extension %s: %w", extensionType, extensionName, err)
 	}
 	defer func() {
 		if closeErr := rows.Close(); closeErr != nil {
@@ -41,21 +38,14 @@ func ensureExtension(conn *sql.Conn, extensionName string, extensionType string)
 	}()
 
 	// Check for installation errors
-	rowsErr := rows.Err()
-	if rowsErr != nil {
-		log.Error().Err(rowsErr).
-			Interface("extension", extensionName).
-			Msg("error during installation of " + extensionType + " extension")
-		panic("Error during extension installation: " + rowsErr.Error())
+	if rowsErr := rows.Err(); rowsErr != nil {
+		return fmt.Errorf("error during installation of %s extension %s: %w", extensionType, extensionName, rowsErr)
 	}
 
 	// Run load query
 	loadRows, err := RunSQLQuery(conn, loadSQL)
 	if err != nil {
-		log.Error().Err(err).
-			Interface("extension", extensionName).
-			Msg("unable to load " + extensionType + " extension")
-		panic("Failed to load extension: " + err.Error())
+		return fmt.Errorf("unable to load %s extension %s: %w", extensionType, extensionName, err)
 	}
 	defer func() {
 		if closeErr := loadRows.Close(); closeErr != nil {
@@ -66,36 +56,33 @@ func ensureExtension(conn *sql.Conn, extensionName string, extensionType string)
 	}()
 
 	// Check for loading errors
-	loadRowsErr := loadRows.Err()
-	if loadRowsErr != nil {
-		log.Error().Err(loadRowsErr).
-			Interface("extension", extensionName).
-			Msg("error during loading of " + extensionType + " extension")
-		panic("Error during extension loading: " + loadRowsErr.Error())
+	if loadRowsErr := loadRows.Err(); loadRowsErr != nil {
+		return fmt.Errorf("error during loading of %s extension %s: %w", extensionType, extensionName, loadRowsErr)
 	}
 
 	log.Debug().Interface("extension", extensionName).Msg(extensionType + " extension ensured")
+	return nil
 }
 
 // TestHookEnsureExtension exposes the ensureExtension function for testing.
-func TestHookEnsureExtension(conn *sql.Conn, extensionName string, extensionType string) {
-	ensureExtension(conn, extensionName, extensionType)
+func TestHookEnsureExtension(conn *sql.Conn, extensionName string, extensionType string) error {
+	return ensureExtension(conn, extensionName, extensionType)
 }
 
 type CoreExtension struct {
 	Name string
 }
 
-func (ce *CoreExtension) ensureWithConnection(conn *sql.Conn) {
-	ensureExtension(conn, ce.Name, CoreExtensionType)
+func (ce *CoreExtension) ensureWithConnection(conn *sql.Conn) error {
+	return ensureExtension(conn, ce.Name, CoreExtensionType)
 }
 
 type CommunityExtension struct {
 	Name string
 }
 
-func (e *CommunityExtension) ensureWithConnection(conn *sql.Conn) {
-	ensureExtension(conn, e.Name, CommunityExtensionType)
+func (e *CommunityExtension) ensureWithConnection(conn *sql.Conn) error {
+	return ensureExtension(conn, e.Name, CommunityExtensionType)
 }
 
 type Extensions struct {
@@ -103,11 +90,17 @@ type Extensions struct {
 	Community []CommunityExtension
 }
 
-func EnsureExtensionsWithConnection(extensions Extensions, conn *sql.Conn) {
+// EnsureExtensionsWithConnection installs and loads every extension, stopping at the first that fails.
+func EnsureExtensionsWithConnection(extensions Extensions, conn *sql.Conn) error {
 	for _, coreExtension := range extensions.Core {
-		coreExtension.ensureWithConnection(conn)
+		if err := coreExtension.ensureWithConnection(conn); err != nil {
+			return err
+		}
 	}
 	for _, communityExtension := range extensions.Community {
-		communityExtension.ensureWithConnection(conn)
+		if err := communityExtension.ensureWithConnection(conn); err != nil {
+			return err
+		}
 	}
+	return nil
 }
@@ -2,26 +2,29 @@
 package db_test
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/jakthom/hercules/pkg/db"
 	"github.com/jakthom/hercules/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-func TestEnsureExtension(_ *testing.T) {
+func TestEnsureExtension(t *testing.T) {
 	conn, mock, _ := testutil.GetMockedConnection()
 
 	mock.ExpectQuery("install test;").WithoutArgs().WillReturnRows(sqlmock.NewRows([]string{}))
 	mock.ExpectQuery("load test;").WithoutArgs().WillReturnRows(sqlmock.NewRows([]string{}))
-	db.TestHookEnsureExtension(conn, "test", db.CoreExtensionType)
+	require.NoError(t, db.TestHookEnsureExtension(conn, "test", db.CoreExtensionType))
 
 	mock.ExpectQuery("install z from community;").WithoutArgs().WillReturnRows(sqlmock.NewRows([]string{}))
 	mock.ExpectQuery("load z;").WithoutArgs().WillReturnRows(sqlmock.NewRows([]string{}))
-	db.TestHookEnsureExtension(conn, "z", db.CommunityExtensionType)
+	require.NoError(t, db.TestHookEnsureExtension(conn, "z", db.CommunityExtensionType))
 }
 
-func TestEnsureExtensionsWithConnection(_ *testing.T) {
+func TestEnsureExtensionsWithConnection(t *testing.T) {
 	conn, mock, _ := testutil.GetMockedConnection()
 
 	extensions := db.Extensions{
@@ -41,5 +44,19 @@ func TestEnsureExtensionsWithConnection(_ *testing.T) {
 	mock.ExpectQuery("load testcore;").WithoutArgs().WillReturnRows(sqlmock.NewRows([]string{}))
 	mock.ExpectQuery("install testcommunity from community;").WithoutArgs().WillReturnRows(sqlmock.NewRows([]string{}))
 	mock.ExpectQuery("load testcommunity;").WithoutArgs().WillReturnRows(sqlmock.NewRows([]string{}))
-	db.EnsureExtensionsWithConnection(extensions, conn)
+	require.NoError(t, db.EnsureExtensionsWithConnection(extensions, conn))
+}
+
+func TestEnsureExtensionsWithConnection_ReturnsErrors(t *testing.T) {
+	conn, mock, _ := testutil.GetMockedConnection()
+
+	extensions := db.Extensions{
+		Core: []db.CoreExtension{{Name: "missing"}, {Name: "skipped"}},
+	}
+
+	mock.ExpectQuery("install missing;").WithoutArgs().WillReturnError(errors.New("extension not found"))
+	err := db.EnsureExtensionsWithConnection(extensions, conn)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "extension not found")
+	assert.NoError(t, mock.ExpectationsWereMet())
 }
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/marcboeker/go-duckdb/v2"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	uuidType = "UUID"
+	dateType = "DATE"
+	timeType = "TIME"
+
+	rfc3339FullDate    = "2006-01-02"
+	rfc3339PartialTime = "15:04:05.999999999"
+)
+
+// renderColumnLabel renders a value scanned from a column of the given DuckDB type as a label value.
+// Dates, times, and UUIDs scan into Go types shared with other DuckDB types, so they are rendered
+// by column type.
+func renderColumnLabel(value any, databaseType string) string {
+	switch v := value.(type) {
+	case []byte:
+		if databaseType == uuidType && len(v) == len(duckdb.UUID{}) {
+			uuid := duckdb.UUID(v)
+			return uuid.String()
+		}
+	case time.Time:
+		switch databaseType {
+		case dateType:
+			return v.Format(rfc3339FullDate)
+		case timeType:
+			return v.Format(rfc3339PartialTime)
+		}
+	}
+	return RenderLabel(value)
+}
+
+// RenderLabel renders a value scanned from DuckDB as a label value. Rendering is deterministic:
+// timestamps are RFC3339 in UTC, decimals use their shortest exact form, and lists, structs,
+// and maps are JSON with sorted keys.
+func RenderLabel(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case bool:
+		return strconv.FormatBool(v)
+	case int8, int16, int32, int64, int, uint8, uint16, uint32, uint64, uint:
+		return fmt.Sprintf("%d", v)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case *big.Int:
+		return v.String()
+	case duckdb.Decimal:
+		return v.String()
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case []any, map[string]any, duckdb.Map, duckdb.Interval:
+		rendered, err := json.Marshal(jsonValue(v))
+		if err == nil {
+			return string(rendered)
+		}
+		log.Warn().Err(err).Interface("value", v).Msg("could not render value as json")
+	}
+	log.Warn().Interface("value", value).Msg("rendering value of unsupported type")
+	return fmt.Sprintf("%v", value)
+}
+
+// jsonValue converts nested DuckDB values into values encoding/json renders the same way as
+// RenderLabel renders scalars.
+func jsonValue(value any) any {
+	switch v := value.(type) {
+	case nil, string, bool, duckdb.Interval:
+		return v
+	case []byte, time.Time:
+		return RenderLabel(v)
+	case *big.Int, duckdb.Decimal:
+		return json.Number(RenderLabel(v))
+	case []any:
+		list := make([]any, len(v))
+		for i, element := range v {
+			list[i] = jsonValue(element)
+		}
+		return list
+	case map[string]any:
+		object := make(map[string]any, len(v))
+		for key, element := range v {
+			object[key] = jsonValue(element)
+		}
+		return object
+	case duckdb.Map:
+		object := make(map[string]any, len(v))
+		for key, element := range v {
+			object[RenderLabel(key)] = jsonValue(element)
+		}
+		return object
+	default:
+		return v
+	}
+}
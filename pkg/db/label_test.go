@@ -0,0 +1,37 @@
+package db_test
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/jakthom/hercules/pkg/db"
+	"github.com/marcboeker/go-duckdb/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderLabel(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    any
+		expected string
+	}{
+		{name: "String", value: "warehouse", expected: "warehouse"},
+		{name: "Bool", value: true, expected: "true"},
+		{name: "Integer", value: int64(-42), expected: "-42"},
+		{name: "Float", value: 0.1, expected: "0.1"},
+		{name: "Large float", value: 1e21, expected: "1e+21"},
+		{name: "Hugeint", value: new(big.Int).Lsh(big.NewInt(1), 70), expected: "1180591620717411303424"},
+		{name: "Decimal", value: duckdb.Decimal{Width: 4, Scale: 2, Value: big.NewInt(150)}, expected: "1.5"},
+		{name: "Timestamp", value: time.Date(2024, 7, 1, 12, 0, 0, 500000000, time.FixedZone("CEST", 7200)), expected: "2024-07-01T10:00:00.5Z"},
+		{name: "List", value: []any{int32(1), "a", nil}, expected: `[1,"a",null]`},
+		{name: "Struct", value: map[string]any{"b": int32(1), "a": duckdb.Decimal{Scale: 1, Value: big.NewInt(15)}}, expected: `{"a":1.5,"b":1}`},
+		{name: "Map", value: duckdb.Map{int32(2): "b", int32(1): "a"}, expected: `{"1":"a","2":"b"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, db.RenderLabel(tt.value))
+		})
+	}
+}
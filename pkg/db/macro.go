@@ -2,6 +2,7 @@ package db
 
 import (
 	"database/sql"
+	"fmt"
 
 	"github.com/rs/zerolog/log"
 )
@@ -17,11 +18,10 @@ func (m *Macro) CreateOrReplaceSQL() SQL {
 	return SQL("create or replace macro " + string(m.SQL))
 }
 
-func (m *Macro) ensureWithConnection(conn *sql.Conn) {
+func (m *Macro) ensureWithConnection(conn *sql.Conn) error {
 	rows, err := RunSQLQuery(conn, m.CreateOrReplaceSQL())
 	if err != nil {
-		log.Error().Err(err).Msg("could not ensure macro")
-		panic("Failed to ensure macro: " + err.Error())
+		return fmt.Errorf("could not ensure macro %s: %w", m.Name, err)
 	}
 	defer func() {
 		if closeErr := rows.Close(); closeErr != nil {
@@ -30,23 +30,25 @@ func (m *Macro) ensureWithConnection(conn *sql.Conn) {
 	}()
 
 	// Check for errors
-	rowsErr := rows.Err()
-	if rowsErr != nil {
-		log.Error().Err(rowsErr).Msg("error during macro creation")
-		panic("Error during macro creation: " + rowsErr.Error())
+	if rowsErr := rows.Err(); rowsErr != nil {
+		return fmt.Errorf("error during creation of macro %s: %w", m.Name, rowsErr)
 	}
 
 	log.Debug().Interface("macro", m.SQL).Msg("macro ensured")
+	return nil
 }
 
 // TestHookEnsureMacro exposes the ensureWithConnection method for testing.
-func TestHookEnsureMacro(conn *sql.Conn, macro Macro) {
-	macro.ensureWithConnection(conn)
+func TestHookEnsureMacro(conn *sql.Conn, macro Macro) error {
+	return macro.ensureWithConnection(conn)
 }
 
-// EnsureMacrosWithConnection creates and ensures all macros are properly set up in the database.
-func EnsureMacrosWithConnection(macros []Macro, conn *sql.Conn) {
+// EnsureMacrosWithConnection creates or replaces every macro, stopping at the first that fails.
+func EnsureMacrosWithConnection(macros []Macro, conn *sql.Conn) error {
 	for _, macro := range macros {
-		macro.ensureWithConnection(conn)
+		if err := macro.ensureWithConnection(conn); err != nil {
+			return err
+		}
 	}
+	return nil
 }
@@ -2,12 +2,14 @@
 package db_test
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/jakthom/hercules/pkg/db"
 	"github.com/jakthom/hercules/pkg/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestMacro(t *testing.T) {
@@ -24,10 +26,10 @@ func TestMacro(t *testing.T) {
 	assert.Equal(t, "create or replace macro "+string(macroSQL), string(macro.CreateOrReplaceSQL()))
 	// Ensure query is executed appropriately
 	mock.ExpectQuery(string(macro.CreateOrReplaceSQL())).WithoutArgs().WillReturnRows(sqlmock.NewRows([]string{}))
-	db.TestHookEnsureMacro(conn, macro)
+	require.NoError(t, db.TestHookEnsureMacro(conn, macro))
 }
 
-func TestEnsureMacrosWithConnection(_ *testing.T) {
+func TestEnsureMacrosWithConnection(t *testing.T) {
 	conn, mock, _ := testutil.GetMockedConnection()
 
 	macros := []db.Macro{
@@ -38,5 +40,21 @@ func TestEnsureMacrosWithConnection(_ *testing.T) {
 	}
 
 	mock.ExpectQuery(string(macros[0].CreateOrReplaceSQL())).WithoutArgs().WillReturnRows(sqlmock.NewRows([]string{}))
-	db.EnsureMacrosWithConnection(macros, conn)
+	require.NoError(t, db.EnsureMacrosWithConnection(macros, conn))
+}
+
+func TestEnsureMacrosWithConnection_ReturnsErrors(t *testing.T) {
+	conn, mock, _ := testutil.GetMockedConnection()
+
+	macros := []db.Macro{
+		{
+			Name: "broken",
+			SQL:  db.SQL("broken() as (selec 1)"),
+		},
+	}
+
+	mock.ExpectQuery(string(macros[0].CreateOrReplaceSQL())).WithoutArgs().WillReturnError(errors.New("syntax error"))
+	err := db.EnsureMacrosWithConnection(macros, conn)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "broken")
 }
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+)
+
+// NullLabelPolicy decides how a NULL in a label column is exposed.
+type NullLabelPolicy string
+
+// NullValuePolicy decides how a NULL in a value column is exposed.
+type NullValuePolicy string
+
+const (
+	// NullLabelEmpty exposes NULL labels as empty label values.
+	NullLabelEmpty NullLabelPolicy = "empty"
+	// NullLabelDrop drops rows with a NULL label.
+	NullLabelDrop NullLabelPolicy = "drop"
+	// NullLabelReplace exposes NULL labels as the policy's replacement.
+	NullLabelReplace NullLabelPolicy = "replace"
+
+	// NullValueZero reads NULL values as zero.
+	NullValueZero NullValuePolicy = "zero"
+	// NullValueSkip skips rows with a NULL value.
+	NullValueSkip NullValuePolicy = "skip"
+)
+
+// NullPolicy decides how NULLs returned by a query are exposed. The zero value exposes NULL
+// labels as empty label values and reads NULL values as zero.
+type NullPolicy struct {
+	Labels      NullLabelPolicy `json:"labels,omitempty"`
+	Replacement string          `json:"replacement,omitempty"`
+	Values      NullValuePolicy `json:"values,omitempty"`
+}
+
+// Validate returns an error if the policy is not supported.
+func (p NullPolicy) Validate() error {
+	var errs []error
+	switch p.Labels {
+	case "", NullLabelEmpty, NullLabelDrop:
+	case NullLabelReplace:
+		if p.Replacement == "" {
+			errs = append(errs, errors.New("null label policy replace requires a replacement"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unsupported null label policy %s", p.Labels))
+	}
+	switch p.Values {
+	case "", NullValueZero, NullValueSkip:
+	default:
+		errs = append(errs, fmt.Errorf("unsupported null value policy %s", p.Values))
+	}
+	return errors.Join(errs...)
+}
+
+// nullLabel returns the label value a NULL is exposed as, and false if its row is dropped.
+func (p NullPolicy) nullLabel() (string, bool) {
+	switch p.Labels {
+	case NullLabelDrop:
+		return "", false
+	case NullLabelReplace:
+		return p.Replacement, true
+	default:
+		return "", true
+	}
+}
+
+// keepsNullValues returns whether rows with a NULL value are kept.
+func (p NullPolicy) keepsNullValues() bool {
+	return p.Values != NullValueSkip
+}
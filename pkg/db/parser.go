@@ -1,12 +1,30 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"strings"
+
+	"github.com/marcboeker/go-duckdb/v2"
 )
 
-// GetLabelNamesFromQuery uses DuckDB's own parser to extract all column names from a query.
+// GetLabelNamesFromQuery uses DuckDB's own parser to extract all column names from a query,
+// excluding conventionally named value columns.
 func GetLabelNamesFromQuery(conn *sql.Conn, query SQL) ([]string, error) {
+	columns, err := GetColumnNamesFromQuery(conn, query)
+	var labels []string
+	for _, column := range columns {
+		if !isValueColumn(column) {
+			labels = append(labels, column)
+		}
+	}
+	return labels, err
+}
+
+// GetColumnNamesFromQuery uses DuckDB's own parser to extract all named columns from a query.
+func GetColumnNamesFromQuery(conn *sql.Conn, query SQL) ([]string, error) {
 	parseSQL := SQL(`select coalesce(nullif(row->>'alias', ''), row->>'$.column_names[-1]') 
                      from (select unnest::json as row 
                            from unnest(json_serialize_sql('` + strings.ReplaceAll(string(query), "'", "''") +
@@ -25,7 +43,7 @@ func GetLabelNamesFromQuery(conn *sql.Conn, query SQL) ([]string, error) {
 		if scanErr != nil {
 			return columns, scanErr
 		}
-		if column != "value" && column != "val" && column != "v" && column != "" {
+		if column != "" {
 			columns = append(columns, column)
 		}
 	}
@@ -38,3 +56,73 @@ func GetLabelNamesFromQuery(conn *sql.Conn, query SQL) ([]string, error) {
 
 	return columns, nil
 }
+
+// Parser checks SQL with DuckDB's parser without running it. It holds its own in-memory
+// database, so checking SQL never touches the database metrics are served from.
+type Parser struct {
+	db *sql.DB
+}
+
+// NewParser opens the in-memory database SQL is parsed with.
+func NewParser() (*Parser, error) {
+	connector, err := duckdb.NewConnector("", nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Parser{db: sql.OpenDB(connector)}, nil
+}
+
+// Conn returns a connection to the parser's database. Queries run on it, such as those inferring
+// the column names of metric queries, never touch the database metrics are served from.
+func (p *Parser) Conn(ctx context.Context) (*sql.Conn, error) {
+	return p.db.Conn(ctx)
+}
+
+// Close releases the parser's database.
+func (p *Parser) Close() error {
+	return p.db.Close()
+}
+
+// serializedSQL is the result of DuckDB's json_serialize_sql function.
+type serializedSQL struct {
+	Error        bool              `json:"error"`
+	ErrorType    string            `json:"error_type"`
+	ErrorMessage string            `json:"error_message"`
+	Statements   []json.RawMessage `json:"statements"`
+}
+
+// ParseQuery checks that query is a single SELECT statement DuckDB can parse. Tables, columns,
+// and functions are not resolved.
+func (p *Parser) ParseQuery(ctx context.Context, query SQL) error {
+	var serialized string
+	err := p.db.QueryRowContext(ctx, "select json_serialize_sql(?::varchar)::varchar", string(query)).Scan(&serialized)
+	if err != nil {
+		return err
+	}
+	var result serializedSQL
+	if err = json.Unmarshal([]byte(serialized), &result); err != nil {
+		return err
+	}
+	switch {
+	case result.Error && result.ErrorType == "not implemented":
+		// Only SELECT statements can be serialized
+		return errors.New("query must be a SELECT statement")
+	case result.Error:
+		return errors.New(result.ErrorMessage)
+	case len(result.Statements) != 1:
+		return errors.New("query must be a single statement")
+	}
+	return nil
+}
+
+// ParseMacro checks that DuckDB can parse the definition of macro. DuckDB only parses macros as
+// they are created, so the macro is created in the parser's database; errors other than syntax
+// errors, such as calls to functions of extensions that are not loaded, are ignored.
+func (p *Parser) ParseMacro(ctx context.Context, macro Macro) error {
+	_, err := p.db.ExecContext(ctx, string(macro.CreateOrReplaceSQL()))
+	var duckdbErr *duckdb.Error
+	if errors.As(err, &duckdbErr) && duckdbErr.Type == duckdb.ErrorTypeParser {
+		return err
+	}
+	return nil
+}
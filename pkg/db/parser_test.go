@@ -0,0 +1,36 @@
+// Package db_test contains tests for the db package
+package db_test
+
+import (
+	"testing"
+
+	"github.com/jakthom/hercules/pkg/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParser_ParseQuery(t *testing.T) {
+	parser, err := db.NewParser()
+	require.NoError(t, err)
+	defer parser.Close()
+
+	// Tables and functions are not resolved, so queries of sources that do not exist yet parse
+	require.NoError(t, parser.ParseQuery(t.Context(), "from snowflake_query_history select user_name as user, count(*) as v group by all;"))
+	require.NoError(t, parser.ParseQuery(t.Context(), "select * from read_parquet('missing.parquet')"))
+
+	assert.ErrorContains(t, parser.ParseQuery(t.Context(), "selec 1"), `syntax error at or near "selec"`)
+	assert.ErrorContains(t, parser.ParseQuery(t.Context(), "create table t as select 1"), "must be a SELECT statement")
+	assert.ErrorContains(t, parser.ParseQuery(t.Context(), "select 1; select 2"), "must be a single statement")
+}
+
+func TestParser_ParseMacro(t *testing.T) {
+	parser, err := db.NewParser()
+	require.NoError(t, err)
+	defer parser.Close()
+
+	require.NoError(t, parser.ParseMacro(t.Context(), db.Macro{SQL: "one() AS (SELECT 1)"}))
+	// Functions of extensions that are not loaded are not parse errors
+	require.NoError(t, parser.ParseMacro(t.Context(), db.Macro{SQL: "distance(a, b) AS st_distance(a, b)"}))
+
+	assert.ErrorContains(t, parser.ParseMacro(t.Context(), db.Macro{SQL: "broken( AS 1"}), "syntax error")
+}
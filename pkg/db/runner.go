@@ -4,16 +4,52 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"math"
 	"math/big"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/marcboeker/go-duckdb/v2"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cast"
 )
 
 type QueryResult struct {
-	Value  float64
-	Labels map[string]string
+	Value     float64
+	Labels    map[string]string
+	Columns   map[string]float64 // Numeric columns requested with MaterializeWithColumns.
+	Timestamp time.Time          // Zero unless a timestamp column is requested with MaterializeWithColumns.
+	Exemplar  map[string]string  // Non-NULL exemplar columns requested with MaterializeWithColumns.
+}
+
+// ResultColumns selects the columns of a query that hold numbers rather than labels. Both value
+// and numeric columns are read into QueryResult.Columns.
+type ResultColumns struct {
+	// Values are the columns holding metric values. When none are given, the value is read into
+	// QueryResult.Value from a column named value, val, or v, or an unaliased expression.
+	Values  []string
+	Numeric []string
+	// Timestamp is the column holding the time a row describes, read into QueryResult.Timestamp.
+	Timestamp string
+	// Exemplar are the columns labeling the exemplar of a row, read into QueryResult.Exemplar.
+	Exemplar []string
+	// Valueless disables reading the value from conventionally named columns, so every column
+	// other than the selected ones is a label.
+	Valueless bool
+}
+
+func (c ResultColumns) all() []string {
+	return append(slices.Clone(c.Values), c.Numeric...)
+}
+
+// required returns every selected column, all of which the query must return.
+func (c ResultColumns) required() []string {
+	required := c.all()
+	if c.Timestamp != "" {
+		required = append(required, c.Timestamp)
+	}
+	return append(required, c.Exemplar...)
 }
 
 func toFloat64(v any) float64 {
@@ -30,11 +66,37 @@ func toFloat64(v any) float64 {
 			log.Debug().Interface("value", v).Msg("inexact conversion from big.Float to float64")
 		}
 		return val
+	case duckdb.Decimal:
+		return v.Float64()
 	default:
 		return cast.ToFloat64(v)
 	}
 }
 
+// toTime converts a timestamp column value, either a DuckDB timestamp or a number of seconds
+// since the Unix epoch, to a time. NULLs and unsupported values convert to the zero time.
+func toTime(v any) time.Time {
+	switch v := v.(type) {
+	case nil:
+		return time.Time{}
+	case time.Time:
+		return v
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			log.Warn().Err(err).Str("value", v).Msg("could not parse timestamp")
+		}
+		return t
+	default:
+		seconds, err := cast.ToFloat64E(v)
+		if err != nil {
+			log.Warn().Err(err).Interface("value", v).Msg("could not convert timestamp")
+			return time.Time{}
+		}
+		return time.UnixMicro(int64(math.Round(seconds * float64(time.Second/time.Microsecond))))
+	}
+}
+
 func isFunctionColumn(column string) bool {
 	return strings.Contains(column, "(") && strings.Contains(column, ")")
 }
@@ -49,8 +111,14 @@ func (qr *QueryResult) StringifiedLabels() map[string]string {
 
 // RunSQLQuery executes a SQL query on the provided connection.
 func RunSQLQuery(conn *sql.Conn, query SQL) (*sql.Rows, error) {
+	return RunSQLQueryContext(context.Background(), conn, query)
+}
+
+// RunSQLQueryContext executes a SQL query on the provided connection. The query is interrupted
+// in DuckDB when ctx is done.
+func RunSQLQueryContext(ctx context.Context, conn *sql.Conn, query SQL) (*sql.Rows, error) {
 	log.Trace().Interface("query", query).Msg("running query")
-	rows, err := conn.QueryContext(context.Background(), string(query))
+	rows, err := conn.QueryContext(ctx, string(query))
 	if err != nil {
 		log.Error().Err(err).Interface("query", query).Msg("could not run query")
 		return nil, err
@@ -58,9 +126,12 @@ func RunSQLQuery(conn *sql.Conn, query SQL) (*sql.Rows, error) {
 	return rows, nil
 }
 
-func processRow(columns []string, vals []interface{}) QueryResult {
+// processRow converts a scanned row into a QueryResult, and returns false if nulls drops the row.
+func processRow(columns []string, columnTypes []string, vals []interface{}, resultColumns ResultColumns, nulls NullPolicy) (QueryResult, bool) {
+	numericColumns := resultColumns.all()
 	queryResult := QueryResult{
-		Labels: make(map[string]string),
+		Labels:  make(map[string]string),
+		Columns: make(map[string]float64, len(numericColumns)),
 	}
 
 	for i := range vals {
@@ -72,39 +143,53 @@ func processRow(columns []string, vals []interface{}) QueryResult {
 		}
 
 		value := *valuePtr
-		if isValueColumn(columnName) {
+		switch {
+		case resultColumns.Timestamp != "" && columnName == resultColumns.Timestamp:
+			queryResult.Timestamp = toTime(value)
+		case slices.Contains(resultColumns.Exemplar, columnName):
+			if value != nil {
+				if queryResult.Exemplar == nil {
+					queryResult.Exemplar = make(map[string]string, len(resultColumns.Exemplar))
+				}
+				queryResult.Exemplar[columnName] = renderColumnLabel(value, columnTypes[i])
+			}
+		case slices.Contains(numericColumns, columnName):
+			if value == nil && !nulls.keepsNullValues() {
+				return queryResult, false
+			}
+			queryResult.Columns[columnName] = toFloat64(value)
+		case len(resultColumns.Values) == 0 && !resultColumns.Valueless && isValueColumn(columnName):
+			if value == nil && !nulls.keepsNullValues() {
+				return queryResult, false
+			}
 			queryResult.Value = toFloat64(value)
-		} else {
-			strValue := convertToString(columnName, value)
-			queryResult.Labels[columnName] = strValue
+		case value == nil:
+			label, keep := nulls.nullLabel()
+			if !keep {
+				return queryResult, false
+			}
+			queryResult.Labels[columnName] = label
+		default:
+			queryResult.Labels[columnName] = renderColumnLabel(value, columnTypes[i])
 		}
 	}
 
-	return queryResult
+	return queryResult, true
 }
 
 func isValueColumn(columnName string) bool {
 	return columnName == "value" || columnName == "val" || columnName == "v" || isFunctionColumn(columnName)
 }
 
-func convertToString(columnName string, value interface{}) string {
-	if value == nil {
-		return "NULL"
-	}
-
-	strValue, ok := value.(string)
-	if !ok {
-		log.Warn().
-			Str("column", columnName).
-			Interface("value", value).
-			Msg("converting non-string value to string")
-		strValue = fmt.Sprintf("%v", value)
-	}
-	return strValue
+func Materialize(ctx context.Context, conn *sql.Conn, query SQL) ([]QueryResult, error) {
+	return MaterializeWithColumns(ctx, conn, query, ResultColumns{}, NullPolicy{})
 }
 
-func Materialize(conn *sql.Conn, query SQL) ([]QueryResult, error) {
-	rows, err := RunSQLQuery(conn, query)
+// MaterializeWithColumns runs query like Materialize, reading the selected columns into
+// QueryResult.Columns instead of treating them as labels, and handling NULLs according to nulls.
+// It is an error for the query not to return one of the selected columns.
+func MaterializeWithColumns(ctx context.Context, conn *sql.Conn, query SQL, resultColumns ResultColumns, nulls NullPolicy) ([]QueryResult, error) {
+	rows, err := RunSQLQueryContext(ctx, conn, query)
 	if err != nil {
 		return nil, err
 	}
@@ -119,6 +204,16 @@ func Materialize(conn *sql.Conn, query SQL) ([]QueryResult, error) {
 		log.Error().Err(err).Msg("could not get columns")
 		return nil, err
 	}
+	for _, column := range resultColumns.required() {
+		if !slices.Contains(columns, column) {
+			return nil, fmt.Errorf("query did not return a %s column", column)
+		}
+	}
+	columnTypes, err := databaseTypeNames(rows)
+	if err != nil {
+		log.Error().Err(err).Msg("could not get column types")
+		return nil, err
+	}
 
 	// Initialize values as interface{} pointers
 	vals := prepareValueSlice(len(columns))
@@ -131,7 +226,11 @@ func Materialize(conn *sql.Conn, query SQL) ([]QueryResult, error) {
 			continue // Skip this row and continue with the next one
 		}
 
-		queryResult := processRow(columns, vals)
+		queryResult, keep := processRow(columns, columnTypes, vals, resultColumns, nulls)
+		if !keep {
+			log.Debug().Interface("query", query).Msg("dropping row with null")
+			continue
+		}
 		queryResults = append(queryResults, queryResult)
 	}
 
@@ -145,6 +244,18 @@ func Materialize(conn *sql.Conn, query SQL) ([]QueryResult, error) {
 	return queryResults, nil
 }
 
+func databaseTypeNames(rows *sql.Rows) ([]string, error) {
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(columnTypes))
+	for i, columnType := range columnTypes {
+		names[i] = columnType.DatabaseTypeName()
+	}
+	return names, nil
+}
+
 func prepareValueSlice(columnCount int) []interface{} {
 	vals := make([]interface{}, columnCount)
 	for i := range vals {
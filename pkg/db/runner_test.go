@@ -3,6 +3,7 @@ package db_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/jakthom/hercules/pkg/db"
@@ -25,3 +26,85 @@ func TestRunSqlQuery(t *testing.T) {
 	// Check rows.Err
 	assert.NoError(t, rows.Err())
 }
+
+func nullRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"user", "value"}).
+		AddRow("a", 1).
+		AddRow(nil, 2).
+		AddRow("c", nil)
+}
+
+func TestMaterializeWithColumns_NullPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		nulls    db.NullPolicy
+		expected []db.QueryResult
+	}{
+		{
+			name:  "Defaults",
+			nulls: db.NullPolicy{},
+			expected: []db.QueryResult{
+				{Value: 1, Labels: map[string]string{"user": "a"}, Columns: map[string]float64{}},
+				{Value: 2, Labels: map[string]string{"user": ""}, Columns: map[string]float64{}},
+				{Value: 0, Labels: map[string]string{"user": "c"}, Columns: map[string]float64{}},
+			},
+		},
+		{
+			name:  "Drop labels and skip values",
+			nulls: db.NullPolicy{Labels: db.NullLabelDrop, Values: db.NullValueSkip},
+			expected: []db.QueryResult{
+				{Value: 1, Labels: map[string]string{"user": "a"}, Columns: map[string]float64{}},
+			},
+		},
+		{
+			name:  "Replace labels",
+			nulls: db.NullPolicy{Labels: db.NullLabelReplace, Replacement: "unknown", Values: db.NullValueSkip},
+			expected: []db.QueryResult{
+				{Value: 1, Labels: map[string]string{"user": "a"}, Columns: map[string]float64{}},
+				{Value: 2, Labels: map[string]string{"user": "unknown"}, Columns: map[string]float64{}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, mock, _ := testutil.GetMockedConnection()
+			mock.ExpectQuery("select nulls").WillReturnRows(nullRows())
+
+			results, err := db.MaterializeWithColumns(t.Context(), conn, "select nulls", db.ResultColumns{}, tt.nulls)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, results)
+		})
+	}
+}
+
+func TestNullPolicy_Validate(t *testing.T) {
+	require.NoError(t, db.NullPolicy{}.Validate())
+	require.NoError(t, db.NullPolicy{Labels: db.NullLabelReplace, Replacement: "unknown", Values: db.NullValueSkip}.Validate())
+
+	err := db.NullPolicy{Labels: db.NullLabelReplace, Values: "ignore"}.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "requires a replacement")
+	assert.Contains(t, err.Error(), "unsupported null value policy ignore")
+}
+
+func TestMaterializeWithColumns_Timestamp(t *testing.T) {
+	conn, mock, _ := testutil.GetMockedConnection()
+	measured := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("select timestamps").WillReturnRows(sqlmock.NewRows([]string{"user", "ts", "value"}).
+		AddRow("a", measured, 1).
+		AddRow("b", 1719835200.5, 2).
+		AddRow("c", nil, 3))
+
+	results, err := db.MaterializeWithColumns(t.Context(), conn, "select timestamps", db.ResultColumns{Timestamp: "ts"}, db.NullPolicy{})
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	assert.Equal(t, map[string]string{"user": "a"}, results[0].Labels)
+	assert.True(t, measured.Equal(results[0].Timestamp))
+	assert.Equal(t, measured.Add(500*time.Millisecond).UnixMilli(), results[1].Timestamp.UnixMilli())
+	assert.True(t, results[2].Timestamp.IsZero())
+
+	mock.ExpectQuery("select timestamps").WillReturnRows(sqlmock.NewRows([]string{"user", "value"}).AddRow("a", 1))
+	_, err = db.MaterializeWithColumns(t.Context(), conn, "select timestamps", db.ResultColumns{Timestamp: "ts"}, db.NullPolicy{})
+	assert.ErrorContains(t, err, "query did not return a ts column")
+}
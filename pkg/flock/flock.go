@@ -1,26 +1,66 @@
 package flock
 
 import (
-	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 
 	"github.com/jakthom/hercules/pkg/config"
 	"github.com/marcboeker/go-duckdb/v2"
 	"github.com/rs/zerolog/log"
 )
 
-func InitializeDB(conf config.Config) (*sql.DB, *sql.Conn) {
+// ConnectionManager hands out pooled connections to a single DuckDB database. Source refreshes
+// and other writes draw from one pool while metric queries draw from another, so long-running
+// refreshes never starve scrapes (and vice versa). Every caller checks out its own *sql.Conn
+// and returns it to the pool by closing it; connections are never shared between goroutines.
+type ConnectionManager struct {
+	connector *duckdb.Connector
+	write     *sql.DB
+	read      *sql.DB
+}
+
+// sharedConnector hides the connector's Close method from database/sql, which would otherwise
+// tear down the database as soon as the first pool is closed.
+type sharedConnector struct {
+	driver.Connector
+}
+
+func InitializeDB(conf config.Config) *ConnectionManager {
 	// Open a connection to DuckDB using the new API
 	connector, err := duckdb.NewConnector(conf.DB, nil)
 	if err != nil {
 		log.Fatal().Err(err).Msg("could not initialize duckdb database")
 	}
 
-	db := sql.OpenDB(connector)
-	conn, err := db.Conn(context.Background())
-	if err != nil {
-		log.Fatal().Err(err).Msg("could not initialize duckdb connection")
+	return &ConnectionManager{
+		connector: connector,
+		write:     openPool(connector, conf.Connections.Write, config.DefaultWriteConnections),
+		read:      openPool(connector, conf.Connections.Read, config.DefaultReadConnections),
+	}
+}
+
+func openPool(connector *duckdb.Connector, size int, defaultSize int) *sql.DB {
+	if size <= 0 {
+		size = defaultSize
 	}
-	defer db.Close()
-	return db, conn
+	pool := sql.OpenDB(sharedConnector{connector})
+	pool.SetMaxOpenConns(size)
+	pool.SetMaxIdleConns(size)
+	return pool
+}
+
+// Writer returns the pool used to refresh sources and ensure extensions and macros.
+func (cm *ConnectionManager) Writer() *sql.DB {
+	return cm.write
+}
+
+// Reader returns the pool used to run metric queries.
+func (cm *ConnectionManager) Reader() *sql.DB {
+	return cm.read
+}
+
+// Close closes both pools and then the underlying database.
+func (cm *ConnectionManager) Close() error {
+	return errors.Join(cm.read.Close(), cm.write.Close(), cm.connector.Close())
 }
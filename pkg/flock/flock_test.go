@@ -2,15 +2,44 @@
 package flock_test
 
 import (
+	"sync"
 	"testing"
 
 	"github.com/jakthom/hercules/pkg/config"
 	"github.com/jakthom/hercules/pkg/flock"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestInitializeDB(t *testing.T) {
-	db, conn := flock.InitializeDB(config.Config{})
-	assert.NotNil(t, db)
-	assert.NotNil(t, conn)
+	cm := flock.InitializeDB(config.Config{})
+	assert.NotNil(t, cm.Writer())
+	assert.NotNil(t, cm.Reader())
+	assert.NoError(t, cm.Close())
+}
+
+func TestConnectionManager_PoolsShareDatabase(t *testing.T) {
+	cm := flock.InitializeDB(config.Config{})
+	defer cm.Close()
+
+	_, err := cm.Writer().ExecContext(t.Context(), "create table shared as select 42 as answer")
+	require.NoError(t, err)
+
+	// Concurrent readers each check out their own connection and see the writer's table
+	var wg sync.WaitGroup
+	for range config.DefaultReadConnections * 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, connErr := cm.Reader().Conn(t.Context())
+			if !assert.NoError(t, connErr) {
+				return
+			}
+			defer conn.Close()
+			var answer int
+			assert.NoError(t, conn.QueryRowContext(t.Context(), "select answer from shared").Scan(&answer))
+			assert.Equal(t, 42, answer)
+		}()
+	}
+	wg.Wait()
 }
@@ -0,0 +1,270 @@
+package herculespackage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+
+	"github.com/jakthom/hercules/pkg/db"
+	"github.com/jakthom/hercules/pkg/metric"
+	"github.com/jakthom/hercules/pkg/source"
+	"github.com/rs/zerolog/log"
+)
+
+// Changes names the items added, changed, and removed between two versions of a package.
+type Changes struct {
+	Added   []string `json:"added,omitempty"`
+	Changed []string `json:"changed,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+// Empty reports whether nothing was added, changed, or removed.
+func (c Changes) Empty() bool {
+	return len(c.Added) == 0 && len(c.Changed) == 0 && len(c.Removed) == 0
+}
+
+// Diff describes how a package changed between two loads. Metrics are named by type and name,
+// such as gauge/query_count.
+type Diff struct {
+	Extensions Changes `json:"extensions"`
+	Macros     Changes `json:"macros"`
+	Sources    Changes `json:"sources"`
+	Metrics    Changes `json:"metrics"`
+}
+
+// Empty reports whether the package is unchanged.
+func (d Diff) Empty() bool {
+	return d.Extensions.Empty() && d.Macros.Empty() && d.Sources.Empty() && d.Metrics.Empty()
+}
+
+// diff compares previous and next by key. Added and changed items are listed in the order of
+// next, removed items in the order of previous.
+func diff[T any](previous []T, next []T, key func(T) string, equal func(T, T) bool) Changes {
+	var changes Changes
+	previousByKey := make(map[string]T, len(previous))
+	for _, item := range previous {
+		previousByKey[key(item)] = item
+	}
+	nextKeys := make(map[string]bool, len(next))
+	for _, item := range next {
+		k := key(item)
+		nextKeys[k] = true
+		old, ok := previousByKey[k]
+		switch {
+		case !ok:
+			changes.Added = append(changes.Added, k)
+		case !equal(old, item):
+			changes.Changed = append(changes.Changed, k)
+		}
+	}
+	for _, item := range previous {
+		if k := key(item); !nextKeys[k] {
+			changes.Removed = append(changes.Removed, k)
+		}
+	}
+	return changes
+}
+
+func deepEqual[T any](a T, b T) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+type extension struct {
+	Type string
+	Name string
+}
+
+func extensions(e db.Extensions) []extension {
+	var all []extension
+	for _, core := range e.Core {
+		all = append(all, extension{Type: db.CoreExtensionType, Name: core.Name})
+	}
+	for _, community := range e.Community {
+		all = append(all, extension{Type: db.CommunityExtensionType, Name: community.Name})
+	}
+	return all
+}
+
+func extensionKey(e extension) string {
+	return e.Type + "/" + e.Name
+}
+
+// macroKey identifies a macro by its name, falling back to its SQL for unnamed macros.
+func macroKey(m db.Macro) string {
+	if m.Name != "" {
+		return m.Name
+	}
+	return string(m.SQL)
+}
+
+func sourceKey(s source.Source) string {
+	return s.Name
+}
+
+func sourceEqual(a source.Source, b source.Source) bool {
+	return a.Equal(&b)
+}
+
+func metricKey(d metric.TypedDefinition) string {
+	return string(d.Type) + "/" + d.Definition.Name
+}
+
+func metricEqual(a metric.TypedDefinition, b metric.TypedDefinition) bool {
+	return reflect.DeepEqual(a.Definition, b.Definition)
+}
+
+// DiffPackages compares the extensions, macros, sources, and metrics of two versions of a package.
+func DiffPackages(previous *Package, next *Package) Diff {
+	return Diff{
+		Extensions: diff(extensions(previous.Extensions), extensions(next.Extensions), extensionKey, deepEqual[extension]),
+		Macros:     diff(previous.Macros, next.Macros, macroKey, deepEqual[db.Macro]),
+		Sources:    diff(previous.Sources, next.Sources, sourceKey, sourceEqual),
+		Metrics:    diff(previous.Metrics.Typed(), next.Metrics.Typed(), metricKey, metricEqual),
+	}
+}
+
+// Reload initializes p alongside previous, a running version of the same package, applying only
+// what changed: added extensions and added or changed macros are ensured, and added and changed
+// sources are refreshed. Unchanged sources are carried over from previous without being
+// refreshed. Metadata is injected into every metric of p, after which its metrics are compared to
+// those of previous. The returned diff describes every change.
+//
+// Previous keeps running until Replace is called, so that the reload of every package can succeed
+// before any is applied. If the reload fails, it is discarded, restoring the macros and sources
+// of previous.
+func (p *Package) Reload(previous *Package, pool *sql.DB) (Diff, error) {
+	d := DiffPackages(previous, p)
+	err := p.reload(previous, pool, d)
+	if err != nil {
+		log.Error().Err(err).Interface("package", p.Name).Msg("could not reload package")
+		if discardErr := p.Discard(previous, pool); discardErr != nil {
+			log.Error().Err(discardErr).Interface("package", p.Name).Msg("could not restore package")
+		}
+	}
+	d.Metrics = diff(previous.Metrics.Typed(), p.Metrics.Typed(), metricKey, metricEqual)
+	return d, err
+}
+
+func (p *Package) reload(previous *Package, pool *sql.DB, d Diff) error {
+	if len(d.Extensions.Added) > 0 || len(d.Macros.Added) > 0 || len(d.Macros.Changed) > 0 {
+		err := withConn(pool, func(conn *sql.Conn) error {
+			if err := db.EnsureExtensionsWithConnection(added(p.Extensions, d.Extensions), conn); err != nil {
+				return err
+			}
+			var macros []db.Macro
+			for _, macro := range p.Macros {
+				if k := macroKey(macro); slices.Contains(d.Macros.Added, k) || slices.Contains(d.Macros.Changed, k) {
+					macros = append(macros, macro)
+				}
+			}
+			return db.EnsureMacrosWithConnection(macros, conn)
+		})
+		if err != nil {
+			return fmt.Errorf("could not ensure extensions and macros for package %s: %w", p.Name, err)
+		}
+	}
+
+	for i := range p.Sources {
+		s := &p.Sources[i]
+		if old := previous.source(s.Name); old != nil && old.Equal(s) {
+			*s = *old
+			continue
+		}
+		if err := s.Initialize(pool); err != nil {
+			return err
+		}
+	}
+
+	if err := withConn(pool, func(conn *sql.Conn) error {
+		return p.Metrics.InjectMetadata(conn, p.Metadata)
+	}); err != nil {
+		return fmt.Errorf("could not inject metadata for package %s: %w", p.Name, err)
+	}
+	return nil
+}
+
+// Replace retires previous once p has been reloaded in its place: the previous versions of
+// changed sources stop refreshing, and removed sources stop refreshing and exporting their
+// refresh timings.
+func (p *Package) Replace(previous *Package) {
+	for i := range previous.Sources {
+		old := &previous.Sources[i]
+		switch s := p.source(old.Name); {
+		case s == nil:
+			old.Remove()
+		case !s.Equal(old):
+			old.Cleanup()
+		}
+	}
+}
+
+// Discard undoes the reload of p in place of previous: the sources p started stop refreshing, and
+// the previous definitions of changed macros and sources are re-created, as the running metrics of
+// previous query them. Sources carried over from previous keep running. The extensions and macros
+// p added, and the tables and views of the sources it added, are left in the database.
+func (p *Package) Discard(previous *Package, pool *sql.DB) error {
+	d := DiffPackages(previous, p)
+	var errs []error
+	var macros []db.Macro
+	for _, macro := range previous.Macros {
+		if slices.Contains(d.Macros.Changed, macroKey(macro)) {
+			macros = append(macros, macro)
+		}
+	}
+	if len(macros) > 0 {
+		if err := withConn(pool, func(conn *sql.Conn) error {
+			return db.EnsureMacrosWithConnection(macros, conn)
+		}); err != nil {
+			errs = append(errs, fmt.Errorf("could not restore macros for package %s: %w", p.Name, err))
+		}
+	}
+	for i := range p.Sources {
+		s := &p.Sources[i]
+		switch old := previous.source(s.Name); {
+		case old == nil:
+			s.Remove()
+		case !old.Equal(s):
+			s.Cleanup()
+			if err := old.Refresh(pool); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// source returns the source of the package named name, or nil if there is none.
+func (p *Package) source(name string) *source.Source {
+	for i := range p.Sources {
+		if p.Sources[i].Name == name {
+			return &p.Sources[i]
+		}
+	}
+	return nil
+}
+
+// Cleanup stops the background refresh of every source in the package, and stops exporting
+// their refresh timings.
+func (p *Package) Cleanup() {
+	for i := range p.Sources {
+		p.Sources[i].Remove()
+	}
+}
+
+// added returns the extensions of e that changes lists as added.
+func added(e db.Extensions, changes Changes) db.Extensions {
+	var a db.Extensions
+	for _, core := range e.Core {
+		if slices.Contains(changes.Added, extensionKey(extension{Type: db.CoreExtensionType, Name: core.Name})) {
+			a.Core = append(a.Core, core)
+		}
+	}
+	for _, community := range e.Community {
+		if slices.Contains(changes.Added, extensionKey(extension{Type: db.CommunityExtensionType, Name: community.Name})) {
+			a.Community = append(a.Community, community)
+		}
+	}
+	return a
+}
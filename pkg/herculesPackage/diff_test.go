@@ -0,0 +1,157 @@
+// Package herculespackage_test contains tests for the herculespackage package
+package herculespackage_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jakthom/hercules/pkg/db"
+	herculespackage "github.com/jakthom/hercules/pkg/herculesPackage"
+	"github.com/jakthom/hercules/pkg/metric"
+	"github.com/jakthom/hercules/pkg/source"
+	"github.com/jakthom/hercules/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffPackages(t *testing.T) {
+	previous := createMinimalTestPackage()
+	previous.Sources = append(previous.Sources, source.Source{Name: "removed_source", Type: "sql", Source: "SELECT 2"})
+
+	next := createMinimalTestPackage()
+	next.Extensions.Core = []db.CoreExtension{{Name: "httpfs"}}
+	next.Macros[0].SQL = "test_macro() AS (SELECT 2)"
+	next.Sources[0].RefreshIntervalSeconds = 30
+	next.Metrics.Gauge = append(next.Metrics.Gauge, &metric.Definition{Name: "added_gauge", SQL: "SELECT 2"})
+	next.Metrics.Counter = []*metric.Definition{{Name: "test_gauge", SQL: "SELECT 1"}}
+
+	diff := herculespackage.DiffPackages(&previous, &next)
+
+	assert.Equal(t, herculespackage.Diff{
+		Extensions: herculespackage.Changes{Added: []string{"core/httpfs"}},
+		Macros:     herculespackage.Changes{Changed: []string{"test_macro"}},
+		Sources:    herculespackage.Changes{Changed: []string{"test_source"}, Removed: []string{"removed_source"}},
+		Metrics:    herculespackage.Changes{Added: []string{"gauge/added_gauge", "counter/test_gauge"}},
+	}, diff)
+	assert.False(t, diff.Empty())
+
+	reloaded, unchanged := createMinimalTestPackage(), createMinimalTestPackage()
+	assert.True(t, herculespackage.DiffPackages(&reloaded, &unchanged).Empty())
+}
+
+func TestPackage_Reload(t *testing.T) {
+	previous := herculespackage.Package{
+		Name: "reload",
+		Sources: []source.Source{
+			{Name: "unchanged", Type: "sql", Source: "SELECT 1"},
+			{Name: "changed", Type: "sql", Source: "SELECT 2"},
+			{Name: "removed", Type: "sql", Source: "SELECT 3"},
+		},
+	}
+	next := herculespackage.Package{
+		Name: "reload",
+		Macros: []db.Macro{
+			{Name: "added", SQL: "added() AS (SELECT 1)"},
+		},
+		Sources: []source.Source{
+			{Name: "unchanged", Type: "sql", Source: "SELECT 1"},
+			{Name: "changed", Type: "sql", Source: "SELECT 20"},
+			{Name: "added", Type: "sql", Source: "SELECT 4"},
+		},
+	}
+
+	pool, mock, _ := testutil.GetMockedPool()
+	// Only the added macro and the changed and added sources are applied
+	mock.ExpectQuery("create or replace macro added() AS (SELECT 1)").
+		WillReturnRows(sqlmock.NewRows([]string{"result"}))
+	mock.ExpectQuery("create or replace view changed as SELECT 20;").
+		WillReturnRows(sqlmock.NewRows([]string{"result"}))
+	mock.ExpectQuery("create or replace view added as SELECT 4;").
+		WillReturnRows(sqlmock.NewRows([]string{"result"}))
+
+	diff, err := next.Reload(&previous, pool)
+	require.NoError(t, err)
+	assert.Equal(t, herculespackage.Changes{Added: []string{"added"}}, diff.Macros)
+	assert.Equal(t, herculespackage.Changes{
+		Added:   []string{"added"},
+		Changed: []string{"changed"},
+		Removed: []string{"removed"},
+	}, diff.Sources)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPackage_ReloadRejectsFailures(t *testing.T) {
+	previous := herculespackage.Package{
+		Name:    "reload",
+		Sources: []source.Source{{Name: "changed", Type: "sql", Source: "SELECT 2"}},
+	}
+
+	t.Run("macro", func(t *testing.T) {
+		next := herculespackage.Package{
+			Name:    "reload",
+			Macros:  []db.Macro{{Name: "broken", SQL: "broken() AS (SELEC 1)"}},
+			Sources: previous.Sources,
+		}
+		pool, mock, _ := testutil.GetMockedPool()
+		mock.ExpectQuery("create or replace macro broken() AS (SELEC 1)").
+			WillReturnError(errors.New("syntax error"))
+
+		_, err := next.Reload(&previous, pool)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "syntax error")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("source", func(t *testing.T) {
+		next := herculespackage.Package{
+			Name: "reload",
+			Sources: []source.Source{
+				{Name: "added", Type: "sql", Source: "SELECT 1"},
+				{Name: "changed", Type: "sql", Source: "SELECT 20"},
+			},
+		}
+		pool, mock, _ := testutil.GetMockedPool()
+		mock.ExpectQuery("create or replace view added as SELECT 1;").
+			WillReturnRows(sqlmock.NewRows([]string{"result"}))
+		mock.ExpectQuery("create or replace view changed as SELECT 20;").
+			WillReturnError(errors.New("table does not exist"))
+		// The changed view is restored for the running metrics
+		mock.ExpectQuery("create or replace view changed as SELECT 2;").
+			WillReturnRows(sqlmock.NewRows([]string{"result"}))
+
+		_, err := next.Reload(&previous, pool)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "table does not exist")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestPackage_Discard(t *testing.T) {
+	previous := herculespackage.Package{
+		Name:    "reload",
+		Macros:  []db.Macro{{Name: "changed", SQL: "changed() AS (SELECT 1)"}},
+		Sources: []source.Source{{Name: "changed", Type: "sql", Source: "SELECT 2"}},
+	}
+	next := herculespackage.Package{
+		Name:    "reload",
+		Macros:  []db.Macro{{Name: "changed", SQL: "changed() AS (SELECT 10)"}},
+		Sources: []source.Source{{Name: "changed", Type: "sql", Source: "SELECT 20"}},
+	}
+
+	pool, mock, _ := testutil.GetMockedPool()
+	mock.ExpectQuery("create or replace macro changed() AS (SELECT 10)").
+		WillReturnRows(sqlmock.NewRows([]string{"result"}))
+	mock.ExpectQuery("create or replace view changed as SELECT 20;").
+		WillReturnRows(sqlmock.NewRows([]string{"result"}))
+	_, err := next.Reload(&previous, pool)
+	require.NoError(t, err)
+
+	// A later package failed to reload, so the running definitions are re-created
+	mock.ExpectQuery("create or replace macro changed() AS (SELECT 1)").
+		WillReturnRows(sqlmock.NewRows([]string{"result"}))
+	mock.ExpectQuery("create or replace view changed as SELECT 2;").
+		WillReturnRows(sqlmock.NewRows([]string{"result"}))
+	require.NoError(t, next.Discard(&previous, pool))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
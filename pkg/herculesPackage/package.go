@@ -8,12 +8,14 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/jakthom/hercules/pkg/db"
 	"github.com/jakthom/hercules/pkg/metric"
 	"github.com/jakthom/hercules/pkg/source"
+	"github.com/jakthom/hercules/pkg/telemetry"
 	herculestypes "github.com/jakthom/hercules/pkg/types"
 	"github.com/rs/zerolog/log"
 	"golang.org/x/sync/errgroup"
@@ -28,7 +30,7 @@ const (
 	// MaxConcurrentPackageInit is the maximum number of packages to initialize concurrently.
 	MaxConcurrentPackageInit = 4
 	// ErrorChannelSize is the size of the error channel buffer for package initialization.
-	ErrorChannelSize = 3
+	ErrorChannelSize = 4
 )
 
 // HTTP request timeout constants.
@@ -41,24 +43,71 @@ const (
 // It can be downloaded from remote sources or shipped alongside hercules.
 
 type Package struct {
-	Name         herculestypes.PackageName  `json:"name"`
-	Version      string                     `json:"version"`
-	Variables    Variables                  `json:"variables"`
-	Extensions   db.Extensions              `json:"extensions"`
-	Macros       []db.Macro                 `json:"macros"`
-	Sources      []source.Source            `json:"sources"`
-	Metrics      metric.Definitions         `json:"metrics"`
-	MetricPrefix herculestypes.MetricPrefix `json:"-"`
-	Metadata     metric.Metadata            `json:"metadata"`
+	Name                       herculestypes.PackageName  `json:"name"`
+	Version                    string                     `json:"version"`
+	Variables                  Variables                  `json:"-"` // Resolved from the package config.
+	VariableDeclarations       VariableDeclarations       `json:"variables"`
+	Extensions                 db.Extensions              `json:"extensions"`
+	Macros                     []db.Macro                 `json:"macros"`
+	Sources                    []source.Source            `json:"sources"`
+	Metrics                    metric.Definitions         `json:"metrics"`
+	MetricPrefix               herculestypes.MetricPrefix `json:"-"`
+	MaterializeIntervalSeconds int                        `json:"-"`
+	Metadata                   metric.Metadata            `json:"metadata"`
+	Location                   string                     `json:"-"` // The file or endpoint the package was loaded from.
+	Tests                      []Test                     `json:"tests,omitempty"`
 	// TODO -> Package-level secrets
 }
 
-// InitializePackagesWithConnection initializes multiple packages in parallel
+// RenderTemplates substitutes the package variables into source locations, macro SQL, and metric SQL.
+func (p *Package) RenderTemplates() error {
+	var errs []error
+	for i := range p.Sources {
+		rendered, err := p.Variables.render("source "+p.Sources[i].Name, p.Sources[i].Source)
+		errs = append(errs, err)
+		p.Sources[i].Source = rendered
+	}
+	for i := range p.Macros {
+		rendered, err := p.Variables.render("macro "+p.Macros[i].Name, string(p.Macros[i].SQL))
+		errs = append(errs, err)
+		p.Macros[i].SQL = db.SQL(rendered)
+	}
+	for _, definitions := range [][]*metric.Definition{
+		p.Metrics.Gauge, p.Metrics.Counter, p.Metrics.Summary, p.Metrics.Histogram, p.Metrics.Info, p.Metrics.StateSet,
+	} {
+		for _, definition := range definitions {
+			rendered, err := p.Variables.render("metric "+definition.Name, string(definition.SQL))
+			errs = append(errs, err)
+			definition.SQL = db.SQL(rendered)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// DefaultTimeouts sets the timeout of every source and metric in the package that does not
+// configure its own.
+func (p *Package) DefaultTimeouts(sourceTimeout time.Duration, queryTimeout time.Duration) {
+	for i := range p.Sources {
+		if p.Sources[i].Timeout <= 0 {
+			p.Sources[i].Timeout = herculestypes.Duration(sourceTimeout)
+		}
+	}
+	p.Metrics.DefaultTimeout(queryTimeout)
+}
+
+// Instrument exports refresh timings for every source in the package to t.
+func (p *Package) Instrument(t *telemetry.Telemetry) {
+	for i := range p.Sources {
+		p.Sources[i].Instrument(string(p.Name), t)
+	}
+}
+
+// InitializePackagesWithPool initializes multiple packages in parallel
 // for better performance when starting up with many packages.
-func InitializePackagesWithConnection(packages []Package, conn *sql.Conn) error {
+func InitializePackagesWithPool(packages []Package, pool *sql.DB) error {
 	// Use errgroup to handle concurrent initialization with error handling.
 	g := new(errgroup.Group)
-	// Limit concurrency to avoid overwhelming the database connection.
+	// Limit concurrency to avoid overwhelming the connection pool.
 	sem := make(chan struct{}, MaxConcurrentPackageInit)
 
 	for i := range packages {
@@ -68,7 +117,7 @@ func InitializePackagesWithConnection(packages []Package, conn *sql.Conn) error
 			sem <- struct{}{}
 			defer func() { <-sem }()
 
-			return pkg.InitializeWithConnection(conn)
+			return pkg.InitializeWithPool(pool)
 		})
 	}
 
@@ -76,7 +125,19 @@ func InitializePackagesWithConnection(packages []Package, conn *sql.Conn) error
 	return g.Wait()
 }
 
-func (p *Package) InitializeWithConnection(conn *sql.Conn) error {
+// withConn checks out a connection from the pool for the duration of fn.
+func withConn(pool *sql.DB, fn func(conn *sql.Conn) error) error {
+	conn, err := pool.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return fn(conn)
+}
+
+// InitializeWithPool ensures the package's extensions, macros, and sources and injects metadata
+// into its metrics. Every concurrent step checks out its own connection from the pool.
+func (p *Package) InitializeWithPool(pool *sql.DB) error {
 	if len(p.Name) == 0 {
 		log.Trace().Msg("empty package detected - skipping initialization")
 		return nil
@@ -84,7 +145,7 @@ func (p *Package) InitializeWithConnection(conn *sql.Conn) error {
 
 	log.Info().Interface("package", p.Name).Msg("initializing " + string(p.Name) + " package")
 
-	// Create a wait group for concurrent operations that don't return errors.
+	// Create a wait group for concurrent operations.
 	var wg sync.WaitGroup
 
 	// Error channel for collecting errors from goroutines.
@@ -94,19 +155,32 @@ func (p *Package) InitializeWithConnection(conn *sql.Conn) error {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		db.EnsureExtensionsWithConnection(p.Extensions, conn)
+		if err := withConn(pool, func(conn *sql.Conn) error {
+			return db.EnsureExtensionsWithConnection(p.Extensions, conn)
+		}); err != nil {
+			errChan <- fmt.Errorf("could not ensure extensions for package %s: %w", p.Name, err)
+		}
 	}()
 
 	// Ensure macros.
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		db.EnsureMacrosWithConnection(p.Macros, conn)
+		if err := withConn(pool, func(conn *sql.Conn) error {
+			return db.EnsureMacrosWithConnection(p.Macros, conn)
+		}); err != nil {
+			errChan <- fmt.Errorf("could not ensure macros for package %s: %w", p.Name, err)
+		}
 	}()
 
+	// Sources may depend on extensions and macros, so they are only initialized once both are ensured.
+	wg.Wait()
+
 	// Ensure sources (this can return an error).
+	wg.Add(1)
 	go func() {
-		err := source.InitializeSourcesWithConnection(p.Sources, conn)
+		defer wg.Done()
+		err := source.InitializeSourcesWithPool(p.Sources, pool)
 		if err != nil {
 			errChan <- fmt.Errorf("could not initialize sources for package %s: %w", p.Name, err)
 		}
@@ -116,7 +190,9 @@ func (p *Package) InitializeWithConnection(conn *sql.Conn) error {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		if err := p.Metrics.InjectMetadata(conn, p.Metadata); err != nil {
+		if err := withConn(pool, func(conn *sql.Conn) error {
+			return p.Metrics.InjectMetadata(conn, p.Metadata)
+		}); err != nil {
 			errChan <- fmt.Errorf("could not inject metadata for package %s: %w", p.Name, err)
 		}
 	}()
@@ -138,9 +214,12 @@ func (p *Package) InitializeWithConnection(conn *sql.Conn) error {
 }
 
 type PackageConfig struct {
-	Package      string                     `json:"package"`
-	Variables    Variables                  `json:"variables"`
-	MetricPrefix herculestypes.MetricPrefix `json:"metricPrefix"`
+	Package                    string                     `json:"package"`
+	Variables                  Variables                  `json:"variables"`
+	MetricPrefix               herculestypes.MetricPrefix `json:"metricPrefix"`
+	MaterializeIntervalSeconds int                        `json:"materializeIntervalSeconds"`
+	EnabledMetrics             []string                   `json:"enabledMetrics"`  // Metrics to enable regardless of the package definition.
+	DisabledMetrics            []string                   `json:"disabledMetrics"` // Metrics to disable regardless of the package definition.
 }
 
 func (p *PackageConfig) getFromFile() (Package, error) {
@@ -149,6 +228,7 @@ func (p *PackageConfig) getFromFile() (Package, error) {
 	yamlFile, err := os.ReadFile(p.Package)
 	if err != nil {
 		log.Error().Err(err).Msg("could not get package from file " + p.Package)
+		return pkg, err
 	}
 	err = yaml.Unmarshal(yamlFile, &pkg)
 	pkg.MetricPrefix = p.MetricPrefix
@@ -193,13 +273,39 @@ func (p *PackageConfig) getFromObjectStorage() error {
 	return err
 }
 
+// validateToggles checks that every metric enabled or disabled by name is defined by pkg.
+func (p *PackageConfig) validateToggles(pkg *Package) error {
+	defined := make(map[string]bool)
+	for _, typed := range pkg.Metrics.Typed() {
+		defined[typed.Definition.Name] = true
+	}
+	var errs []error
+	for _, toggle := range []struct {
+		field string
+		names []string
+	}{
+		{"enabledMetrics", p.EnabledMetrics},
+		{"disabledMetrics", p.DisabledMetrics},
+	} {
+		for i, name := range toggle.names {
+			if !defined[name] {
+				errs = append(errs, herculestypes.FieldErrorf(fmt.Sprintf("%s[%d]", toggle.field, i), "package %s has no metric %s", pkg.Name, name))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// GetPackage loads, validates, and renders the package. Problems with the package itself are
+// attributed to the package file, and problems with how it is configured are located relative
+// to the package's configuration.
 func (p *PackageConfig) GetPackage() (Package, error) {
 	var pkg Package
 	var err error
-	switch p.Package[0:4] {
-	case "http":
+	switch {
+	case strings.HasPrefix(p.Package, "http"):
 		pkg, err = p.getFromEndpoint()
-	case "s3:/", "gcs:":
+	case strings.HasPrefix(p.Package, "s3:/"), strings.HasPrefix(p.Package, "gcs:"):
 		err = p.getFromObjectStorage()
 		return Package{}, err
 	default:
@@ -209,6 +315,16 @@ func (p *PackageConfig) GetPackage() (Package, error) {
 		log.Debug().Stack().Err(err).Msg("could not load package from location " + p.Package)
 		return Package{}, err
 	}
-	pkg.Variables = p.Variables
-	return pkg, nil
+	pkg.Location = p.Package
+	if pkg.Variables, err = pkg.VariableDeclarations.Resolve(p.Variables); err != nil {
+		return Package{}, herculestypes.Nest(err, "variables")
+	}
+	if err = pkg.RenderTemplates(); err != nil {
+		return Package{}, herculestypes.InFile(err, p.Package)
+	}
+	pkg.MaterializeIntervalSeconds = p.MaterializeIntervalSeconds
+	toggleErr := p.validateToggles(&pkg)
+	pkg.Metrics.Toggle(p.EnabledMetrics, p.DisabledMetrics)
+	// Invalid packages are still returned, so they can be checked against other packages
+	return pkg, errors.Join(herculestypes.InFile(pkg.Validate(), p.Package), toggleErr)
 }
@@ -3,6 +3,7 @@ package herculespackage_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/jakthom/hercules/pkg/db"
 	herculespackage "github.com/jakthom/hercules/pkg/herculesPackage"
@@ -22,7 +23,6 @@ func TestPackageLoadFromFile(t *testing.T) {
 	// Configure and load package
 	config := herculespackage.PackageConfig{
 		Package:      packagePath,
-		Variables:    herculespackage.Variables{"env": "test"},
 		MetricPrefix: "prefix_",
 	}
 
@@ -32,7 +32,6 @@ func TestPackageLoadFromFile(t *testing.T) {
 	// Validate core package attributes
 	assert.Equal(t, herculestypes.PackageName("snowflake"), pkg.Name)
 	assert.Equal(t, "1.0", pkg.Version)
-	assert.Equal(t, "test", pkg.Variables["env"])
 	assert.Equal(t, herculestypes.MetricPrefix("prefix_"), pkg.MetricPrefix)
 
 	// Validate macros
@@ -71,7 +70,36 @@ func TestPackageLoadFromFile(t *testing.T) {
 	assert.Contains(t, pkg.Metrics.Counter[0].Help, "The count of queries executed by user and warehouse")
 }
 
+// TestPackageConfigTogglesMetrics tests enabling and disabling package metrics from config.
+func TestPackageConfigTogglesMetrics(t *testing.T) {
+	config := herculespackage.PackageConfig{
+		Package:         "../../hercules-packages/snowflake/1.0.yml",
+		DisabledMetrics: []string{"query_status_count"},
+	}
+
+	pkg, err := config.GetPackage()
+	require.NoError(t, err)
+
+	assert.False(t, pkg.Metrics.Gauge[0].IsEnabled(), "Disabled metric should be turned off")
+	assert.True(t, pkg.Metrics.Histogram[0].IsEnabled(), "Other metrics should be untouched")
+}
+
 // TestPackageSerialization tests package serialization and deserialization.
+func TestPackage_DefaultTimeouts(t *testing.T) {
+	pkg := herculespackage.Package{
+		Sources: []source.Source{
+			{Name: "unset", Type: "sql", Source: "SELECT 1"},
+			{Name: "set", Type: "sql", Source: "SELECT 1", Timeout: herculestypes.Duration(time.Minute)},
+		},
+		Metrics: metric.Definitions{Gauge: []*metric.Definition{{Name: "gauge", SQL: "SELECT 1"}}},
+	}
+
+	pkg.DefaultTimeouts(10*time.Minute, 30*time.Second)
+	assert.Equal(t, 10*time.Minute, pkg.Sources[0].Timeout.Duration())
+	assert.Equal(t, time.Minute, pkg.Sources[1].Timeout.Duration())
+	assert.Equal(t, 30*time.Second, pkg.Metrics.Gauge[0].Timeout.Duration())
+}
+
 func TestPackageSerialization(t *testing.T) {
 	// Create test package
 	originalPkg := createMinimalTestPackage()
@@ -0,0 +1,87 @@
+package herculespackage
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/jakthom/hercules/pkg/labels"
+	"github.com/jakthom/hercules/pkg/metric"
+	"github.com/jakthom/hercules/pkg/source"
+	herculestypes "github.com/jakthom/hercules/pkg/types"
+)
+
+// Test runs a package against fixtures in place of its sources and states the metrics the package
+// is expected to produce from them.
+type Test struct {
+	Name string `json:"name"`
+	// Fixtures replace the named sources with local CSV, parquet, or JSON files, relative to the
+	// package file. The type of each source is inferred from the file extension.
+	Fixtures map[string]string   `json:"fixtures,omitempty"`
+	Metrics  []MetricExpectation `json:"metrics"`
+}
+
+// MetricExpectation states what a single metric is expected to produce. A metric with neither
+// samples nor a golden file is only expected to materialize without error.
+type MetricExpectation struct {
+	Name string `json:"name"` // The name or full name of the metric.
+	// Every sample the metric is expected to produce, in any order.
+	Samples []ExpectedSample `json:"samples,omitempty"`
+	// An exposition file, relative to the package file, the exposition of the metric must match.
+	Golden string `json:"golden,omitempty"`
+}
+
+// ExpectedSample is a sample a metric is expected to produce. Metrics are tested without a metric
+// prefix or global labels.
+type ExpectedSample struct {
+	// The full name of the sample, e.g. snowflake_query_duration_seconds_bucket. Defaults to the
+	// full name of the metric.
+	Name   string        `json:"name,omitempty"`
+	Labels labels.Labels `json:"labels,omitempty"`
+	Value  float64       `json:"value"`
+}
+
+// validateTests checks that every test of the package names sources and metrics the package has.
+func (p *Package) validateTests() error {
+	var errs []error
+	for i, test := range p.Tests {
+		path := fmt.Sprintf("tests[%d]", i)
+		if test.Name == "" {
+			errs = append(errs, herculestypes.FieldErrorf(path+".name", "name is required"))
+		}
+		for _, name := range slices.Sorted(maps.Keys(test.Fixtures)) {
+			fixturePath := path + ".fixtures." + name
+			if !slices.ContainsFunc(p.Sources, func(s source.Source) bool { return s.Name == name }) {
+				errs = append(errs, herculestypes.FieldErrorf(fixturePath, "package %s has no source %s", p.Name, name))
+			}
+			if _, ok := source.FileType(test.Fixtures[name]); !ok {
+				errs = append(errs, herculestypes.FieldErrorf(fixturePath, "fixture %q is not a CSV, parquet, or JSON file", test.Fixtures[name]))
+			}
+		}
+		for j, expectation := range test.Metrics {
+			namePath := fmt.Sprintf("%s.metrics[%d].name", path, j)
+			if expectation.Name == "" {
+				errs = append(errs, herculestypes.FieldErrorf(namePath, "name is required"))
+				continue
+			}
+			if !p.hasTestMetric(expectation.Name) {
+				errs = append(errs, herculestypes.FieldErrorf(namePath, "package %s has no metric %s", p.Name, expectation.Name))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// hasTestMetric reports whether the package has a metric exposed under name when tested, which is
+// either the name of the metric or one of its full names without a metric prefix.
+func (p *Package) hasTestMetric(name string) bool {
+	for _, typed := range p.Metrics.Typed() {
+		definition := *typed.Definition
+		definition.Metadata = metric.Metadata{PackageName: string(p.Name)}
+		if definition.Name == name || slices.Contains(definition.FullNames(), name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,177 @@
+package herculespackage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jakthom/hercules/pkg/db"
+	"github.com/jakthom/hercules/pkg/metric"
+	"github.com/jakthom/hercules/pkg/source"
+	herculestypes "github.com/jakthom/hercules/pkg/types"
+	"github.com/prometheus/common/model"
+)
+
+// Validate checks the package for settings that cannot be initialized. Every problem is reported
+// as a *herculestypes.FieldError locating the field within the package.
+func (p *Package) Validate() error {
+	var errs []error
+	// Metric names are prefixed by the package name, as in metric.Definition.FullName
+	if p.Name == "" {
+		errs = append(errs, herculestypes.FieldErrorf("name", "name is required"))
+	} else if !model.IsValidLegacyMetricName(strings.ReplaceAll(string(p.Name), "-", "_")) {
+		errs = append(errs, herculestypes.FieldErrorf("name", "%q cannot prefix metric names", p.Name))
+	}
+	for i, extension := range p.Extensions.Core {
+		if extension.Name == "" {
+			errs = append(errs, herculestypes.FieldErrorf(fmt.Sprintf("extensions.core[%d].name", i), "name is required"))
+		}
+	}
+	for i, extension := range p.Extensions.Community {
+		if extension.Name == "" {
+			errs = append(errs, herculestypes.FieldErrorf(fmt.Sprintf("extensions.community[%d].name", i), "name is required"))
+		}
+	}
+	for i, macro := range p.Macros {
+		if macro.SQL == "" {
+			errs = append(errs, herculestypes.FieldErrorf(fmt.Sprintf("macros[%d].sql", i), "sql is required"))
+		}
+	}
+	sources := make(map[string]int, len(p.Sources))
+	for i := range p.Sources {
+		path := fmt.Sprintf("sources[%d]", i)
+		errs = append(errs, herculestypes.Nest(p.Sources[i].Validate(), path))
+		name := p.Sources[i].Name
+		if j, ok := sources[name]; ok && name != "" {
+			errs = append(errs, herculestypes.FieldErrorf(path+".name", "duplicate source name %q, also defined at sources[%d]", name, j))
+			continue
+		}
+		sources[name] = i
+	}
+	errs = append(errs, herculestypes.Nest(p.Metrics.Validate(), "metrics"))
+	errs = append(errs, p.validateTests())
+	return errors.Join(errs...)
+}
+
+// ParseSQL checks that DuckDB can parse the SQL of every macro, source, and metric in the package.
+func (p *Package) ParseSQL(ctx context.Context, parser *db.Parser) error {
+	var errs []error
+	// Missing SQL and sources of unknown types are reported by Validate
+	for i, macro := range p.Macros {
+		if macro.SQL == "" {
+			continue
+		}
+		if err := parser.ParseMacro(ctx, macro); err != nil {
+			errs = append(errs, &herculestypes.FieldError{Path: fmt.Sprintf("macros[%d].sql", i), Err: err})
+		}
+	}
+	for i := range p.Sources {
+		if p.Sources[i].Validate() != nil {
+			continue
+		}
+		if err := parser.ParseQuery(ctx, p.Sources[i].SQL()); err != nil {
+			errs = append(errs, &herculestypes.FieldError{Path: fmt.Sprintf("sources[%d].source", i), Err: err})
+		}
+	}
+	conn, err := parser.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	indexes := make(map[metric.Type]int)
+	for _, typed := range p.Metrics.Typed() {
+		path := fmt.Sprintf("metrics.%s[%d].sql", typed.Type, indexes[typed.Type])
+		indexes[typed.Type]++
+		if typed.Definition.SQL == "" {
+			continue
+		}
+		if err = parser.ParseQuery(ctx, typed.Definition.SQL); err != nil {
+			errs = append(errs, &herculestypes.FieldError{Path: path, Err: err})
+			continue
+		}
+		errs = append(errs, herculestypes.Nest(inferredLabelErrors(conn, typed), path))
+	}
+	return herculestypes.InFile(errors.Join(errs...), p.Location)
+}
+
+// inferredLabelErrors checks the name of every label inferred from the columns of the query of an
+// enabled metric, as declared labels are replaced by them once the package is initialized.
+func inferredLabelErrors(conn *sql.Conn, typed metric.TypedDefinition) error {
+	if !typed.Definition.IsEnabled() {
+		return nil
+	}
+	// Queries whose columns cannot be inferred have no labels to check
+	labels, _ := typed.Definition.InferLabels(conn, typed.Type)
+	var errs []error
+	for _, label := range labels {
+		if !model.LabelName(label).IsValidLegacy() {
+			errs = append(errs, herculestypes.FieldErrorf("", "column %q is not a valid label name", label))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// location is where a source or metric is defined.
+type location struct {
+	file string
+	path string
+}
+
+func (l location) String() string {
+	return l.file + " at " + l.path
+}
+
+// ValidatePackages checks packages for conflicts with each other. Sources share a single
+// database, so sources of different packages may only share a name if they are defined
+// identically, as when a package file is loaded more than once. Every metric must be gathered
+// under a unique family name. Metrics collide when a package is loaded from more than one file
+// under the same prefix, e.g. two versions of the same package, or when an info metric is exposed
+// under the name of another metric of the package.
+func ValidatePackages(packages []Package) error {
+	var errs []error
+	type definedSource struct {
+		location
+		pkg    int
+		source *source.Source
+	}
+	sources := make(map[string]definedSource)
+	metrics := make(map[string]location)
+	for p, pkg := range packages {
+		for i := range pkg.Sources {
+			s := &pkg.Sources[i]
+			here := location{file: pkg.Location, path: fmt.Sprintf("sources[%d].name", i)}
+			// Duplicates within a package are reported by Package.Validate
+			if there, ok := sources[s.Name]; ok && there.pkg != p && !there.source.Equal(s) {
+				errs = append(errs, &herculestypes.FieldError{
+					File: here.file, Path: here.path, Err: fmt.Errorf("source %q is also defined differently by %s", s.Name, there),
+				})
+				continue
+			}
+			sources[s.Name] = definedSource{location: here, pkg: p, source: s}
+		}
+
+		indexes := make(map[metric.Type]int)
+		for _, typed := range pkg.Metrics.Typed() {
+			here := location{file: pkg.Location, path: fmt.Sprintf("metrics.%s[%d].name", typed.Type, indexes[typed.Type])}
+			indexes[typed.Type]++
+			if !typed.Definition.IsEnabled() {
+				continue
+			}
+			// Metadata is only injected into the definitions once the package is initialized
+			definition := *typed.Definition
+			definition.Metadata = pkg.Metadata
+			for _, name := range definition.FamilyNames(typed.Type) {
+				if there, ok := metrics[name]; ok {
+					errs = append(errs, &herculestypes.FieldError{
+						File: here.file, Path: here.path, Err: fmt.Errorf("metric %s is also defined by %s", name, there),
+					})
+					continue
+				}
+				metrics[name] = here
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
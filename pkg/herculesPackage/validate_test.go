@@ -0,0 +1,162 @@
+package herculespackage_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jakthom/hercules/pkg/db"
+	herculespackage "github.com/jakthom/hercules/pkg/herculesPackage"
+	"github.com/jakthom/hercules/pkg/metric"
+	"github.com/jakthom/hercules/pkg/source"
+	herculestypes "github.com/jakthom/hercules/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func errorMessages(err error) []string {
+	var messages []string
+	for _, fieldError := range herculestypes.FieldErrors(err) {
+		messages = append(messages, fieldError.Error())
+	}
+	return messages
+}
+
+func TestPackage_Validate(t *testing.T) {
+	pkg := herculespackage.Package{
+		Name:   "test",
+		Macros: []db.Macro{{SQL: ""}},
+		Sources: []source.Source{
+			{Name: "queries", Type: source.SQLSourceType, Source: "select 1"},
+			{Name: "queries", Type: "xlsx", Source: "queries.xlsx"},
+		},
+		Metrics: metric.Definitions{
+			Histogram: []*metric.Definition{{Name: "query_duration_seconds", SQL: "select 1 as value"}},
+		},
+	}
+	assert.Equal(t, []string{
+		"macros[0].sql: sql is required",
+		`sources[1].type: unknown source type "xlsx", expected one of sql, parquet, json, or csv`,
+		`sources[1].name: duplicate source name "queries", also defined at sources[0]`,
+		"metrics.histogram[0].buckets: histogram requires buckets",
+	}, errorMessages(pkg.Validate()))
+}
+
+func TestPackageConfig_GetPackageReportsInvalidFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "package.yml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+name: test
+metrics:
+  gauge:
+    - name: query-count
+      sql: select 1 as value
+`), 0o600))
+
+	config := herculespackage.PackageConfig{Package: path, EnabledMetrics: []string{"missing"}}
+	_, err := config.GetPackage()
+	assert.Equal(t, []string{
+		path + `: metrics.gauge[0].name: "query-count" is not a valid metric name`,
+		"enabledMetrics[0]: package test has no metric missing",
+	}, errorMessages(err))
+}
+
+func TestPackage_ParseSQL(t *testing.T) {
+	parser, err := db.NewParser()
+	require.NoError(t, err)
+	defer parser.Close()
+
+	pkg := herculespackage.Package{
+		Name:     "test",
+		Location: "package.yml",
+		Macros:   []db.Macro{{SQL: "one() AS (SELECT 1)"}},
+		Sources:  []source.Source{{Name: "queries", Type: source.SQLSourceType, Source: "selec 1"}},
+		Metrics: metric.Definitions{
+			Gauge:   []*metric.Definition{{Name: "ok", SQL: "select 1 as value"}},
+			Counter: []*metric.Definition{{Name: "broken", SQL: "select from where"}},
+			Info:    []*metric.Definition{{Name: "build", SQL: `select version as "build version" from builds`}},
+		},
+	}
+	fieldErrors := herculestypes.FieldErrors(pkg.ParseSQL(t.Context(), parser))
+	require.Len(t, fieldErrors, 3)
+	assert.Equal(t, "package.yml", fieldErrors[0].File)
+	assert.Equal(t, "sources[0].source", fieldErrors[0].Path)
+	assert.Equal(t, "metrics.counter[0].sql", fieldErrors[1].Path)
+	// Labels inferred from the columns of metric queries are checked too
+	assert.Equal(t, "metrics.info[0].sql", fieldErrors[2].Path)
+	assert.EqualError(t, fieldErrors[2].Err, `column "build version" is not a valid label name`)
+
+	// Packages shipped with Hercules parse
+	config := herculespackage.PackageConfig{Package: "../../hercules-packages/snowflake/1.0.yml"}
+	snowflake, err := config.GetPackage()
+	require.NoError(t, err)
+	assert.NoError(t, snowflake.ParseSQL(t.Context(), parser))
+}
+
+func TestValidatePackages(t *testing.T) {
+	newPackage := func(name string, prefix herculestypes.MetricPrefix) herculespackage.Package {
+		return herculespackage.Package{
+			Name:     herculestypes.PackageName(name),
+			Location: name + ".yml",
+			Sources:  []source.Source{{Name: "queries", Type: source.SQLSourceType, Source: "select 1"}},
+			Metrics: metric.Definitions{
+				Gauge: []*metric.Definition{{Name: "query_count", SQL: "select 1 as value"}},
+			},
+			Metadata: metric.Metadata{PackageName: name, Prefix: prefix},
+		}
+	}
+
+	// The same package may be loaded twice under different prefixes
+	assert.NoError(t, herculespackage.ValidatePackages([]herculespackage.Package{
+		newPackage("a", "first_"), newPackage("a", "second_"),
+	}))
+
+	// Unless its variables render its sources differently, as they share a table or view
+	rendered := newPackage("a", "second_")
+	rendered.Sources[0].Source = "select 2"
+	assert.Equal(t, []string{
+		`a.yml: sources[0].name: source "queries" is also defined differently by a.yml at sources[0].name`,
+	}, errorMessages(herculespackage.ValidatePackages([]herculespackage.Package{
+		newPackage("a", "first_"), rendered,
+	})))
+
+	// Full names include the package name, so metrics only collide between versions of a package
+	other := newPackage("a", "")
+	other.Location = "b.yml"
+	other.Sources[0].Source = "select 2"
+	assert.Equal(t, []string{
+		`b.yml: sources[0].name: source "queries" is also defined differently by a.yml at sources[0].name`,
+		"b.yml: metrics.gauge[0].name: metric a_query_count is also defined by a.yml at metrics.gauge[0].name",
+	}, errorMessages(herculespackage.ValidatePackages([]herculespackage.Package{
+		newPackage("a", ""), other,
+	})))
+
+	// Info metrics are gathered with the _info suffix
+	info := newPackage("a", "")
+	info.Metrics.Gauge[0].Name = "build_info"
+	info.Metrics.Info = []*metric.Definition{{Name: "build", SQL: "select 1 as version"}}
+	assert.Equal(t, []string{
+		"a.yml: metrics.info[0].name: metric a_build_info is also defined by a.yml at metrics.gauge[0].name",
+	}, errorMessages(herculespackage.ValidatePackages([]herculespackage.Package{info})))
+}
+
+func TestPackage_ValidateTests(t *testing.T) {
+	pkg := herculespackage.Package{
+		Name:    "test",
+		Sources: []source.Source{{Name: "queries", Type: source.SQLSourceType, Source: "select 1"}},
+		Metrics: metric.Definitions{
+			Gauge: []*metric.Definition{{Name: "query_count", SQL: "select 1 as value"}},
+		},
+		Tests: []herculespackage.Test{
+			{
+				Name:     "fixtures",
+				Fixtures: map[string]string{"queries": "queries.csv", "missing": "missing.xlsx"},
+				Metrics:  []herculespackage.MetricExpectation{{Name: "query_count"}, {Name: "test_query_count"}, {Name: "missing"}},
+			},
+		},
+	}
+	assert.Equal(t, []string{
+		"tests[0].fixtures.missing: package test has no source missing",
+		`tests[0].fixtures.missing: fixture "missing.xlsx" is not a CSV, parquet, or JSON file`,
+		"tests[0].metrics[2].name: package test has no metric missing",
+	}, errorMessages(pkg.Validate()))
+}
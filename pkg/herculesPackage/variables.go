@@ -0,0 +1,185 @@
+package herculespackage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+
+	herculestypes "github.com/jakthom/hercules/pkg/types"
+)
+
+// VariableType is the type a package variable's value is coerced to.
+type VariableType string
+
+const (
+	StringVariableType VariableType = "string"
+	IntVariableType    VariableType = "int"
+	FloatVariableType  VariableType = "float"
+	BoolVariableType   VariableType = "bool"
+)
+
+// VariableDeclaration declares a variable a package can be configured with. Declared variables are
+// available to source, macro, and metric SQL as Go templates, e.g. `{{ .bucket }}`, and are
+// rendered as is. String values compared in SQL should be quoted with `{{ quote .warehouse }}`.
+type VariableDeclaration struct {
+	Name        string       `json:"name"`
+	Type        VariableType `json:"type"` // Defaults to string.
+	Default     interface{}  `json:"default,omitempty"`
+	Required    bool         `json:"required"`
+	Description string       `json:"description,omitempty"`
+}
+
+// VariableDeclarations are the variables declared by a package.
+type VariableDeclarations []VariableDeclaration
+
+// Resolve validates configured values against the declarations and returns the value of every
+// declared variable, falling back to defaults. Variable names are matched case-insensitively since
+// configuration keys are lowercased when hercules.yml is loaded. Every value that is not declared,
+// such as a misspelled name, is reported as a *herculestypes.FieldError locating the value.
+func (d VariableDeclarations) Resolve(values Variables) (Variables, error) {
+	configured := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		configured[strings.ToLower(k)] = v
+	}
+
+	resolved := Variables{}
+	declared := make(map[string]bool, len(d))
+	var errs []error
+	for _, declaration := range d {
+		key := strings.ToLower(declaration.Name)
+		declared[key] = true
+		value, ok := configured[key]
+		if !ok || value == nil {
+			if declaration.Required {
+				errs = append(errs, fmt.Errorf("variable %s is required", declaration.Name))
+				continue
+			}
+			value = declaration.Default
+		}
+		if value == nil {
+			continue
+		}
+		coerced, err := declaration.coerce(value)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		resolved[declaration.Name] = coerced
+	}
+	for _, k := range slices.Sorted(maps.Keys(values)) {
+		if !declared[strings.ToLower(k)] {
+			errs = append(errs, herculestypes.FieldErrorf(k, "variable %s is not declared by the package", k))
+		}
+	}
+	return resolved, errors.Join(errs...)
+}
+
+func (d VariableDeclaration) coerce(value interface{}) (interface{}, error) {
+	var coerced interface{}
+	var err error
+	switch d.Type {
+	case StringVariableType, "":
+		coerced, err = coerceString(value)
+	case IntVariableType:
+		coerced, err = coerceInt(value)
+	case FloatVariableType:
+		coerced, err = coerceFloat(value)
+	case BoolVariableType:
+		coerced, err = coerceBool(value)
+	default:
+		return nil, fmt.Errorf("variable %s has unsupported type %s", d.Name, d.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("variable %s is not a valid %s: %w", d.Name, d.Type, err)
+	}
+	return coerced, nil
+}
+
+func coerceString(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool, int, int64, float64:
+		return fmt.Sprint(v), nil
+	default:
+		return "", fmt.Errorf("unexpected value %v", value)
+	}
+}
+
+func coerceInt(value interface{}) (int64, error) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case float64:
+		if v != math.Trunc(v) {
+			return 0, fmt.Errorf("unexpected value %v", value)
+		}
+		return int64(v), nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	default:
+		return 0, fmt.Errorf("unexpected value %v", value)
+	}
+}
+
+func coerceFloat(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	default:
+		return 0, fmt.Errorf("unexpected value %v", value)
+	}
+}
+
+func coerceBool(value interface{}) (bool, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case string:
+		return strconv.ParseBool(v)
+	default:
+		return false, fmt.Errorf("unexpected value %v", value)
+	}
+}
+
+// templateFuncs are available to every template rendered against the package variables.
+var templateFuncs = template.FuncMap{
+	"quote": quote,
+}
+
+// quote renders value as a SQL string literal, escaping any single quotes it contains, e.g.
+// `where warehouse = {{ quote .warehouse }}`.
+func quote(value interface{}) string {
+	return "'" + strings.ReplaceAll(fmt.Sprint(value), "'", "''") + "'"
+}
+
+// render executes text as a Go template against the package variables. Referencing an undefined
+// variable is an error.
+func (v Variables) render(name string, text string) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("could not parse template %s: %w", name, err)
+	}
+	var rendered bytes.Buffer
+	if err = tmpl.Execute(&rendered, map[string]interface{}(v)); err != nil {
+		return "", fmt.Errorf("could not render template %s: %w", name, err)
+	}
+	return rendered.String(), nil
+}
@@ -0,0 +1,181 @@
+package herculespackage_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jakthom/hercules/pkg/db"
+	herculespackage "github.com/jakthom/hercules/pkg/herculesPackage"
+	"github.com/jakthom/hercules/pkg/metric"
+	"github.com/jakthom/hercules/pkg/source"
+	herculestypes "github.com/jakthom/hercules/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVariableDeclarations_Resolve(t *testing.T) {
+	declarations := herculespackage.VariableDeclarations{
+		{Name: "bucket", Required: true},
+		{Name: "warehouseName", Type: herculespackage.StringVariableType, Default: "COMPUTE_WH"},
+		{Name: "limit", Type: herculespackage.IntVariableType, Default: 100},
+		{Name: "threshold", Type: herculespackage.FloatVariableType},
+		{Name: "verbose", Type: herculespackage.BoolVariableType, Default: false},
+	}
+
+	resolved, err := declarations.Resolve(herculespackage.Variables{
+		"bucket":    "s3://metrics",
+		"threshold": "0.5",
+		"verbose":   "true",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, herculespackage.Variables{
+		"bucket":        "s3://metrics",
+		"warehouseName": "COMPUTE_WH",
+		"limit":         int64(100),
+		"threshold":     0.5,
+		"verbose":       true,
+	}, resolved)
+}
+
+func TestVariableDeclarations_ResolveMatchesLowercasedNames(t *testing.T) {
+	declarations := herculespackage.VariableDeclarations{{Name: "warehouseName"}}
+
+	resolved, err := declarations.Resolve(herculespackage.Variables{"warehousename": "ANALYTICS_WH"})
+	require.NoError(t, err)
+	assert.Equal(t, herculespackage.Variables{"warehouseName": "ANALYTICS_WH"}, resolved)
+}
+
+func TestVariableDeclarations_ResolveErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		declaration herculespackage.VariableDeclaration
+		values      herculespackage.Variables
+		expected    string
+	}{
+		{
+			name:        "Missing required variable",
+			declaration: herculespackage.VariableDeclaration{Name: "bucket", Required: true},
+			values:      herculespackage.Variables{},
+			expected:    "variable bucket is required",
+		},
+		{
+			name:        "Invalid int",
+			declaration: herculespackage.VariableDeclaration{Name: "limit", Type: herculespackage.IntVariableType},
+			values:      herculespackage.Variables{"limit": 1.5},
+			expected:    "variable limit is not a valid int",
+		},
+		{
+			name:        "Invalid bool",
+			declaration: herculespackage.VariableDeclaration{Name: "verbose", Type: herculespackage.BoolVariableType},
+			values:      herculespackage.Variables{"verbose": "sometimes"},
+			expected:    "variable verbose is not a valid bool",
+		},
+		{
+			name:        "Unsupported type",
+			declaration: herculespackage.VariableDeclaration{Name: "buckets", Type: "list"},
+			values:      herculespackage.Variables{"buckets": "a"},
+			expected:    "variable buckets has unsupported type list",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := herculespackage.VariableDeclarations{tt.declaration}.Resolve(tt.values)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expected)
+		})
+	}
+}
+
+func TestVariableDeclarations_ResolveRejectsUndeclaredVariables(t *testing.T) {
+	declarations := herculespackage.VariableDeclarations{{Name: "bucket", Default: "s3://metrics"}}
+
+	_, err := declarations.Resolve(herculespackage.Variables{"buckett": "s3://typo"})
+	require.Error(t, err)
+	fieldErrors := herculestypes.FieldErrors(herculestypes.Nest(err, "packages[0].variables"))
+	require.Len(t, fieldErrors, 1)
+	assert.Equal(t, "packages[0].variables.buckett", fieldErrors[0].Path)
+	assert.EqualError(t, fieldErrors[0].Err, "variable buckett is not declared by the package")
+}
+
+func TestPackage_RenderTemplates(t *testing.T) {
+	pkg := herculespackage.Package{
+		Variables: herculespackage.Variables{"bucket": "s3://metrics", "warehouse": "COMPUTE_WH"},
+		Sources: []source.Source{
+			{Name: "history", Source: "{{ .bucket }}/query_history.parquet"},
+		},
+		Macros: []db.Macro{
+			{Name: "wh", SQL: "wh() AS ('{{ .warehouse }}')"},
+		},
+		Metrics: metric.Definitions{
+			Gauge: []*metric.Definition{
+				{Name: "queries", SQL: "select count(*) as value from history where warehouse = '{{ .warehouse }}'"},
+			},
+		},
+	}
+
+	require.NoError(t, pkg.RenderTemplates())
+	assert.Equal(t, "s3://metrics/query_history.parquet", pkg.Sources[0].Source)
+	assert.Equal(t, db.SQL("wh() AS ('COMPUTE_WH')"), pkg.Macros[0].SQL)
+	assert.Equal(t, db.SQL("select count(*) as value from history where warehouse = 'COMPUTE_WH'"), pkg.Metrics.Gauge[0].SQL)
+}
+
+func TestPackage_RenderTemplatesQuote(t *testing.T) {
+	pkg := herculespackage.Package{
+		Variables: herculespackage.Variables{"warehouse": "O'BRIEN_WH", "limit": int64(10)},
+		Metrics: metric.Definitions{
+			Gauge: []*metric.Definition{
+				{Name: "queries", SQL: "select count(*) as value from history where warehouse = {{ quote .warehouse }} and id = {{ quote .limit }}"},
+			},
+		},
+	}
+
+	require.NoError(t, pkg.RenderTemplates())
+	assert.Equal(t, db.SQL("select count(*) as value from history where warehouse = 'O''BRIEN_WH' and id = '10'"), pkg.Metrics.Gauge[0].SQL)
+}
+
+func TestPackage_RenderTemplatesUndefinedVariable(t *testing.T) {
+	pkg := herculespackage.Package{
+		Sources: []source.Source{
+			{Name: "history", Source: "{{ .bucket }}/query_history.parquet"},
+		},
+	}
+
+	err := pkg.RenderTemplates()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "source history")
+}
+
+func TestPackageConfig_GetPackageRendersVariables(t *testing.T) {
+	packagePath := filepath.Join(t.TempDir(), "package.yml")
+	require.NoError(t, os.WriteFile(packagePath, []byte(`
+name: templated
+version: '1.0'
+variables:
+  - name: bucket
+    required: true
+  - name: minimumDuration
+    type: int
+    default: 10
+sources:
+  - name: history
+    type: parquet
+    source: "{{ .bucket }}/history.parquet"
+metrics:
+  gauge:
+    - name: slow_queries
+      sql: select count(*) as value from history where duration > {{ .minimumDuration }}
+`), 0o600))
+
+	pkg, err := (&herculespackage.PackageConfig{
+		Package:   packagePath,
+		Variables: herculespackage.Variables{"bucket": "s3://metrics"},
+	}).GetPackage()
+	require.NoError(t, err)
+	assert.Equal(t, "s3://metrics/history.parquet", pkg.Sources[0].Source)
+	assert.Equal(t, db.SQL("select count(*) as value from history where duration > 10"), pkg.Metrics.Gauge[0].SQL)
+
+	_, err = (&herculespackage.PackageConfig{Package: packagePath}).GetPackage()
+	assert.ErrorContains(t, err, "variable bucket is required")
+}
@@ -0,0 +1,189 @@
+package metric
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jakthom/hercules/pkg/db"
+	"github.com/jakthom/hercules/pkg/labels"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collector holds the shared prometheus.Collector plumbing for every metric type. Each
+// materialization builds a fresh set of const metrics which is swapped in atomically, so
+// concurrent scrapes always observe a complete result set.
+type collector struct {
+	definition Definition
+	values     []value
+	labelNames []string
+	metrics    atomic.Pointer[[]prometheus.Metric]
+	rows       atomic.Int64
+}
+
+// value is a single metric fed by a column of the definition's query.
+type value struct {
+	name   string
+	column string // Empty when the value is read from a conventionally named column.
+	desc   *prometheus.Desc
+}
+
+func newValue(name string, column string, help string, labelNames []string) value {
+	return value{
+		name:   name,
+		column: column,
+		desc:   prometheus.NewDesc(name, help, labelNames, nil),
+	}
+}
+
+// of returns the value of a single query result.
+func (v value) of(r db.QueryResult) float64 {
+	if v.column == "" {
+		return r.Value
+	}
+	return r.Columns[v.column]
+}
+
+// newCollector builds the collector for definition. Metrics that add labels of their own, beyond the
+// labels of their query results, name them in variableLabels.
+func newCollector(definition Definition, variableLabels ...string) collector {
+	// Label order is fixed once so label values always line up with the descriptor
+	labelNames := definition.LabelNames()
+	descLabels := slices.Concat(labelNames, variableLabels)
+	var values []value
+	if len(definition.ValueColumns) == 0 {
+		values = []value{newValue(definition.FullName(), definition.ValueColumn, definition.Help, descLabels)}
+	}
+	for _, column := range definition.valueColumns() {
+		if name, ok := definition.ValueColumns[column]; ok {
+			values = append(values, newValue(definition.fullName(name), column, definition.Help, descLabels))
+		}
+	}
+	return collector{
+		definition: definition,
+		values:     values,
+		labelNames: labelNames,
+	}
+}
+
+// MetricDefinition returns the definition the metric was built from.
+func (c *collector) MetricDefinition() Definition {
+	return c.definition
+}
+
+// Describe implements prometheus.Collector.
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, v := range c.values {
+		ch <- v.desc
+	}
+}
+
+// Collect implements prometheus.Collector by emitting the metrics from the latest materialization.
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	metrics := c.metrics.Load()
+	if metrics == nil {
+		return
+	}
+	for _, m := range *metrics {
+		ch <- m
+	}
+}
+
+// RowsReturned returns the number of rows returned by the latest materialization.
+func (c *collector) RowsReturned() int {
+	return int(c.rows.Load())
+}
+
+// materialize runs the definition's query, reading its value columns along with numericColumns
+// and applying its NULL policy.
+func (c *collector) materialize(ctx context.Context, conn *sql.Conn, numericColumns ...string) ([]db.QueryResult, error) {
+	return db.MaterializeWithColumns(ctx, conn, c.definition.SQL, db.ResultColumns{
+		Values:    c.definition.valueColumns(),
+		Numeric:   numericColumns,
+		Timestamp: c.definition.TimestampColumn,
+		Exemplar:  c.definition.ExemplarColumns,
+	}, c.definition.Nulls)
+}
+
+// materializeLabels runs the query of a metric without a value, reading every column other than
+// the timestamp column as a label.
+func (c *collector) materializeLabels(ctx context.Context, conn *sql.Conn) ([]db.QueryResult, error) {
+	return db.MaterializeWithColumns(ctx, conn, c.definition.SQL, db.ResultColumns{
+		Timestamp: c.definition.TimestampColumn,
+		Valueless: true,
+	}, c.definition.Nulls)
+}
+
+// exemplar builds the exemplar of a single query result, and returns false if it has none.
+func (v value) exemplar(r db.QueryResult) (prometheus.Exemplar, bool) {
+	if len(r.Exemplar) == 0 {
+		return prometheus.Exemplar{}, false
+	}
+	return prometheus.Exemplar{Value: v.of(r), Labels: r.Exemplar, Timestamp: r.Timestamp}, true
+}
+
+// withExemplars attaches exemplars to a metric, if there are any.
+func (c *collector) withExemplars(m prometheus.Metric, exemplars []prometheus.Exemplar) (prometheus.Metric, error) {
+	if len(exemplars) == 0 {
+		return m, nil
+	}
+	return prometheus.NewMetricWithExemplars(m, exemplars...)
+}
+
+// withTimestamp attaches the time a series describes to its metric, if known.
+func (c *collector) withTimestamp(m prometheus.Metric, timestamp time.Time) prometheus.Metric {
+	if timestamp.IsZero() {
+		return m
+	}
+	return prometheus.NewMetricWithTimestamp(timestamp, m)
+}
+
+func (c *collector) store(metrics []prometheus.Metric, rows int) {
+	c.metrics.Store(&metrics)
+	c.rows.Store(int64(rows))
+}
+
+// series is the set of query results sharing the same label values.
+type series struct {
+	key         string // Uniquely identifies the label set.
+	labelValues []string
+	results     []db.QueryResult
+}
+
+// timestamp returns the latest time described by the series' results, or the zero time if none
+// of them carry a timestamp.
+func (s *series) timestamp() time.Time {
+	var latest time.Time
+	for _, r := range s.results {
+		if r.Timestamp.After(latest) {
+			latest = r.Timestamp
+		}
+	}
+	return latest
+}
+
+// group collects query results into series, in order of first appearance, so that each
+// label set is emitted exactly once.
+func (c *collector) group(results []db.QueryResult, metadata Metadata) []*series {
+	var grouped []*series
+	index := make(map[string]*series)
+	for _, r := range results {
+		l := labels.Merge(r.StringifiedLabels(), metadata.Labels)
+		labelValues := make([]string, len(c.labelNames))
+		for i, name := range c.labelNames {
+			labelValues[i] = l[name]
+		}
+		key := strings.Join(labelValues, "\xff")
+		s, ok := index[key]
+		if !ok {
+			s = &series{key: key, labelValues: labelValues}
+			index[key] = s
+			grouped = append(grouped, s)
+		}
+		s.results = append(s.results, r)
+	}
+	return grouped
+}
@@ -0,0 +1,464 @@
+// Package metric_test contains tests for the metric package
+package metric_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jakthom/hercules/pkg/db"
+	"github.com/jakthom/hercules/pkg/metric"
+	"github.com/jakthom/hercules/pkg/testutil"
+	herculestypes "github.com/jakthom/hercules/pkg/types"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gather(t *testing.T, c prometheus.Collector) *dto.MetricFamily {
+	t.Helper()
+	reg := prometheus.NewRegistry()
+	require.NoError(t, reg.Register(c))
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 1)
+	return families[0]
+}
+
+func testDefinition(name string, sql db.SQL) metric.Definition {
+	return metric.Definition{
+		Name:     name,
+		Help:     "Test metric",
+		SQL:      sql,
+		Labels:   []string{"user"},
+		Metadata: metric.Metadata{PackageName: "test", Labels: map[string]string{"hercules": "test"}},
+	}
+}
+
+func userRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"user", "value"}).
+		AddRow("a", 1).
+		AddRow("a", 3).
+		AddRow("b", 10)
+}
+
+func TestGauge_CollectsConstMetrics(t *testing.T) {
+	conn, mock, _ := testutil.GetMockedConnection()
+	mock.ExpectQuery("select gauge").WillReturnRows(userRows())
+
+	g := metric.NewGauge(testDefinition("gauge", "select gauge"))
+	require.NoError(t, g.Materialize(t.Context(), conn))
+
+	family := gather(t, g)
+	assert.Equal(t, "test_gauge", family.GetName())
+	assert.Equal(t, dto.MetricType_GAUGE, family.GetType())
+	require.Len(t, family.GetMetric(), 2)
+	// The last row for a label set wins
+	assert.InDelta(t, 3, family.GetMetric()[0].GetGauge().GetValue(), 0)
+	assert.InDelta(t, 10, family.GetMetric()[1].GetGauge().GetValue(), 0)
+}
+
+func TestGauge_MaterializeReplacesPreviousResults(t *testing.T) {
+	conn, mock, _ := testutil.GetMockedConnection()
+	mock.ExpectQuery("select gauge").WillReturnRows(userRows())
+	mock.ExpectQuery("select gauge").
+		WillReturnRows(sqlmock.NewRows([]string{"user", "value"}).AddRow("c", 5))
+
+	g := metric.NewGauge(testDefinition("gauge", "select gauge"))
+	require.NoError(t, g.Materialize(t.Context(), conn))
+	require.NoError(t, g.Materialize(t.Context(), conn))
+
+	family := gather(t, g)
+	require.Len(t, family.GetMetric(), 1)
+	assert.Equal(t, "c", family.GetMetric()[0].GetLabel()[1].GetValue())
+}
+
+func TestCounter_CollectsConstMetrics(t *testing.T) {
+	conn, mock, _ := testutil.GetMockedConnection()
+	mock.ExpectQuery("select counter").WillReturnRows(userRows())
+
+	c := metric.NewCounter(testDefinition("counter", "select counter"))
+	require.NoError(t, c.Materialize(t.Context(), conn))
+
+	family := gather(t, c)
+	assert.Equal(t, dto.MetricType_COUNTER, family.GetType())
+	require.Len(t, family.GetMetric(), 2)
+	assert.InDelta(t, 4, family.GetMetric()[0].GetCounter().GetValue(), 0)
+	assert.InDelta(t, 10, family.GetMetric()[1].GetCounter().GetValue(), 0)
+}
+
+func TestCounter_DetectsResets(t *testing.T) {
+	conn, mock, _ := testutil.GetMockedConnection()
+	c := metric.NewCounter(testDefinition("counter", "select counter"))
+
+	// The underlying total drops from 10 to 3 between the second and third materialization
+	for _, value := range []int{5, 10, 3, 7} {
+		mock.ExpectQuery("select counter").WillReturnRows(sqlmock.NewRows([]string{"user", "value"}).AddRow("a", value))
+	}
+	var exposed []float64
+	for range 4 {
+		require.NoError(t, c.Materialize(t.Context(), conn))
+		exposed = append(exposed, gather(t, c).GetMetric()[0].GetCounter().GetValue())
+	}
+
+	assert.Equal(t, []float64{5, 10, 13, 17}, exposed)
+}
+
+func TestCounter_ForgetsDisappearedSeries(t *testing.T) {
+	conn, mock, _ := testutil.GetMockedConnection()
+	c := metric.NewCounter(testDefinition("counter", "select counter"))
+
+	// Series a disappears from the second materialization and reappears in the third
+	mock.ExpectQuery("select counter").WillReturnRows(sqlmock.NewRows([]string{"user", "value"}).AddRow("a", 10))
+	mock.ExpectQuery("select counter").WillReturnRows(sqlmock.NewRows([]string{"user", "value"}).AddRow("b", 1))
+	mock.ExpectQuery("select counter").WillReturnRows(sqlmock.NewRows([]string{"user", "value"}).AddRow("a", 3))
+	for range 3 {
+		require.NoError(t, c.Materialize(t.Context(), conn))
+	}
+
+	family := gather(t, c)
+	require.Len(t, family.GetMetric(), 1)
+	assert.InDelta(t, 3, family.GetMetric()[0].GetCounter().GetValue(), 0, "a reappearing series starts a new total")
+}
+
+func TestCounter_RejectsNegativeValues(t *testing.T) {
+	conn, mock, _ := testutil.GetMockedConnection()
+	mock.ExpectQuery("select counter").WillReturnRows(sqlmock.NewRows([]string{"user", "value"}).
+		AddRow("a", -1).
+		AddRow("b", 1))
+
+	c := metric.NewCounter(testDefinition("counter", "select counter"))
+	require.ErrorContains(t, c.Materialize(t.Context(), conn), "negative value")
+
+	family := gather(t, c)
+	require.Len(t, family.GetMetric(), 1, "valid series are still exposed")
+	assert.Equal(t, "b", family.GetMetric()[0].GetLabel()[1].GetValue())
+}
+
+func TestHistogram_CollectsConstMetrics(t *testing.T) {
+	conn, mock, _ := testutil.GetMockedConnection()
+	mock.ExpectQuery("select histogram").WillReturnRows(userRows())
+
+	definition := testDefinition("histogram", "select histogram")
+	definition.Buckets = []float64{1, 5}
+	h := metric.NewHistogram(definition)
+	require.NoError(t, h.Materialize(t.Context(), conn))
+
+	family := gather(t, h)
+	assert.Equal(t, dto.MetricType_HISTOGRAM, family.GetType())
+	require.Len(t, family.GetMetric(), 2)
+	a := family.GetMetric()[0].GetHistogram()
+	assert.Equal(t, uint64(2), a.GetSampleCount())
+	assert.InDelta(t, 4, a.GetSampleSum(), 0)
+	assert.Equal(t, uint64(1), a.GetBucket()[0].GetCumulativeCount())
+	assert.Equal(t, uint64(2), a.GetBucket()[1].GetCumulativeCount())
+}
+
+func TestSummary_CollectsConstMetrics(t *testing.T) {
+	conn, mock, _ := testutil.GetMockedConnection()
+	mock.ExpectQuery("select summary").WillReturnRows(userRows())
+
+	definition := testDefinition("summary", "select summary")
+	definition.Objectives = metric.Objectives{0.5: 0.05}
+	s := metric.NewSummary(definition)
+	require.NoError(t, s.Materialize(t.Context(), conn))
+
+	family := gather(t, s)
+	assert.Equal(t, dto.MetricType_SUMMARY, family.GetType())
+	require.Len(t, family.GetMetric(), 2)
+	b := family.GetMetric()[1].GetSummary()
+	assert.Equal(t, uint64(1), b.GetSampleCount())
+	assert.InDelta(t, 10, b.GetSampleSum(), 0)
+	require.Len(t, b.GetQuantile(), 1)
+	assert.InDelta(t, 10, b.GetQuantile()[0].GetValue(), 0)
+}
+
+func TestSummary_KeepsWindowAcrossMaterializations(t *testing.T) {
+	conn, mock, _ := testutil.GetMockedConnection()
+	definition := testDefinition("summary", "select summary")
+	definition.Objectives = metric.Objectives{0.5: 0.05}
+	definition.MaxAge = herculestypes.Duration(10 * time.Minute)
+	s := metric.NewSummary(definition)
+
+	// Series b disappears from the second materialization
+	mock.ExpectQuery("select summary").WillReturnRows(userRows())
+	mock.ExpectQuery("select summary").WillReturnRows(sqlmock.NewRows([]string{"user", "value"}).AddRow("a", 5))
+	for range 2 {
+		require.NoError(t, s.Materialize(t.Context(), conn))
+	}
+
+	family := gather(t, s)
+	require.Len(t, family.GetMetric(), 1)
+	a := family.GetMetric()[0].GetSummary()
+	assert.Equal(t, uint64(3), a.GetSampleCount())
+	assert.InDelta(t, 9, a.GetSampleSum(), 0)
+	require.Len(t, a.GetQuantile(), 1)
+	assert.InDelta(t, 3, a.GetQuantile()[0].GetValue(), 0, "the median covers both materializations")
+}
+
+func TestHistogram_CollectsPreaggregatedBuckets(t *testing.T) {
+	conn, mock, _ := testutil.GetMockedConnection()
+	mock.ExpectQuery("select histogram").WillReturnRows(sqlmock.NewRows([]string{"user", "le", "count", "sum"}).
+		AddRow("a", "5", 3, 12).
+		AddRow("a", "1", 2, 1).
+		AddRow("a", "+Inf", 1, 50))
+
+	definition := testDefinition("histogram", "select histogram")
+	definition.Preaggregated = true
+	definition.Buckets = []float64{1, 2}
+	h := metric.NewHistogram(definition)
+	require.NoError(t, h.Materialize(t.Context(), conn))
+
+	family := gather(t, h)
+	require.Len(t, family.GetMetric(), 1)
+	a := family.GetMetric()[0].GetHistogram()
+	assert.Equal(t, uint64(6), a.GetSampleCount())
+	assert.InDelta(t, 63, a.GetSampleSum(), 0)
+	require.Len(t, a.GetBucket(), 3, "configured buckets missing from the results are exposed")
+	assert.InDelta(t, 1, a.GetBucket()[0].GetUpperBound(), 0)
+	assert.Equal(t, uint64(2), a.GetBucket()[0].GetCumulativeCount())
+	assert.InDelta(t, 2, a.GetBucket()[1].GetUpperBound(), 0)
+	assert.Equal(t, uint64(2), a.GetBucket()[1].GetCumulativeCount())
+	assert.InDelta(t, 5, a.GetBucket()[2].GetUpperBound(), 0)
+	assert.Equal(t, uint64(5), a.GetBucket()[2].GetCumulativeCount())
+}
+
+func TestHistogram_PreaggregatedRequiresBucketColumns(t *testing.T) {
+	conn, mock, _ := testutil.GetMockedConnection()
+	mock.ExpectQuery("select histogram").WillReturnRows(userRows())
+
+	definition := testDefinition("histogram", "select histogram")
+	definition.Preaggregated = true
+	h := metric.NewHistogram(definition)
+	require.ErrorContains(t, h.Materialize(t.Context(), conn), "did not return a le column")
+}
+
+func TestSummary_CollectsPreaggregatedQuantiles(t *testing.T) {
+	conn, mock, _ := testutil.GetMockedConnection()
+	mock.ExpectQuery("select summary").WillReturnRows(sqlmock.NewRows([]string{"user", "quantile", "value", "sum", "count"}).
+		AddRow("a", 0.5, 2, 40, 10).
+		AddRow("a", 0.99, 9, 40, 10))
+
+	definition := testDefinition("summary", "select summary")
+	definition.Preaggregated = true
+	s := metric.NewSummary(definition)
+	require.NoError(t, s.Materialize(t.Context(), conn))
+
+	family := gather(t, s)
+	require.Len(t, family.GetMetric(), 1)
+	a := family.GetMetric()[0].GetSummary()
+	assert.Equal(t, uint64(10), a.GetSampleCount())
+	assert.InDelta(t, 40, a.GetSampleSum(), 0)
+	require.Len(t, a.GetQuantile(), 2)
+	assert.InDelta(t, 0.5, a.GetQuantile()[0].GetQuantile(), 0)
+	assert.InDelta(t, 2, a.GetQuantile()[0].GetValue(), 0)
+	assert.InDelta(t, 0.99, a.GetQuantile()[1].GetQuantile(), 0)
+	assert.InDelta(t, 9, a.GetQuantile()[1].GetValue(), 0)
+}
+
+func TestSummary_RejectsInvalidPreaggregatedQuantiles(t *testing.T) {
+	conn, mock, _ := testutil.GetMockedConnection()
+	mock.ExpectQuery("select summary").WillReturnRows(sqlmock.NewRows([]string{"user", "quantile", "value", "sum", "count"}).
+		AddRow("a", 99, 2, 40, 10))
+
+	definition := testDefinition("summary", "select summary")
+	definition.Preaggregated = true
+	s := metric.NewSummary(definition)
+	require.ErrorContains(t, s.Materialize(t.Context(), conn), "invalid quantile")
+}
+
+func TestGauge_CollectsExplicitValueColumn(t *testing.T) {
+	conn, mock, _ := testutil.GetMockedConnection()
+	mock.ExpectQuery("select gauge").WillReturnRows(sqlmock.NewRows([]string{"v", "total"}).
+		AddRow("a", 2).
+		AddRow("b", 5))
+
+	definition := testDefinition("gauge", "select gauge")
+	definition.Labels = []string{"v"}
+	definition.ValueColumn = "total"
+	g := metric.NewGauge(definition)
+	require.NoError(t, g.Materialize(t.Context(), conn))
+
+	family := gather(t, g)
+	require.Len(t, family.GetMetric(), 2)
+	assert.Equal(t, "a", family.GetMetric()[0].GetLabel()[1].GetValue())
+	assert.InDelta(t, 2, family.GetMetric()[0].GetGauge().GetValue(), 0)
+	assert.InDelta(t, 5, family.GetMetric()[1].GetGauge().GetValue(), 0)
+}
+
+func TestGauge_CollectsValueColumns(t *testing.T) {
+	conn, mock, _ := testutil.GetMockedConnection()
+	mock.ExpectQuery("select scanned").WillReturnRows(sqlmock.NewRows([]string{"user", "bytes", "rows"}).
+		AddRow("a", 1024, 10).
+		AddRow("b", 2048, 20))
+
+	definition := testDefinition("scanned", "select scanned")
+	definition.ValueColumns = map[string]string{"bytes": "scanned_bytes", "rows": "scanned_rows"}
+	g := metric.NewGauge(definition)
+	require.NoError(t, g.Materialize(t.Context(), conn))
+
+	reg := prometheus.NewRegistry()
+	require.NoError(t, reg.Register(g))
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 2)
+	assert.Equal(t, "test_scanned_bytes", families[0].GetName())
+	assert.InDelta(t, 2048, families[0].GetMetric()[1].GetGauge().GetValue(), 0)
+	assert.Equal(t, "test_scanned_rows", families[1].GetName())
+	assert.InDelta(t, 20, families[1].GetMetric()[1].GetGauge().GetValue(), 0)
+}
+
+func TestCounter_CollectsValueColumns(t *testing.T) {
+	conn, mock, _ := testutil.GetMockedConnection()
+	definition := testDefinition("scanned", "select scanned")
+	definition.ValueColumns = map[string]string{"bytes": "scanned_bytes_total", "rows": "scanned_rows_total"}
+	c := metric.NewCounter(definition)
+
+	// Resets are tracked per value column, so a drop in rows leaves bytes untouched
+	mock.ExpectQuery("select scanned").WillReturnRows(sqlmock.NewRows([]string{"user", "bytes", "rows"}).AddRow("a", 100, 10))
+	mock.ExpectQuery("select scanned").WillReturnRows(sqlmock.NewRows([]string{"user", "bytes", "rows"}).AddRow("a", 150, 4))
+	require.NoError(t, c.Materialize(t.Context(), conn))
+	require.NoError(t, c.Materialize(t.Context(), conn))
+
+	reg := prometheus.NewRegistry()
+	require.NoError(t, reg.Register(c))
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 2)
+	assert.InDelta(t, 150, families[0].GetMetric()[0].GetCounter().GetValue(), 0)
+	assert.InDelta(t, 14, families[1].GetMetric()[0].GetCounter().GetValue(), 0)
+}
+
+func TestGauge_CollectsTimestamps(t *testing.T) {
+	conn, mock, _ := testutil.GetMockedConnection()
+	measured := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("select gauge").WillReturnRows(sqlmock.NewRows([]string{"user", "measured_at", "value"}).
+		AddRow("a", measured, 1).
+		AddRow("b", nil, 2))
+
+	definition := testDefinition("gauge", "select gauge")
+	definition.TimestampColumn = "measured_at"
+	g := metric.NewGauge(definition)
+	require.NoError(t, g.Materialize(t.Context(), conn))
+
+	family := gather(t, g)
+	require.Len(t, family.GetMetric(), 2)
+	assert.Equal(t, measured.UnixMilli(), family.GetMetric()[0].GetTimestampMs())
+	assert.Len(t, family.GetMetric()[0].GetLabel(), 2, "the timestamp column is not a label")
+	assert.Nil(t, family.GetMetric()[1].TimestampMs, "rows without a timestamp are exposed at scrape time")
+}
+
+func TestHistogram_CollectsLatestTimestamp(t *testing.T) {
+	conn, mock, _ := testutil.GetMockedConnection()
+	mock.ExpectQuery("select histogram").WillReturnRows(sqlmock.NewRows([]string{"user", "ts", "value"}).
+		AddRow("a", 1719835200, 1).
+		AddRow("a", 1719838800, 2))
+
+	definition := testDefinition("histogram", "select histogram")
+	definition.Buckets = []float64{1, 5}
+	definition.TimestampColumn = "ts"
+	h := metric.NewHistogram(definition)
+	require.NoError(t, h.Materialize(t.Context(), conn))
+
+	family := gather(t, h)
+	require.Len(t, family.GetMetric(), 1)
+	assert.Equal(t, int64(1719838800000), family.GetMetric()[0].GetTimestampMs())
+}
+
+func TestInfo_CollectsConstantSeries(t *testing.T) {
+	conn, mock, _ := testutil.GetMockedConnection()
+	mock.ExpectQuery("select info").WillReturnRows(sqlmock.NewRows([]string{"warehouse", "value"}).
+		AddRow("compute", "x-small").
+		AddRow("compute", "x-small").
+		AddRow("analytics", "large"))
+
+	definition := testDefinition("warehouse", "select info")
+	definition.Labels = []string{"warehouse", "value"}
+	i := metric.NewInfo(definition)
+	require.NoError(t, i.Materialize(t.Context(), conn))
+
+	family := gather(t, i)
+	assert.Equal(t, "test_warehouse_info", family.GetName())
+	assert.Equal(t, "test_warehouse", i.FamilyName())
+	require.Len(t, family.GetMetric(), 2, "duplicate rows collapse into one series")
+	assert.Equal(t, "large", family.GetMetric()[0].GetLabel()[1].GetValue(), "value columns are labels")
+	assert.InDelta(t, 1, family.GetMetric()[0].GetGauge().GetValue(), 0)
+}
+
+func TestStateSet_CollectsStates(t *testing.T) {
+	conn, mock, _ := testutil.GetMockedConnection()
+	mock.ExpectQuery("select stateset").WillReturnRows(sqlmock.NewRows([]string{"user", "status"}).
+		AddRow("a", "success").
+		AddRow("a", "fail").
+		AddRow("b", "queued"))
+
+	definition := testDefinition("status", "select stateset")
+	definition.StateColumn = "status"
+	definition.States = []string{"success", "fail"}
+	s := metric.NewStateSet(definition)
+	require.NoError(t, s.Materialize(t.Context(), conn))
+
+	family := gather(t, s)
+	assert.Equal(t, dto.MetricType_GAUGE, family.GetType())
+	states := map[string]float64{}
+	for _, m := range family.GetMetric() {
+		var user, state string
+		for _, l := range m.GetLabel() {
+			switch l.GetName() {
+			case "user":
+				user = l.GetValue()
+			case "test_status":
+				state = l.GetValue()
+			}
+		}
+		states[user+"/"+state] = m.GetGauge().GetValue()
+	}
+	assert.Equal(t, map[string]float64{
+		"a/success": 1,
+		"a/fail":    1,
+		"b/success": 0,
+		"b/fail":    0,
+		"b/queued":  1,
+	}, states)
+}
+
+func TestHistogram_CollectsExemplars(t *testing.T) {
+	conn, mock, _ := testutil.GetMockedConnection()
+	mock.ExpectQuery("select histogram").WillReturnRows(sqlmock.NewRows([]string{"user", "query_id", "value"}).
+		AddRow("a", "q1", 0.5).
+		AddRow("a", "q2", 0.7).
+		AddRow("a", nil, 4))
+
+	definition := testDefinition("histogram", "select histogram")
+	definition.Buckets = []float64{1, 5}
+	definition.ExemplarColumns = []string{"query_id"}
+	h := metric.NewHistogram(definition)
+	require.NoError(t, h.Materialize(t.Context(), conn))
+
+	family := gather(t, h)
+	require.Len(t, family.GetMetric(), 1)
+	assert.Len(t, family.GetMetric()[0].GetLabel(), 2, "exemplar columns are not labels")
+	buckets := family.GetMetric()[0].GetHistogram().GetBucket()
+	require.Len(t, buckets, 2)
+	assert.Equal(t, "q2", buckets[0].GetExemplar().GetLabel()[0].GetValue(), "the last observation in a bucket wins")
+	assert.InDelta(t, 0.7, buckets[0].GetExemplar().GetValue(), 0)
+	assert.Nil(t, buckets[1].GetExemplar(), "observations without exemplar labels carry no exemplar")
+}
+
+func TestCounter_CollectsExemplars(t *testing.T) {
+	conn, mock, _ := testutil.GetMockedConnection()
+	mock.ExpectQuery("select counter").WillReturnRows(sqlmock.NewRows([]string{"user", "trace_id", "value"}).
+		AddRow("a", "t1", 1).
+		AddRow("a", "t2", 3))
+
+	definition := testDefinition("counter", "select counter")
+	definition.ExemplarColumns = []string{"trace_id"}
+	c := metric.NewCounter(definition)
+	require.NoError(t, c.Materialize(t.Context(), conn))
+
+	counter := gather(t, c).GetMetric()[0].GetCounter()
+	assert.InDelta(t, 4, counter.GetValue(), 0)
+	assert.Equal(t, "t2", counter.GetExemplar().GetLabel()[0].GetValue())
+	assert.InDelta(t, 3, counter.GetExemplar().GetValue(), 0)
+}
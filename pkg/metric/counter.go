@@ -1,64 +1,96 @@
 package metric
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
 
-	db "github.com/jakthom/hercules/pkg/db"
-	"github.com/jakthom/hercules/pkg/labels"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/rs/zerolog/log"
 )
 
+// Counter exposes the value returned by its SQL as a cumulative total. The underlying value may
+// reset, e.g. when a source is re-materialized, so the exposed series is kept monotonic by
+// carrying the last value seen before each reset forward.
 type Counter struct {
-	Definition Definition
-	Collector  *prometheus.CounterVec
-}
+	collector
 
-func NewCounter(definition Definition) Counter {
-	metric := Counter{
-		Definition: definition,
-	}
-	err := metric.register()
-	if err != nil {
-		log.Error().Err(err).Interface("metric", definition.FullName()).Msg("could not register metric")
-	}
-	return metric
+	Definition Definition
+	mu         sync.Mutex
+	totals     map[string]*cumulativeTotal // Keyed by series, holding only the series of the latest materialization.
 }
 
-func (m *Counter) AsVec() *prometheus.CounterVec {
-	v := prometheus.NewCounterVec(prometheus.CounterOpts{
-		Name: m.Definition.FullName(),
-		Help: m.Definition.Help,
-	}, m.Definition.LabelNames())
-	return v
+// cumulativeTotal tracks the underlying value of a single counter series across materializations.
+type cumulativeTotal struct {
+	last   float64 // Last value returned by the query.
+	offset float64 // Sum of the values observed before each reset.
 }
 
-func (m *Counter) register() error {
-	collector := m.AsVec()
-	err := prometheus.Register(collector)
-	m.Collector = collector
-	return err
+func (c *cumulativeTotal) observe(value float64) float64 {
+	if value < c.last {
+		c.offset += c.last
+	}
+	c.last = value
+	return c.offset + value
 }
 
-func (m *Counter) reregister() error {
-	// godd this is ugly, but it's the only way I've found to make a collector go back to zero (so data isn't dup'd per request)
-	prometheus.Unregister(m.Collector)
-	return m.register()
+func NewCounter(definition Definition) *Counter {
+	return &Counter{
+		collector:  newCollector(definition),
+		Definition: definition,
+		totals:     make(map[string]*cumulativeTotal),
+	}
 }
 
-func (m *Counter) Materialize(conn *sql.Conn) error {
-	err := m.reregister()
-	if err != nil {
-		log.Error().Err(err).Interface("metric", m.Definition.FullName()).Msg("could not materialize metric")
-	}
-	results, err := db.Materialize(conn, m.Definition.SQL)
+func (m *Counter) Materialize(ctx context.Context, conn *sql.Conn) error {
+	results, err := m.materialize(ctx, conn)
 	if err != nil {
-		log.Error().Interface("metric", m.Definition.FullName()).Msg("could not materialize metric")
+		m.store(nil, 0)
 		return err
 	}
-	for _, r := range results {
-		l := labels.Merge(r.StringifiedLabels(), m.Definition.Metadata.Labels)
-		m.Collector.With(map[string]string(l)).Inc()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var metrics []prometheus.Metric
+	var errs []error
+	grouped := m.group(results, m.Definition.Metadata)
+	totals := make(map[string]*cumulativeTotal, len(m.totals))
+	for _, v := range m.values {
+		for _, s := range grouped {
+			// Rows sharing a label set add up to the series total, carrying the exemplar of the last row that has one
+			var value float64
+			var exemplars []prometheus.Exemplar
+			for _, r := range s.results {
+				value += v.of(r)
+				if exemplar, ok := v.exemplar(r); ok {
+					exemplars = []prometheus.Exemplar{exemplar}
+				}
+			}
+			key := v.column + "\xff" + s.key
+			total, ok := m.totals[key]
+			if !ok {
+				total = &cumulativeTotal{}
+			}
+			// Series that disappeared from the query are forgotten, so label churn cannot grow totals without bound
+			totals[key] = total
+			if value < 0 {
+				errs = append(errs, fmt.Errorf("counter %s has negative value %v", v.name, value))
+				continue
+			}
+			cm, constErr := prometheus.NewConstMetric(v.desc, prometheus.CounterValue, total.observe(value), s.labelValues...)
+			if constErr != nil {
+				errs = append(errs, constErr)
+				continue
+			}
+			cm, constErr = m.withExemplars(cm, exemplars)
+			if constErr != nil {
+				errs = append(errs, constErr)
+				continue
+			}
+			metrics = append(metrics, m.withTimestamp(cm, s.timestamp()))
+		}
 	}
-	return nil
+	m.totals = totals
+	m.store(metrics, len(results))
+	return errors.Join(errs...)
 }
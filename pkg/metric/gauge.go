@@ -1,66 +1,47 @@
 package metric
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 
-	db "github.com/jakthom/hercules/pkg/db"
-	"github.com/jakthom/hercules/pkg/labels"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/rs/zerolog/log"
 )
 
 type Gauge struct {
+	collector
+
 	Definition Definition
-	Collector  *prometheus.GaugeVec
 }
 
-func NewGauge(definition Definition) Gauge {
-	// TODO! Turn this into a generic function instead of copy/pasta
-	metric := Gauge{
+func NewGauge(definition Definition) *Gauge {
+	return &Gauge{
+		collector:  newCollector(definition),
 		Definition: definition,
 	}
-	err := metric.register()
-	if err != nil {
-		log.Error().Err(err).Interface("metric", definition.FullName()).Msg("could not register metric")
-	}
-	return metric
-}
-
-func (m *Gauge) asVec() *prometheus.GaugeVec {
-	v := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: m.Definition.FullName(),
-		Help: m.Definition.Help,
-	}, m.Definition.LabelNames())
-	return v
-}
-
-func (m *Gauge) register() error {
-	collector := m.asVec()
-	err := prometheus.Register(collector)
-	m.Collector = collector
-	return err
-}
-
-func (m *Gauge) reregister() error {
-	// godd this is ugly, but it's the only way I've found to make a collector go back to zero (so data isn't dup'd per request)
-	prometheus.Unregister(m.Collector)
-	return m.register()
 }
 
-func (m *Gauge) Materialize(conn *sql.Conn) error {
-	err := m.reregister()
-	if err != nil {
-		log.Error().Err(err).Interface("metric", m.Definition.FullName()).Msg("could not materialize metric")
-	}
-
-	results, err := db.Materialize(conn, m.Definition.SQL)
+func (m *Gauge) Materialize(ctx context.Context, conn *sql.Conn) error {
+	results, err := m.materialize(ctx, conn)
 	if err != nil {
-		log.Error().Interface("metric", m.Definition.FullName()).Msg("could not materialize metric")
+		m.store(nil, 0)
 		return err
 	}
-	for _, r := range results {
-		l := labels.Merge(r.StringifiedLabels(), m.Definition.Metadata.Labels)
-		m.Collector.With(map[string]string(l)).Set(r.Value)
+	var metrics []prometheus.Metric
+	var errs []error
+	grouped := m.group(results, m.Definition.Metadata)
+	for _, v := range m.values {
+		for _, s := range grouped {
+			// The last row for a label set wins
+			last := s.results[len(s.results)-1]
+			cm, constErr := prometheus.NewConstMetric(v.desc, prometheus.GaugeValue, v.of(last), s.labelValues...)
+			if constErr != nil {
+				errs = append(errs, constErr)
+				continue
+			}
+			metrics = append(metrics, m.withTimestamp(cm, last.Timestamp))
+		}
 	}
-	return nil
+	m.store(metrics, len(results))
+	return errors.Join(errs...)
 }
@@ -1,65 +1,129 @@
 package metric
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
 
 	db "github.com/jakthom/hercules/pkg/db"
-	"github.com/jakthom/hercules/pkg/labels"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/rs/zerolog/log"
 )
 
 type Histogram struct {
+	collector
+
 	Definition Definition
-	Collector  *prometheus.HistogramVec
 }
 
-func NewHistogram(definition Definition) Histogram {
-	metric := Histogram{
+func NewHistogram(definition Definition) *Histogram {
+	return &Histogram{
+		collector:  newCollector(definition),
 		Definition: definition,
 	}
-	err := metric.register()
-	if err != nil {
-		log.Error().Err(err).Interface("metric", definition.FullName()).Msg("could not register metric")
-	}
-	return metric
-}
-
-func (m *Histogram) AsVec() *prometheus.HistogramVec {
-	v := prometheus.NewHistogramVec(prometheus.HistogramOpts{
-		Name:    m.Definition.FullName(),
-		Help:    m.Definition.Help,
-		Buckets: m.Definition.Buckets,
-	}, m.Definition.LabelNames())
-	return v
 }
 
-func (m *Histogram) register() error {
-	collector := m.AsVec()
-	err := prometheus.Register(collector)
-	m.Collector = collector
-	return err
+func (m *Histogram) Materialize(ctx context.Context, conn *sql.Conn) error {
+	var numericColumns []string
+	if m.Definition.Preaggregated {
+		numericColumns = []string{BucketColumn, CountColumn, SumColumn}
+	}
+	results, err := m.materialize(ctx, conn, numericColumns...)
+	if err != nil {
+		m.store(nil, 0)
+		return err
+	}
+	// Histograms are fed by a single value column
+	v := m.values[0]
+	var metrics []prometheus.Metric
+	var errs []error
+	for _, s := range m.group(results, m.Definition.Metadata) {
+		var count uint64
+		var sum float64
+		var buckets map[float64]uint64
+		if m.Definition.Preaggregated {
+			var bucketErr error
+			count, sum, buckets, bucketErr = m.accumulate(s.results)
+			if bucketErr != nil {
+				errs = append(errs, bucketErr)
+				continue
+			}
+		} else {
+			count, sum, buckets = m.observe(v, s.results)
+		}
+		cm, constErr := prometheus.NewConstHistogram(v.desc, count, sum, buckets, s.labelValues...)
+		if constErr != nil {
+			errs = append(errs, constErr)
+			continue
+		}
+		// Each bucket carries the exemplar of the last observation it holds
+		var exemplars []prometheus.Exemplar
+		for _, r := range s.results {
+			if exemplar, ok := v.exemplar(r); ok {
+				exemplars = append(exemplars, exemplar)
+			}
+		}
+		cm, constErr = m.withExemplars(cm, exemplars)
+		if constErr != nil {
+			errs = append(errs, constErr)
+			continue
+		}
+		metrics = append(metrics, m.withTimestamp(cm, s.timestamp()))
+	}
+	m.store(metrics, len(results))
+	return errors.Join(errs...)
 }
 
-func (m *Histogram) reregister() error {
-	// godd this is ugly, but it's the only way I've found to make a collector go back to zero (so data isn't dup'd per request)
-	prometheus.Unregister(m.Collector)
-	return m.register()
+// observe treats every row as a single observation and returns the resulting
+// count, sum, and cumulative bucket counts.
+func (m *Histogram) observe(v value, results []db.QueryResult) (uint64, float64, map[float64]uint64) {
+	var sum float64
+	buckets := make(map[float64]uint64, len(m.Definition.Buckets))
+	for _, b := range m.Definition.Buckets {
+		buckets[b] = 0
+	}
+	for _, r := range results {
+		observation := v.of(r)
+		sum += observation
+		for _, b := range m.Definition.Buckets {
+			if observation <= b {
+				buckets[b]++
+			}
+		}
+	}
+	return uint64(len(results)), sum, buckets
 }
 
-func (m *Histogram) Materialize(conn *sql.Conn) error {
-	err := m.reregister()
-	if err != nil {
-		log.Error().Err(err).Interface("metric", m.Definition.FullName()).Msg("could not materialize metric")
-	}
-	results, err := db.Materialize(conn, m.Definition.SQL)
-	if err != nil {
-		log.Error().Interface("metric", m.Definition.FullName()).Msg("could not materialize metric")
-		return err
+// accumulate treats every row as a pre-aggregated bucket holding the count and sum of the
+// observations at or below its le bound and above the previous bucket's bound, and returns the
+// resulting count, sum, and cumulative bucket counts. Configured buckets missing from the results
+// are exposed as empty so every series shares the same bucket layout.
+func (m *Histogram) accumulate(results []db.QueryResult) (uint64, float64, map[float64]uint64, error) {
+	counts := make(map[float64]uint64, len(results))
+	for _, b := range m.Definition.Buckets {
+		counts[b] = 0
 	}
+	var sum float64
 	for _, r := range results {
-		l := labels.Merge(r.StringifiedLabels(), m.Definition.Metadata.Labels)
-		m.Collector.With(map[string]string(l)).Observe(r.Value)
+		le, count := r.Columns[BucketColumn], r.Columns[CountColumn]
+		if math.IsNaN(le) || count < 0 || count != math.Trunc(count) {
+			return 0, 0, nil, fmt.Errorf("histogram %s has invalid bucket le=%v count=%v", m.Definition.FullName(), le, count)
+		}
+		counts[le] += uint64(count)
+		sum += r.Columns[SumColumn]
+	}
+	bounds := slices.Sorted(maps.Keys(counts))
+	buckets := make(map[float64]uint64, len(bounds))
+	var cumulative uint64
+	for _, le := range bounds {
+		cumulative += counts[le]
+		// The +Inf bucket is implied by the total count
+		if !math.IsInf(le, 1) {
+			buckets[le] = cumulative
+		}
 	}
-	return nil
+	return cumulative, sum, buckets, nil
 }
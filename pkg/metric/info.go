@@ -0,0 +1,52 @@
+package metric
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Info exposes every distinct label set returned by its query as a series with the constant value 1.
+// Info metrics are exposed as gauges named with InfoSuffix, or with the info type to OpenMetrics scrapers.
+type Info struct {
+	collector
+
+	Definition Definition
+}
+
+func NewInfo(definition Definition) *Info {
+	definition.Name = strings.TrimSuffix(definition.Name, InfoSuffix) + InfoSuffix
+	return &Info{
+		collector:  newCollector(definition),
+		Definition: definition,
+	}
+}
+
+// FamilyName returns the name of the OpenMetrics metric family, which excludes InfoSuffix.
+func (m *Info) FamilyName() string {
+	return strings.TrimSuffix(m.Definition.FullName(), InfoSuffix)
+}
+
+func (m *Info) Materialize(ctx context.Context, conn *sql.Conn) error {
+	results, err := m.materializeLabels(ctx, conn)
+	if err != nil {
+		m.store(nil, 0)
+		return err
+	}
+	v := m.values[0]
+	var metrics []prometheus.Metric
+	var errs []error
+	for _, s := range m.group(results, m.Definition.Metadata) {
+		cm, constErr := prometheus.NewConstMetric(v.desc, prometheus.GaugeValue, 1, s.labelValues...)
+		if constErr != nil {
+			errs = append(errs, constErr)
+			continue
+		}
+		metrics = append(metrics, m.withTimestamp(cm, s.timestamp()))
+	}
+	m.store(metrics, len(results))
+	return errors.Join(errs...)
+}
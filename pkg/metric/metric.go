@@ -1,23 +1,66 @@
 package metric
 
 import (
+	"context"
 	"database/sql"
+	"maps"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/jakthom/hercules/pkg/db"
+	herculestypes "github.com/jakthom/hercules/pkg/types"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
+// Columns read by the queries of pre-aggregated histograms and summaries.
+const (
+	BucketColumn   = "le"
+	QuantileColumn = "quantile"
+	SumColumn      = "sum"
+	CountColumn    = "count"
+)
+
+// InfoSuffix ends the name every info metric is exposed under.
+const InfoSuffix = "_info"
+
 // Definition defines a metric with its SQL query and metadata.
 type Definition struct {
-	Name       string    `json:"name"`
-	Enabled    bool      `json:"enabled"`
-	Help       string    `json:"help"`
-	SQL        db.SQL    `json:"sql"`
-	Labels     []string  `json:"labels"`
-	Buckets    []float64 `json:"buckets,omitempty"`    // If the metric is a histogram.
-	Objectives []float64 `json:"objectives,omitempty"` // If the metric is a summary.
+	Name       string     `json:"name"`
+	Enabled    *bool      `json:"enabled,omitempty"` // Metrics are enabled unless explicitly disabled.
+	Help       string     `json:"help"`
+	SQL        db.SQL     `json:"sql"`
+	Labels     []string   `json:"labels"`
+	Buckets    []float64  `json:"buckets,omitempty"`    // If the metric is a histogram.
+	Objectives Objectives `json:"objectives,omitempty"` // If the metric is a summary.
+	// If the summary keeps observations across materializations, how long they are kept and how
+	// many buckets the window is divided into.
+	MaxAge     herculestypes.Duration `json:"maxAge,omitempty"`
+	AgeBuckets uint32                 `json:"ageBuckets,omitempty"`
+	// If the histogram or summary query returns buckets or quantiles rather than raw observations.
+	Preaggregated bool `json:"preaggregated,omitempty"`
+	// The column holding the value, instead of one named value, val, or v.
+	ValueColumn string `json:"valueColumn,omitempty"`
+	// Maps each value column of the query to the name of the gauge or counter it feeds.
+	ValueColumns map[string]string `json:"valueColumns,omitempty"`
+	// If the metric is a stateset, the column enumerated into its states and every state it may be in.
+	StateColumn string   `json:"stateColumn,omitempty"`
+	States      []string `json:"states,omitempty"`
+	// The column holding the time each row describes, exposed as the sample timestamp.
+	TimestampColumn string `json:"timestampColumn,omitempty"`
+	// The columns labeling the exemplar attached to histogram and counter samples.
+	ExemplarColumns []string `json:"exemplarColumns,omitempty"`
+	// How NULL labels and values returned by the query are exposed.
+	Nulls db.NullPolicy `json:"nulls"`
+	// How long the query may run before it is interrupted. Defaults to the instance-wide query timeout.
+	Timeout herculestypes.Duration `json:"timeout,omitempty"`
 	// Internal.
-	Metadata Metadata `json:"metadata"`
+	Metadata Metadata `json:"metadata,omitzero"`
+}
+
+// IsEnabled reports whether the metric should be materialized.
+func (m *Definition) IsEnabled() bool {
+	return m.Enabled == nil || *m.Enabled
 }
 
 func (m *Definition) LabelNames() []string {
@@ -29,18 +72,69 @@ func (m *Definition) LabelNames() []string {
 	return names
 }
 
-func (m *Definition) injectLabels(conn *sql.Conn) error {
-	labels, err := db.GetLabelNamesFromQuery(conn, m.SQL)
-	if err != nil {
-		return err
+// valueColumns returns the columns explicitly configured to hold values, in a stable order.
+func (m *Definition) valueColumns() []string {
+	if len(m.ValueColumns) > 0 {
+		return slices.Sorted(maps.Keys(m.ValueColumns))
+	}
+	if m.ValueColumn != "" {
+		return []string{m.ValueColumn}
 	}
-	m.Labels = labels
 	return nil
 }
 
+// inferLabels returns every column of the SQL query other than the value, timestamp, state,
+// exemplar, and reserved columns. Metrics without a value treat conventionally named value columns as labels too.
+func (m *Definition) inferLabels(conn *sql.Conn, reserved []string, valueless bool) ([]string, error) {
+	var columns []string
+	var err error
+	valueColumns := m.valueColumns()
+	if valueless || len(valueColumns) > 0 {
+		columns, err = db.GetColumnNamesFromQuery(conn, m.SQL)
+	} else {
+		columns, err = db.GetLabelNamesFromQuery(conn, m.SQL)
+	}
+	if err != nil {
+		return nil, err
+	}
+	labels := []string{}
+	for _, column := range columns {
+		if !slices.Contains(reserved, column) && !slices.Contains(valueColumns, column) &&
+			column != m.TimestampColumn && column != m.StateColumn && !slices.Contains(m.ExemplarColumns, column) {
+			labels = append(labels, column)
+		}
+	}
+	return labels, nil
+}
+
+// InferLabels returns the labels a metric of metricType defined by m is exposed with, inferred
+// from the columns of its SQL query.
+func (m *Definition) InferLabels(conn *sql.Conn, metricType Type) ([]string, error) {
+	var reserved []string // Columns that are not labels when the metric is pre-aggregated.
+	valueless := false    // If every column is a label.
+	switch metricType {
+	case SummaryType:
+		if m.Preaggregated {
+			reserved = []string{QuantileColumn, SumColumn, CountColumn}
+		}
+	case HistogramType:
+		if m.Preaggregated {
+			reserved = []string{BucketColumn, SumColumn, CountColumn}
+		}
+	case InfoType, StateSetType:
+		valueless = true
+	}
+	return m.inferLabels(conn, reserved, valueless)
+}
+
 // InjectLabels fetches the labels from the SQL query.
 func (m *Definition) InjectLabels(conn *sql.Conn) error {
-	return m.injectLabels(conn)
+	labels, err := m.inferLabels(conn, nil, false)
+	if err != nil {
+		return err
+	}
+	m.Labels = labels
+	return nil
 }
 
 func (m *Definition) injectMetadata(metadata Metadata) {
@@ -48,8 +142,38 @@ func (m *Definition) injectMetadata(metadata Metadata) {
 }
 
 func (m *Definition) FullName() string {
+	return m.fullName(m.Name)
+}
+
+// FullNames returns the full name of every metric the definition feeds: one per value column
+// when valueColumns is set, and the definition's own full name otherwise.
+func (m *Definition) FullNames() []string {
+	if len(m.ValueColumns) == 0 {
+		return []string{m.FullName()}
+	}
+	var names []string
+	for _, column := range slices.Sorted(maps.Keys(m.ValueColumns)) {
+		names = append(names, m.fullName(m.ValueColumns[column]))
+	}
+	return names
+}
+
+// FamilyNames returns the name of every metric family a metric of metricType defined by m is
+// gathered as: its full names, which end in InfoSuffix for info metrics.
+func (m *Definition) FamilyNames(metricType Type) []string {
+	names := m.FullNames()
+	if metricType == InfoType {
+		for i, name := range names {
+			names[i] = strings.TrimSuffix(name, InfoSuffix) + InfoSuffix
+		}
+	}
+	return names
+}
+
+// fullName prefixes name the same way as the definition's own name.
+func (m *Definition) fullName(name string) string {
 	prefix := string(m.Metadata.Prefix) + strings.ReplaceAll(m.Metadata.PackageName, "-", "_") + "_"
-	return prefix + m.Name
+	return prefix + name
 }
 
 // Definitions holds collections of different metric types.
@@ -58,34 +182,82 @@ type Definitions struct {
 	Counter   []*Definition `json:"counter"`
 	Summary   []*Definition `json:"summary"`
 	Histogram []*Definition `json:"histogram"`
+	Info      []*Definition `json:"info"`
+	StateSet  []*Definition `json:"stateset"`
 }
 
 func (m *Definitions) InjectMetadata(conn *sql.Conn, metadata Metadata) error {
-	for _, metricDefinition := range m.Gauge {
-		if err := metricDefinition.injectLabels(conn); err != nil {
+	for _, typed := range m.Typed() {
+		if !typed.Definition.IsEnabled() {
+			continue
+		}
+		labels, err := typed.Definition.InferLabels(conn, typed.Type)
+		if err != nil {
 			return err
 		}
-		metricDefinition.injectMetadata(metadata)
+		typed.Definition.Labels = labels
+		typed.Definition.injectMetadata(metadata)
 	}
-	for _, metricDefinition := range m.Counter {
-		if err := metricDefinition.injectLabels(conn); err != nil {
-			return err
+	return nil
+}
+
+// Toggle enables or disables metrics by name, overriding the enabled flag of their definitions.
+// A metric named in both lists is disabled.
+func (m *Definitions) Toggle(enabled []string, disabled []string) {
+	for _, metricDefinition := range m.all() {
+		var toggle bool
+		switch {
+		case slices.Contains(disabled, metricDefinition.Name):
+			toggle = false
+		case slices.Contains(enabled, metricDefinition.Name):
+			toggle = true
+		default:
+			continue
 		}
-		metricDefinition.injectMetadata(metadata)
+		metricDefinition.Enabled = &toggle
 	}
-	for _, metricDefinition := range m.Summary {
-		if err := metricDefinition.injectLabels(conn); err != nil {
-			return err
+}
+
+// Only returns the definitions of the metric exposed under name, which is either the name of
+// the metric or one of its full names.
+func (m *Definitions) Only(name string) Definitions {
+	only := func(definitions []*Definition) []*Definition {
+		var matched []*Definition
+		for _, metricDefinition := range definitions {
+			if metricDefinition.Name == name || slices.Contains(metricDefinition.FullNames(), name) {
+				matched = append(matched, metricDefinition)
+			}
 		}
-		metricDefinition.injectMetadata(metadata)
+		return matched
 	}
-	for _, metricDefinition := range m.Histogram {
-		if err := metricDefinition.injectLabels(conn); err != nil {
-			return err
+	return Definitions{
+		Gauge:     only(m.Gauge),
+		Counter:   only(m.Counter),
+		Summary:   only(m.Summary),
+		Histogram: only(m.Histogram),
+		Info:      only(m.Info),
+		StateSet:  only(m.StateSet),
+	}
+}
+
+// DefaultTimeout sets the timeout of every metric that does not configure its own.
+func (m *Definitions) DefaultTimeout(timeout time.Duration) {
+	for _, metricDefinition := range m.all() {
+		if metricDefinition.Timeout <= 0 {
+			metricDefinition.Timeout = herculestypes.Duration(timeout)
 		}
-		metricDefinition.injectMetadata(metadata)
 	}
-	return nil
+}
+
+func (m *Definitions) all() []*Definition {
+	var all []*Definition
+	all = append(all, m.Gauge...)
+	all = append(all, m.Counter...)
+	all = append(all, m.Summary...)
+	all = append(all, m.Histogram...)
+	all = append(all, m.Info...)
+	all = append(all, m.StateSet...)
+	return all
 }
 
 func (m *Definitions) Merge(definitions Definitions) {
@@ -93,8 +265,14 @@ func (m *Definitions) Merge(definitions Definitions) {
 	m.Counter = append(m.Counter, definitions.Counter...)
 	m.Summary = append(m.Summary, definitions.Summary...)
 	m.Histogram = append(m.Histogram, definitions.Histogram...)
+	m.Info = append(m.Info, definitions.Info...)
+	m.StateSet = append(m.StateSet, definitions.StateSet...)
 }
 
+// Materializeable is a metric that is populated by running its query and collected as const metrics.
 type Materializeable interface {
-	Materialize(conn *sql.Conn) error
+	prometheus.Collector
+	MetricDefinition() Definition
+	RowsReturned() int
+	Materialize(ctx context.Context, conn *sql.Conn) error
 }
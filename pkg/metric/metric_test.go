@@ -3,9 +3,12 @@ package metric_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jakthom/hercules/pkg/db"
 	"github.com/jakthom/hercules/pkg/metric"
+	herculestypes "github.com/jakthom/hercules/pkg/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -151,6 +154,33 @@ func TestDefinition_InjectLabels(t *testing.T) {
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
+func TestDefinition_InjectLabelsWithValueColumn(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	require.NoError(t, err)
+	defer db.Close()
+
+	conn, err := db.Conn(t.Context())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// With an explicit value column, magic names such as "v" are ordinary labels
+	mock.ExpectQuery("json_serialize_sql").
+		WillReturnRows(sqlmock.NewRows([]string{"column"}).
+			AddRow("v").
+			AddRow("count(*)").
+			AddRow("total"))
+
+	metricDef := &metric.Definition{
+		Name:        "test_metric",
+		SQL:         "SELECT * FROM test_table",
+		ValueColumn: "total",
+	}
+
+	require.NoError(t, metricDef.InjectLabels(conn))
+	assert.Equal(t, []string{"v", "count(*)"}, metricDef.Labels)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestDefinitions_Merge(t *testing.T) {
 	base := metric.Definitions{
 		Gauge: []*metric.Definition{
@@ -243,3 +273,182 @@ func TestDefinitions_InjectMetadata(t *testing.T) {
 	// Verify all expectations were met
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
+
+func TestDefinitions_InjectMetadataSkipsDisabled(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	require.NoError(t, err)
+	defer db.Close()
+
+	conn, err := db.Conn(t.Context())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Only the enabled metric is parsed
+	mock.ExpectQuery("json_serialize_sql\\('SELECT 1'\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"column"}).AddRow("val"))
+
+	disabled := false
+	metrics := metric.Definitions{
+		Gauge: []*metric.Definition{
+			{Name: "enabled", SQL: "SELECT 1"},
+			{Name: "disabled", SQL: "SELECT 2", Enabled: &disabled},
+		},
+	}
+
+	require.NoError(t, metrics.InjectMetadata(conn, metric.Metadata{PackageName: "test"}))
+	assert.Equal(t, "test", metrics.Gauge[0].Metadata.PackageName)
+	assert.Empty(t, metrics.Gauge[1].Metadata.PackageName)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDefinitions_Toggle(t *testing.T) {
+	enabled, disabled := true, false
+	metrics := metric.Definitions{
+		Gauge: []*metric.Definition{
+			{Name: "default"},
+			{Name: "turned_on", Enabled: &disabled},
+			{Name: "turned_off", Enabled: &enabled},
+			{Name: "both"},
+		},
+		Histogram: []*metric.Definition{
+			{Name: "expensive"},
+		},
+	}
+
+	metrics.Toggle([]string{"turned_on", "both"}, []string{"turned_off", "both", "expensive"})
+
+	assert.True(t, metrics.Gauge[0].IsEnabled(), "metrics are enabled by default")
+	assert.True(t, metrics.Gauge[1].IsEnabled())
+	assert.False(t, metrics.Gauge[2].IsEnabled())
+	assert.False(t, metrics.Gauge[3].IsEnabled(), "disabling takes precedence over enabling")
+	assert.False(t, metrics.Histogram[0].IsEnabled())
+}
+
+func TestDefinitions_Only(t *testing.T) {
+	metadata := metric.Metadata{PackageName: "snowflake"}
+	metrics := metric.Definitions{
+		Gauge: []*metric.Definition{
+			{Name: "query_count", Metadata: metadata},
+			{Name: "credits", ValueColumns: map[string]string{"used": "credits_used"}, Metadata: metadata},
+		},
+		Histogram: []*metric.Definition{
+			{Name: "query_count", Metadata: metadata},
+		},
+	}
+
+	only := metrics.Only("query_count")
+	assert.Len(t, only.Gauge, 1)
+	assert.Len(t, only.Histogram, 1)
+	assert.Equal(t, []*metric.Definition{metrics.Gauge[1]}, metrics.Only("snowflake_credits_used").Gauge)
+	missing := metrics.Only("missing")
+	assert.Empty(t, missing.Typed())
+}
+
+func TestDefinitions_InjectMetadataExcludesPreaggregatedColumns(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	require.NoError(t, err)
+	defer db.Close()
+
+	conn, err := db.Conn(t.Context())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	mock.ExpectQuery("json_serialize_sql\\('SELECT 1'\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"column"}).AddRow("user").AddRow("quantile").AddRow("sum").AddRow("count"))
+	mock.ExpectQuery("json_serialize_sql\\('SELECT 2'\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"column"}).AddRow("user").AddRow("le").AddRow("sum").AddRow("count"))
+
+	metrics := metric.Definitions{
+		Summary:   []*metric.Definition{{Name: "summary", SQL: "SELECT 1", Preaggregated: true}},
+		Histogram: []*metric.Definition{{Name: "histogram", SQL: "SELECT 2", Preaggregated: true}},
+	}
+
+	require.NoError(t, metrics.InjectMetadata(conn, metric.Metadata{}))
+	assert.Equal(t, []string{"user"}, metrics.Summary[0].Labels)
+	assert.Equal(t, []string{"user"}, metrics.Histogram[0].Labels)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDefinitions_Validate(t *testing.T) {
+	valid := metric.Definitions{
+		Summary:   []*metric.Definition{{Name: "summary", SQL: "select 1", Objectives: metric.Objectives{0.5: 0.05}, MaxAge: 600e9, AgeBuckets: 5}},
+		Histogram: []*metric.Definition{{Name: "buckets", SQL: "select 1", Preaggregated: true}},
+	}
+	require.NoError(t, valid.Validate())
+
+	invalid := metric.Definitions{
+		Summary: []*metric.Definition{
+			{Name: "bad_quantile", SQL: "select 1", Objectives: metric.Objectives{2: 0.05}},
+			{Name: "bad_max_age", SQL: "select 1", MaxAge: -1},
+			{Name: "unwindowed", SQL: "select 1", AgeBuckets: 5},
+			{Name: "preaggregated", SQL: "select 1", Preaggregated: true, MaxAge: 600e9},
+		},
+	}
+	err := invalid.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "summary[0].objectives: objective quantile 2 must be between 0 and 1")
+	assert.Contains(t, err.Error(), "summary[1].maxAge: maxAge must not be negative")
+	assert.Contains(t, err.Error(), "summary[2].ageBuckets: ageBuckets requires maxAge")
+	assert.Contains(t, err.Error(), "summary[3].maxAge: maxAge is not supported by pre-aggregated summaries")
+
+	conflicting := metric.Definitions{
+		Gauge:     []*metric.Definition{{Name: "both", SQL: "select 1", ValueColumn: "a", ValueColumns: map[string]string{"b": "b"}}},
+		Counter:   []*metric.Definition{{Name: "nulls", SQL: "select 1", Nulls: db.NullPolicy{Values: "ignore"}}},
+		Histogram: []*metric.Definition{{Name: "multi", SQL: "select 1", Buckets: []float64{1}, ValueColumns: map[string]string{"b": "b"}}},
+	}
+	err = conflicting.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "gauge[0].valueColumns: valueColumn and valueColumns are mutually exclusive")
+	assert.Contains(t, err.Error(), "histogram[0].valueColumns: valueColumns is only supported by gauges and counters")
+	assert.Contains(t, err.Error(), "counter[0].nulls: unsupported null value policy ignore")
+
+	invalidStateSets := metric.Definitions{
+		Gauge:    []*metric.Definition{{Name: "gauge", SQL: "select 1", StateColumn: "status"}},
+		Info:     []*metric.Definition{{Name: "info", SQL: "select 1", ValueColumn: "value"}},
+		StateSet: []*metric.Definition{{Name: "stateset", SQL: "select 1", States: []string{"a", "a"}}},
+	}
+	err = invalidStateSets.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "gauge[0].stateColumn: stateColumn is only supported by statesets")
+	assert.Contains(t, err.Error(), "info[0].valueColumn: info and stateset metrics have no value column")
+	assert.Contains(t, err.Error(), "stateset[0].stateColumn: stateColumn is required")
+	assert.Contains(t, err.Error(), "stateset[0].states: states must be unique")
+
+	invalidExemplars := metric.Definitions{
+		Gauge:     []*metric.Definition{{Name: "gauge", SQL: "select 1", ExemplarColumns: []string{"query_id"}}},
+		Histogram: []*metric.Definition{{Name: "buckets", SQL: "select 1", Preaggregated: true, ExemplarColumns: []string{"query_id"}}},
+	}
+	err = invalidExemplars.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "gauge[0].exemplarColumns: exemplarColumns is only supported by histograms and counters")
+	assert.Contains(t, err.Error(), "histogram[0].exemplarColumns: exemplarColumns is not supported by pre-aggregated histograms")
+
+	invalidNames := metric.Definitions{
+		Gauge: []*metric.Definition{
+			{Name: "query-count", Labels: []string{"user", "warehouse-name"}, ValueColumns: map[string]string{"bytes": "scanned bytes"}},
+		},
+		Histogram: []*metric.Definition{{Name: "durations", SQL: "select 1", ExemplarColumns: []string{"query.id"}}},
+	}
+	err = invalidNames.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `gauge[0].name: "query-count" is not a valid metric name`)
+	assert.Contains(t, err.Error(), "gauge[0].sql: sql is required")
+	assert.Contains(t, err.Error(), `gauge[0].labels[1]: "warehouse-name" is not a valid label name`)
+	assert.Contains(t, err.Error(), `gauge[0].valueColumns.bytes: "scanned bytes" is not a valid metric name`)
+	assert.Contains(t, err.Error(), `histogram[0].exemplarColumns[0]: "query.id" is not a valid label name`)
+	assert.Contains(t, err.Error(), "histogram[0].buckets: histogram requires buckets")
+}
+
+func TestDefinitions_DefaultTimeout(t *testing.T) {
+	metrics := metric.Definitions{
+		Gauge: []*metric.Definition{
+			{Name: "default"},
+			{Name: "configured", Timeout: herculestypes.Duration(time.Minute)},
+		},
+	}
+
+	metrics.DefaultTimeout(30 * time.Second)
+
+	assert.Equal(t, 30*time.Second, metrics.Gauge[0].Timeout.Duration())
+	assert.Equal(t, time.Minute, metrics.Gauge[1].Timeout.Duration(), "configured timeouts are kept")
+}
@@ -0,0 +1,69 @@
+package metric
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+)
+
+// Objectives maps each quantile of a summary to its allowed absolute error. They are configured
+// either as a quantile to error map or as a list of quantiles using default errors.
+type Objectives map[float64]float64
+
+// DefaultObjectiveError returns the error used for a quantile configured without one: a tenth
+// of its distance to the nearest end of the distribution, e.g. 0.5 -> 0.05, 0.99 -> 0.001.
+func DefaultObjectiveError(quantile float64) float64 {
+	return min(quantile, 1-quantile) / 10
+}
+
+// Validate checks that every quantile is within [0, 1] and its error is within [0, 1). Every
+// problem is reported, in the order of the quantiles.
+func (o Objectives) Validate() error {
+	var errs []error
+	for _, quantile := range slices.Sorted(maps.Keys(o)) {
+		if quantile < 0 || quantile > 1 {
+			errs = append(errs, fmt.Errorf("objective quantile %v must be between 0 and 1", quantile))
+		}
+		if objectiveError := o[quantile]; objectiveError < 0 || objectiveError >= 1 {
+			errs = append(errs, fmt.Errorf("objective error %v for quantile %v must be at least 0 and less than 1", objectiveError, quantile))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (o Objectives) MarshalJSON() ([]byte, error) {
+	tolerances := make(map[string]float64, len(o))
+	for quantile, objectiveError := range o {
+		tolerances[strconv.FormatFloat(quantile, 'g', -1, 64)] = objectiveError
+	}
+	return json.Marshal(tolerances)
+}
+
+func (o *Objectives) UnmarshalJSON(data []byte) error {
+	var quantiles []float64
+	if err := json.Unmarshal(data, &quantiles); err == nil {
+		objectives := make(Objectives, len(quantiles))
+		for _, q := range quantiles {
+			objectives[q] = DefaultObjectiveError(q)
+		}
+		*o = objectives
+		return nil
+	}
+	var tolerances map[string]float64
+	if err := json.Unmarshal(data, &tolerances); err != nil {
+		return fmt.Errorf("objectives must be a list of quantiles or a map of quantiles to errors: %w", err)
+	}
+	objectives := make(Objectives, len(tolerances))
+	for key, objectiveError := range tolerances {
+		quantile, err := strconv.ParseFloat(key, 64)
+		if err != nil {
+			return fmt.Errorf("invalid objective quantile %q: %w", key, err)
+		}
+		objectives[quantile] = objectiveError
+	}
+	*o = objectives
+	return nil
+}
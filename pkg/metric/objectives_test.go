@@ -0,0 +1,55 @@
+package metric_test
+
+import (
+	"testing"
+
+	"github.com/jakthom/hercules/pkg/metric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/yaml"
+)
+
+func TestObjectives_UnmarshalList(t *testing.T) {
+	var objectives metric.Objectives
+	require.NoError(t, yaml.Unmarshal([]byte("[0.5, 0.9, 0.99]"), &objectives))
+
+	require.Len(t, objectives, 3)
+	assert.InDelta(t, 0.05, objectives[0.5], 1e-12)
+	assert.InDelta(t, 0.01, objectives[0.9], 1e-12)
+	assert.InDelta(t, 0.001, objectives[0.99], 1e-12)
+}
+
+func TestObjectives_UnmarshalMap(t *testing.T) {
+	var objectives metric.Objectives
+	require.NoError(t, yaml.Unmarshal([]byte("{0.5: 0.01, 0.999: 0.0001}"), &objectives))
+
+	assert.Equal(t, metric.Objectives{0.5: 0.01, 0.999: 0.0001}, objectives)
+}
+
+func TestObjectives_RoundTrip(t *testing.T) {
+	objectives := metric.Objectives{0.5: 0.05, 0.99: 0.001}
+	serialized, err := yaml.Marshal(objectives)
+	require.NoError(t, err)
+
+	var deserialized metric.Objectives
+	require.NoError(t, yaml.Unmarshal(serialized, &deserialized))
+	assert.Equal(t, objectives, deserialized)
+}
+
+func TestObjectives_UnmarshalInvalid(t *testing.T) {
+	var objectives metric.Objectives
+	assert.Error(t, yaml.Unmarshal([]byte("{median: 0.05}"), &objectives))
+	assert.Error(t, yaml.Unmarshal([]byte("0.5"), &objectives))
+}
+
+func TestObjectives_Validate(t *testing.T) {
+	assert.NoError(t, metric.Objectives{0.5: 0.05, 1: 0}.Validate())
+	assert.ErrorContains(t, metric.Objectives{1.5: 0.05}.Validate(), "must be between 0 and 1")
+	assert.ErrorContains(t, metric.Objectives{0.5: -0.1}.Validate(), "less than 1")
+	assert.ErrorContains(t, metric.Objectives{0.5: 1}.Validate(), "less than 1")
+
+	// Every problem is reported, ordered by quantile
+	assert.EqualError(t, metric.Objectives{2: 0.05, 0.9: 1, -1: 0.5}.Validate(), "objective quantile -1 must be between 0 and 1\n"+
+		"objective error 1 for quantile 0.9 must be at least 0 and less than 1\n"+
+		"objective quantile 2 must be between 0 and 1")
+}
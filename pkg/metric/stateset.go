@@ -0,0 +1,72 @@
+package metric
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// StateSet enumerates the state column of its query into one boolean series per state. A state is
+// set when a row with the series' labels is in it. StateSets are exposed as gauges labeled with
+// their own name, or with the stateset type to OpenMetrics scrapers.
+type StateSet struct {
+	collector
+
+	Definition Definition
+}
+
+func NewStateSet(definition Definition) *StateSet {
+	return &StateSet{
+		collector:  newCollector(definition, definition.FullName()),
+		Definition: definition,
+	}
+}
+
+func (m *StateSet) Materialize(ctx context.Context, conn *sql.Conn) error {
+	results, err := m.materializeLabels(ctx, conn)
+	if err != nil {
+		m.store(nil, 0)
+		return err
+	}
+	v := m.values[0]
+	var metrics []prometheus.Metric
+	var errs []error
+	for _, s := range m.group(results, m.Definition.Metadata) {
+		set := make(map[string]bool, len(s.results))
+		for _, r := range s.results {
+			set[r.Labels[m.Definition.StateColumn]] = true
+		}
+		for _, state := range m.states(set) {
+			var value float64
+			if set[state] {
+				value = 1
+			}
+			labelValues := append(slices.Clone(s.labelValues), state)
+			cm, constErr := prometheus.NewConstMetric(v.desc, prometheus.GaugeValue, value, labelValues...)
+			if constErr != nil {
+				errs = append(errs, constErr)
+				continue
+			}
+			metrics = append(metrics, m.withTimestamp(cm, s.timestamp()))
+		}
+	}
+	m.store(metrics, len(results))
+	return errors.Join(errs...)
+}
+
+// states returns the configured states followed by any other state in set, so every series
+// exposes the same states in the same order.
+func (m *StateSet) states(set map[string]bool) []string {
+	states := slices.Clone(m.Definition.States)
+	var unexpected []string
+	for state := range set {
+		if !slices.Contains(states, state) {
+			unexpected = append(unexpected, state)
+		}
+	}
+	slices.Sort(unexpected)
+	return append(states, unexpected...)
+}
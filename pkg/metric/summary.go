@@ -1,69 +1,141 @@
 package metric
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
 
 	db "github.com/jakthom/hercules/pkg/db"
-	"github.com/jakthom/hercules/pkg/labels"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/rs/zerolog/log"
+	dto "github.com/prometheus/client_model/go"
 )
 
+// Summary estimates quantiles of the values returned by its SQL. Without a maxAge, every
+// materialization is summarized on its own. With one, every series observes the rows of each
+// materialization into a summary kept across materializations, whose quantiles cover the
+// observations of the last maxAge.
 type Summary struct {
+	collector
+
 	Definition Definition
-	Collector  *prometheus.SummaryVec
+	mu         sync.Mutex
+	windows    map[string]prometheus.Summary // Keyed by series, holding only the series of the latest materialization.
 }
 
-func NewSummary(definition Definition) Summary {
-	metric := Summary{
+func NewSummary(definition Definition) *Summary {
+	return &Summary{
+		collector:  newCollector(definition),
 		Definition: definition,
+		windows:    make(map[string]prometheus.Summary),
 	}
-	err := metric.register()
+}
+
+func (m *Summary) Materialize(ctx context.Context, conn *sql.Conn) error {
+	var numericColumns []string
+	if m.Definition.Preaggregated {
+		numericColumns = []string{QuantileColumn, CountColumn, SumColumn}
+	}
+	results, err := m.materialize(ctx, conn, numericColumns...)
 	if err != nil {
-		log.Error().Err(err).Interface("metric", definition.FullName()).Msg("could not register metric")
+		m.store(nil, 0)
+		return err
 	}
-	return metric
+	// Summaries are fed by a single value column
+	v := m.values[0]
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var metrics []prometheus.Metric
+	var errs []error
+	windows := make(map[string]prometheus.Summary, len(m.windows))
+	for _, s := range m.group(results, m.Definition.Metadata) {
+		var summary *dto.Summary
+		var summaryErr error
+		switch {
+		case m.Definition.Preaggregated:
+			summary, summaryErr = m.accumulate(v, s.results)
+		case m.Definition.MaxAge > 0:
+			window, ok := m.windows[s.key]
+			if !ok {
+				window = m.newSummary()
+			}
+			// Series that disappeared from the query are forgotten, so label churn cannot grow windows without bound
+			windows[s.key] = window
+			summary, summaryErr = observe(window, v, s.results)
+		default:
+			summary, summaryErr = observe(m.newSummary(), v, s.results)
+		}
+		if summaryErr != nil {
+			errs = append(errs, summaryErr)
+			continue
+		}
+		quantiles := make(map[float64]float64, len(summary.GetQuantile()))
+		for _, q := range summary.GetQuantile() {
+			quantiles[q.GetQuantile()] = q.GetValue()
+		}
+		cm, constErr := prometheus.NewConstSummary(
+			v.desc, summary.GetSampleCount(), summary.GetSampleSum(), quantiles, s.labelValues...,
+		)
+		if constErr != nil {
+			errs = append(errs, constErr)
+			continue
+		}
+		metrics = append(metrics, m.withTimestamp(cm, s.timestamp()))
+	}
+	m.windows = windows
+	m.store(metrics, len(results))
+	return errors.Join(errs...)
 }
 
-func (m *Summary) AsVec() *prometheus.SummaryVec {
-	objectives := make(map[float64]float64)
-	for _, o := range m.Definition.Objectives {
-		objectives[o] = o
-	}
-	v := prometheus.NewSummaryVec(prometheus.SummaryOpts{
+// newSummary returns a prometheus summary estimating the objectives of the definition over its
+// sliding window.
+func (m *Summary) newSummary() prometheus.Summary {
+	return prometheus.NewSummary(prometheus.SummaryOpts{
 		Name:       m.Definition.FullName(),
 		Help:       m.Definition.Help,
-		Objectives: objectives,
-	}, m.Definition.LabelNames())
-	return v
-}
-
-func (m *Summary) register() error {
-	collector := m.AsVec()
-	err := prometheus.Register(collector)
-	m.Collector = collector
-	return err
+		Objectives: m.Definition.Objectives,
+		MaxAge:     m.Definition.MaxAge.Duration(),
+		AgeBuckets: m.Definition.AgeBuckets,
+	})
 }
 
-func (m *Summary) reregister() error {
-	// godd this is ugly, but it's the only way I've found to make a collector go back to zero (so data isn't dup'd per request)
-	prometheus.Unregister(m.Collector)
-	return m.register()
-}
-
-func (m *Summary) Materialize(conn *sql.Conn) error {
-	err := m.reregister()
-	if err != nil {
-		log.Error().Err(err).Interface("metric", m.Definition.FullName()).Msg("could not materialize metric")
+// observe streams every row through a prometheus summary so quantiles are estimated exactly as a
+// live summary would, then returns the resulting snapshot.
+func observe(summary prometheus.Summary, v value, results []db.QueryResult) (*dto.Summary, error) {
+	for _, r := range results {
+		summary.Observe(v.of(r))
 	}
-	results, err := db.Materialize(conn, m.Definition.SQL)
-	if err != nil {
-		log.Error().Interface("metric", m.Definition.FullName()).Msg("could not materialize metric")
-		return err
+	var out dto.Metric
+	if err := summary.Write(&out); err != nil {
+		return nil, err
 	}
+	return out.GetSummary(), nil
+}
+
+// accumulate treats every row as a pre-aggregated quantile along with the count and sum of all
+// observations in the series, and returns the resulting snapshot.
+func (m *Summary) accumulate(v value, results []db.QueryResult) (*dto.Summary, error) {
+	summary := &dto.Summary{}
 	for _, r := range results {
-		l := labels.Merge(r.StringifiedLabels(), m.Definition.Metadata.Labels)
-		m.Collector.With(map[string]string(l)).Observe(r.Value)
+		q, count, sum := r.Columns[QuantileColumn], r.Columns[CountColumn], r.Columns[SumColumn]
+		if q < 0 || q > 1 || math.IsNaN(q) {
+			return nil, fmt.Errorf("summary %s has invalid quantile %v", m.Definition.FullName(), q)
+		}
+		if count < 0 || count != math.Trunc(count) {
+			return nil, fmt.Errorf("summary %s has invalid count %v", m.Definition.FullName(), count)
+		}
+		for _, existing := range summary.GetQuantile() {
+			if existing.GetQuantile() == q {
+				return nil, fmt.Errorf("summary %s has duplicate quantile %v", m.Definition.FullName(), q)
+			}
+		}
+		value, sampleCount := v.of(r), uint64(count)
+		summary.Quantile = append(summary.Quantile, &dto.Quantile{Quantile: &q, Value: &value})
+		// Count and sum describe the whole series and are repeated on every row
+		summary.SampleCount = &sampleCount
+		summary.SampleSum = &sum
 	}
-	return nil
+	return summary, nil
 }
@@ -0,0 +1,155 @@
+package metric
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	herculestypes "github.com/jakthom/hercules/pkg/types"
+	"github.com/prometheus/common/model"
+)
+
+// Type is the type of metric a definition defines, as it is keyed in a package.
+type Type string
+
+const (
+	GaugeType     Type = "gauge"
+	CounterType   Type = "counter"
+	SummaryType   Type = "summary"
+	HistogramType Type = "histogram"
+	InfoType      Type = "info"
+	StateSetType  Type = "stateset"
+)
+
+// TypedDefinition is a definition along with the type of metric it defines.
+type TypedDefinition struct {
+	Type       Type
+	Definition *Definition
+}
+
+// Typed returns every definition along with the type of metric it defines.
+func (m *Definitions) Typed() []TypedDefinition {
+	var typed []TypedDefinition
+	for _, group := range []struct {
+		metricType  Type
+		definitions []*Definition
+	}{
+		{GaugeType, m.Gauge},
+		{CounterType, m.Counter},
+		{SummaryType, m.Summary},
+		{HistogramType, m.Histogram},
+		{InfoType, m.Info},
+		{StateSetType, m.StateSet},
+	} {
+		for _, definition := range group.definitions {
+			typed = append(typed, TypedDefinition{Type: group.metricType, Definition: definition})
+		}
+	}
+	return typed
+}
+
+// Validate checks the definitions for settings that cannot be materialized. Every problem is
+// reported as a *herculestypes.FieldError locating the field, e.g. histogram[2].buckets.
+func (m *Definitions) Validate() error {
+	var errs []error
+	indexes := make(map[Type]int)
+	for _, typed := range m.Typed() {
+		path := fmt.Sprintf("%s[%d]", typed.Type, indexes[typed.Type])
+		indexes[typed.Type]++
+		errs = append(errs, herculestypes.Nest(typed.Definition.validate(typed.Type), path))
+	}
+	return errors.Join(errs...)
+}
+
+func (m *Definition) validate(metricType Type) error {
+	var errs []error
+	fieldErrorf := func(path string, format string, args ...any) {
+		errs = append(errs, herculestypes.FieldErrorf(path, format, args...))
+	}
+
+	switch {
+	case m.Name == "":
+		fieldErrorf("name", "name is required")
+	case !model.IsValidLegacyMetricName(m.Name):
+		fieldErrorf("name", "%q is not a valid metric name", m.Name)
+	}
+	if m.SQL == "" {
+		fieldErrorf("sql", "sql is required")
+	}
+	for i, label := range m.Labels {
+		if !model.LabelName(label).IsValidLegacy() {
+			fieldErrorf(fmt.Sprintf("labels[%d]", i), "%q is not a valid label name", label)
+		}
+	}
+	if err := m.Nulls.Validate(); err != nil {
+		errs = append(errs, herculestypes.Nest(err, "nulls"))
+	}
+
+	if m.ValueColumn != "" && len(m.ValueColumns) > 0 {
+		fieldErrorf("valueColumns", "valueColumn and valueColumns are mutually exclusive")
+	}
+	for _, column := range slices.Sorted(maps.Keys(m.ValueColumns)) {
+		if name := m.ValueColumns[column]; !model.IsValidLegacyMetricName(name) {
+			fieldErrorf("valueColumns."+column, "%q is not a valid metric name", name)
+		}
+	}
+	switch metricType {
+	case SummaryType, HistogramType:
+		if len(m.ValueColumns) > 0 {
+			fieldErrorf("valueColumns", "valueColumns is only supported by gauges and counters")
+		}
+	case InfoType, StateSetType:
+		if m.ValueColumn != "" || len(m.ValueColumns) > 0 {
+			fieldErrorf("valueColumn", "info and stateset metrics have no value column")
+		}
+	}
+
+	if metricType != StateSetType && m.StateColumn != "" {
+		fieldErrorf("stateColumn", "stateColumn is only supported by statesets")
+	}
+	if metricType == StateSetType {
+		if m.StateColumn == "" {
+			fieldErrorf("stateColumn", "stateColumn is required")
+		}
+		if len(slices.Compact(slices.Sorted(slices.Values(m.States)))) != len(m.States) {
+			fieldErrorf("states", "states must be unique")
+		}
+	}
+
+	if len(m.ExemplarColumns) > 0 {
+		switch {
+		case metricType != HistogramType && metricType != CounterType:
+			fieldErrorf("exemplarColumns", "exemplarColumns is only supported by histograms and counters")
+		case metricType == HistogramType && m.Preaggregated:
+			fieldErrorf("exemplarColumns", "exemplarColumns is not supported by pre-aggregated histograms")
+		}
+	}
+	for i, column := range m.ExemplarColumns {
+		if !model.LabelName(column).IsValidLegacy() {
+			fieldErrorf(fmt.Sprintf("exemplarColumns[%d]", i), "%q is not a valid label name", column)
+		}
+	}
+
+	switch metricType {
+	case SummaryType:
+		if err := m.Objectives.Validate(); err != nil {
+			errs = append(errs, herculestypes.Nest(err, "objectives"))
+		}
+		switch {
+		case m.MaxAge < 0:
+			fieldErrorf("maxAge", "maxAge must not be negative")
+		case m.MaxAge > 0 && m.Preaggregated:
+			// Pre-aggregated summaries read their quantiles from the query rather than observing rows
+			fieldErrorf("maxAge", "maxAge is not supported by pre-aggregated summaries")
+		case m.MaxAge == 0 && m.AgeBuckets != 0:
+			fieldErrorf("ageBuckets", "ageBuckets requires maxAge")
+		}
+	case HistogramType:
+		// Pre-aggregated histograms read their buckets from the query
+		if len(m.Buckets) == 0 && !m.Preaggregated {
+			fieldErrorf("buckets", "histogram requires buckets")
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -1,66 +1,303 @@
 package registry
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"time"
 
 	"github.com/jakthom/hercules/pkg/metric"
+	"github.com/jakthom/hercules/pkg/openmetrics"
+	"github.com/jakthom/hercules/pkg/telemetry"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog/log"
 )
 
+// Snapshot is an immutable set of metrics captured by a single materialization of a registry,
+// along with a report of every metric that failed to materialize.
+type Snapshot struct {
+	Metrics        []prometheus.Metric
+	Errors         []*MaterializationError
+	MaterializedAt time.Time
+}
+
+// MaterializationError records a single metric definition that failed to materialize.
+type MaterializationError struct {
+	Package string
+	Metric  string
+	Err     error
+}
+
+func (e *MaterializationError) Error() string {
+	return fmt.Sprintf("could not materialize metric %s of package %s: %v", e.Metric, e.Package, e.Err)
+}
+
+func (e *MaterializationError) Unwrap() error {
+	return e.Err
+}
+
 type MetricRegistry struct {
-	Gauge     map[string]metric.Gauge
-	Counter   map[string]metric.Counter
-	Summary   map[string]metric.Summary
-	Histogram map[string]metric.Histogram
+	Gauge     map[string]*metric.Gauge
+	Counter   map[string]*metric.Counter
+	Summary   map[string]*metric.Summary
+	Histogram map[string]*metric.Histogram
+	Info      map[string]*metric.Info
+	StateSet  map[string]*metric.StateSet
+	snapshot  atomic.Pointer[Snapshot]
+	registry  *prometheus.Registry // Isolates this registry's metrics from every other package.
+	telemetry *telemetry.Telemetry
 }
 
+// NewMetricRegistry builds a registry holding every enabled metric in definitions.
 func NewMetricRegistry(definitions metric.Definitions) *MetricRegistry {
+	r := newMetricRegistry(definitions)
+	r.register()
+	return r
+}
+
+// Reload builds a registry holding every enabled metric in definitions in place of mr. Metrics
+// whose definition is unchanged are carried over from mr along with their state, so counters keep
+// counting across reloads. Self-metrics of the metrics that were removed are no longer exported.
+func (mr *MetricRegistry) Reload(definitions metric.Definitions) *MetricRegistry {
+	r := newMetricRegistry(definitions)
+	reuse(r.Gauge, mr.Gauge)
+	reuse(r.Counter, mr.Counter)
+	reuse(r.Summary, mr.Summary)
+	reuse(r.Histogram, mr.Histogram)
+	reuse(r.Info, mr.Info)
+	reuse(r.StateSet, mr.StateSet)
+	r.register()
+
+	names := make(map[string]bool)
+	for _, m := range r.metrics() {
+		definition := m.MetricDefinition()
+		names[definition.FullName()] = true
+	}
+	for _, m := range mr.metrics() {
+		if definition := m.MetricDefinition(); !names[definition.FullName()] {
+			mr.telemetry.ForgetMetric(definition.Metadata.PackageName, definition.FullName())
+		}
+	}
+	return r
+}
+
+// Remove stops exporting self-metrics about every metric in a registry that is no longer used.
+func (mr *MetricRegistry) Remove() {
+	for _, m := range mr.metrics() {
+		definition := m.MetricDefinition()
+		mr.telemetry.ForgetMetric(definition.Metadata.PackageName, definition.FullName())
+	}
+}
+
+// reuse replaces every metric of next with the metric of the same name in previous if both
+// have the same definition.
+func reuse[M metric.Materializeable](next map[string]M, previous map[string]M) {
+	for name, m := range next {
+		if old, ok := previous[name]; ok && reflect.DeepEqual(old.MetricDefinition(), m.MetricDefinition()) {
+			next[name] = old
+		}
+	}
+}
+
+func newMetricRegistry(definitions metric.Definitions) *MetricRegistry {
 	r := MetricRegistry{}
-	r.Gauge = make(map[string]metric.Gauge)
-	r.Histogram = make(map[string]metric.Histogram)
-	r.Summary = make(map[string]metric.Summary)
-	r.Counter = make(map[string]metric.Counter)
+	r.Gauge = make(map[string]*metric.Gauge)
+	r.Histogram = make(map[string]*metric.Histogram)
+	r.Summary = make(map[string]*metric.Summary)
+	r.Counter = make(map[string]*metric.Counter)
+	r.Info = make(map[string]*metric.Info)
+	r.StateSet = make(map[string]*metric.StateSet)
 
 	for _, definition := range definitions.Gauge {
+		if !definition.IsEnabled() {
+			continue
+		}
 		g := metric.NewGauge(*definition)
 		r.Gauge[g.Definition.FullName()] = g
 	}
 	for _, definition := range definitions.Histogram {
+		if !definition.IsEnabled() {
+			continue
+		}
 		h := metric.NewHistogram(*definition)
 		r.Histogram[h.Definition.FullName()] = h
 	}
 	for _, definition := range definitions.Summary {
+		if !definition.IsEnabled() {
+			continue
+		}
 		s := metric.NewSummary(*definition)
 		r.Summary[s.Definition.FullName()] = s
 	}
 	for _, definition := range definitions.Counter {
+		if !definition.IsEnabled() {
+			continue
+		}
 		c := metric.NewCounter(*definition)
 		r.Counter[c.Definition.FullName()] = c
 	}
+	for _, definition := range definitions.Info {
+		if !definition.IsEnabled() {
+			continue
+		}
+		i := metric.NewInfo(*definition)
+		r.Info[i.Definition.FullName()] = i
+	}
+	for _, definition := range definitions.StateSet {
+		if !definition.IsEnabled() {
+			continue
+		}
+		s := metric.NewStateSet(*definition)
+		r.StateSet[s.Definition.FullName()] = s
+	}
 	return &r
 }
 
-func (mr *MetricRegistry) Materialize(conn *sql.Conn) error {
-	// TODO: Make this return a list of "materialization errors"
-	// if something fails
+func (mr *MetricRegistry) register() {
+	mr.registry = prometheus.NewRegistry()
+	if err := mr.registry.Register(mr); err != nil {
+		log.Error().Err(err).Msg("could not register metric registry")
+	}
+}
+
+// Gatherer returns the prometheus registry holding only this registry's metrics.
+func (mr *MetricRegistry) Gatherer() prometheus.Gatherer {
+	return mr.registry
+}
+
+// OpenMetricsFamilies returns how the info and stateset metrics of the registry, which are
+// gathered as gauges, are exposed to OpenMetrics scrapers.
+func (mr *MetricRegistry) OpenMetricsFamilies() openmetrics.Families {
+	families := openmetrics.Families{}
+	for name, info := range mr.Info {
+		families[name] = openmetrics.Family{Name: info.FamilyName(), Type: openmetrics.InfoType}
+	}
+	for name := range mr.StateSet {
+		families[name] = openmetrics.Family{Name: name, Type: openmetrics.StateSetType}
+	}
+	return families
+}
+
+// Instrument exports self-metrics about every metric in the registry to t.
+func (mr *MetricRegistry) Instrument(t *telemetry.Telemetry) {
+	mr.telemetry = t
+	for _, m := range mr.metrics() {
+		definition := m.MetricDefinition()
+		t.TrackMetric(definition.Metadata.PackageName, definition.FullName())
+	}
+}
+
+// Materialize runs every metric in the registry and replaces the registry snapshot with the results.
+// Every metric is attempted; the returned error joins a *MaterializationError for each failure.
+// Queries are interrupted when ctx is done or the metric's timeout elapses.
+func (mr *MetricRegistry) Materialize(ctx context.Context, conn *sql.Conn) error {
+	metrics := mr.metrics()
+	var materializationErrors []*MaterializationError
+	var errs []error
+	for _, materializable := range metrics {
+		definition := materializable.MetricDefinition()
+		start := time.Now()
+		err := materialize(ctx, conn, materializable)
+		mr.telemetry.MetricQueried(
+			definition.Metadata.PackageName, definition.FullName(), time.Since(start), materializable.RowsReturned(),
+		)
+		mr.telemetry.MetricMaterialized(definition.Metadata.PackageName, definition.FullName(), time.Now(), err)
+		if err != nil {
+			materializationError := &MaterializationError{
+				Package: definition.Metadata.PackageName,
+				Metric:  definition.FullName(),
+				Err:     err,
+			}
+			log.Error().Err(err).Str("package", materializationError.Package).
+				Str("metric", materializationError.Metric).Msg("could not materialize metric")
+			materializationErrors = append(materializationErrors, materializationError)
+			errs = append(errs, materializationError)
+		}
+	}
+	mr.snapshot.Store(&Snapshot{
+		Metrics:        collect(metrics),
+		Errors:         materializationErrors,
+		MaterializedAt: time.Now(),
+	})
+	return errors.Join(errs...)
+}
+
+// Snapshot returns the metrics captured by the most recent materialization, or nil if
+// the registry has not been materialized yet.
+func (mr *MetricRegistry) Snapshot() *Snapshot {
+	return mr.snapshot.Load()
+}
+
+// Describe implements prometheus.Collector by describing every metric in the registry.
+func (mr *MetricRegistry) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range mr.metrics() {
+		m.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector by serving the latest snapshot.
+func (mr *MetricRegistry) Collect(ch chan<- prometheus.Metric) {
+	snapshot := mr.Snapshot()
+	if snapshot == nil {
+		return
+	}
+	for _, m := range snapshot.Metrics {
+		ch <- m
+	}
+}
+
+// materialize runs a single metric, interrupting its query once the metric's timeout elapses.
+func materialize(ctx context.Context, conn *sql.Conn, m metric.Materializeable) error {
+	timeout := m.MetricDefinition().Timeout.Duration()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	err := m.Materialize(ctx, conn)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("query timed out after %s: %w", timeout, err)
+	}
+	return err
+}
+
+func (mr *MetricRegistry) metrics() []metric.Materializeable {
 	var m []metric.Materializeable
 	for _, metric := range mr.Gauge {
-		m = append(m, &metric)
+		m = append(m, metric)
 	}
 	for _, metric := range mr.Histogram {
-		m = append(m, &metric)
+		m = append(m, metric)
 	}
 	for _, metric := range mr.Summary {
-		m = append(m, &metric)
+		m = append(m, metric)
 	}
 	for _, metric := range mr.Counter {
-		m = append(m, &metric)
+		m = append(m, metric)
 	}
-	for _, materializable := range m {
-		err := materializable.Materialize(conn)
-		if err != nil {
-			log.Error().Err(err).Msg("could not materialize metric")
+	for _, metric := range mr.Info {
+		m = append(m, metric)
+	}
+	for _, metric := range mr.StateSet {
+		m = append(m, metric)
+	}
+	return m
+}
+
+func collect(metrics []metric.Materializeable) []prometheus.Metric {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		for _, m := range metrics {
+			m.Collect(ch)
 		}
+		close(ch)
+	}()
+	var collected []prometheus.Metric
+	for m := range ch {
+		collected = append(collected, m)
 	}
-	return nil
+	return collected
 }
@@ -2,13 +2,23 @@
 package registry_test
 
 import (
+	"errors"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jakthom/hercules/pkg/db"
 	"github.com/jakthom/hercules/pkg/metric"
 	registry "github.com/jakthom/hercules/pkg/metricRegistry"
+	"github.com/jakthom/hercules/pkg/openmetrics"
+	"github.com/jakthom/hercules/pkg/telemetry"
 	"github.com/jakthom/hercules/pkg/testutil"
+	herculestypes "github.com/jakthom/hercules/pkg/types"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewMetricRegistry(t *testing.T) {
@@ -41,7 +51,7 @@ func TestNewMetricRegistry(t *testing.T) {
 				Name:       "test_summary",
 				Help:       "Test summary metric",
 				SQL:        "SELECT 1",
-				Objectives: []float64{0.5, 0.9, 0.99},
+				Objectives: metric.Objectives{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
 				Metadata: metric.Metadata{
 					PackageName: "test",
 					Prefix:      "prefix_",
@@ -82,6 +92,25 @@ func TestNewMetricRegistry(t *testing.T) {
 	assert.True(t, exists, "Histogram metric should be stored with its full name")
 }
 
+func TestNewMetricRegistry_SkipsDisabled(t *testing.T) {
+	disabled := false
+	definitions := metric.Definitions{
+		Gauge: []*metric.Definition{
+			{Name: "enabled_gauge", SQL: "SELECT 1", Metadata: metric.Metadata{PackageName: "test"}},
+			{Name: "disabled_gauge", SQL: "SELECT 1", Enabled: &disabled, Metadata: metric.Metadata{PackageName: "test"}},
+		},
+		Counter: []*metric.Definition{
+			{Name: "disabled_counter", SQL: "SELECT 1", Enabled: &disabled, Metadata: metric.Metadata{PackageName: "test"}},
+		},
+	}
+
+	reg := registry.NewMetricRegistry(definitions)
+
+	assert.Len(t, reg.Gauge, 1)
+	assert.Contains(t, reg.Gauge, "test_enabled_gauge")
+	assert.Empty(t, reg.Counter)
+}
+
 func TestMetricRegistry_Materialize(t *testing.T) {
 	// Create test metric definitions
 	definitions := metric.Definitions{
@@ -119,9 +148,211 @@ func TestMetricRegistry_Materialize(t *testing.T) {
 		WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(2))
 
 	// Materialize metrics
-	err := reg.Materialize(conn)
+	err := reg.Materialize(t.Context(), conn)
 
 	// Verify
 	assert.NoError(t, err)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
+
+func TestMetricRegistry_Snapshot(t *testing.T) {
+	definitions := metric.Definitions{
+		Gauge: []*metric.Definition{
+			{
+				Name: "snapshot_gauge",
+				Help: "Test gauge metric",
+				SQL:  "SELECT 1 AS value",
+				Metadata: metric.Metadata{
+					PackageName: "test",
+				},
+			},
+		},
+	}
+	reg := registry.NewMetricRegistry(definitions)
+
+	// Nothing is served until the registry has been materialized
+	assert.Nil(t, reg.Snapshot())
+	gatherer := prometheus.NewRegistry()
+	gatherer.MustRegister(reg)
+	families, err := gatherer.Gather()
+	require.NoError(t, err)
+	assert.Empty(t, families)
+
+	conn, mock, _ := testutil.GetMockedConnection()
+	mock.ExpectQuery("SELECT 1 AS value").
+		WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(7))
+	require.NoError(t, reg.Materialize(t.Context(), conn))
+
+	snapshot := reg.Snapshot()
+	require.NotNil(t, snapshot)
+	assert.Len(t, snapshot.Metrics, 1)
+	assert.False(t, snapshot.MaterializedAt.IsZero())
+
+	// Gathering serves the snapshot without running any further queries
+	families, err = gatherer.Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 1)
+	assert.Equal(t, "test_snapshot_gauge", families[0].GetName())
+	assert.InDelta(t, 7, families[0].GetMetric()[0].GetGauge().GetValue(), 0)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMetricRegistry_GathererIsIsolated(t *testing.T) {
+	newRegistry := func(pkg string) *registry.MetricRegistry {
+		return registry.NewMetricRegistry(metric.Definitions{
+			Gauge: []*metric.Definition{
+				{
+					Name:     "isolated_gauge",
+					Help:     "Test gauge metric",
+					SQL:      db.SQL("SELECT '" + pkg + "' AS value"),
+					Metadata: metric.Metadata{PackageName: pkg},
+				},
+			},
+		})
+	}
+	first := newRegistry("first")
+	second := newRegistry("second")
+
+	conn, mock, _ := testutil.GetMockedConnection()
+	mock.ExpectQuery("SELECT 'first' AS value").
+		WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(1))
+	mock.ExpectQuery("SELECT 'second' AS value").
+		WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(2))
+	require.NoError(t, first.Materialize(t.Context(), conn))
+	require.NoError(t, second.Materialize(t.Context(), conn))
+
+	// Each registry only gathers its own package's metrics
+	families, err := first.Gatherer().Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 1)
+	assert.Equal(t, "first_isolated_gauge", families[0].GetName())
+
+	// Registries can be merged to serve every package at once
+	families, err = prometheus.Gatherers{first.Gatherer(), second.Gatherer()}.Gather()
+	require.NoError(t, err)
+	assert.Len(t, families, 2)
+}
+
+func TestMetricRegistry_MaterializeReportsErrors(t *testing.T) {
+	definitions := metric.Definitions{
+		Gauge: []*metric.Definition{
+			{
+				Name:     "broken_gauge",
+				Help:     "Test gauge metric",
+				SQL:      "SELECT broken",
+				Metadata: metric.Metadata{PackageName: "test"},
+			},
+		},
+		Counter: []*metric.Definition{
+			{
+				Name:     "working_counter",
+				Help:     "Test counter metric",
+				SQL:      "SELECT 1 AS value",
+				Metadata: metric.Metadata{PackageName: "test"},
+			},
+		},
+	}
+	reg := registry.NewMetricRegistry(definitions)
+	tel := telemetry.New()
+	reg.Instrument(tel)
+
+	conn, mock, _ := testutil.GetMockedConnection()
+	mock.MatchExpectationsInOrder(false)
+	mock.ExpectQuery("SELECT broken").WillReturnError(errors.New("syntax error"))
+	mock.ExpectQuery("SELECT 1 AS value").
+		WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(1))
+
+	// Every metric is attempted and each failure is reported
+	err := reg.Materialize(t.Context(), conn)
+	require.Error(t, err)
+	var materializationErr *registry.MaterializationError
+	require.ErrorAs(t, err, &materializationErr)
+	assert.Equal(t, "test_broken_gauge", materializationErr.Metric)
+	assert.Equal(t, "test", materializationErr.Package)
+
+	snapshot := reg.Snapshot()
+	require.Len(t, snapshot.Errors, 1)
+	assert.Len(t, snapshot.Metrics, 1)
+
+	expected := `
+# HELP hercules_metric_up Whether the last materialization of a metric definition succeeded (1) or failed (0).
+# TYPE hercules_metric_up gauge
+hercules_metric_up{metric="test_broken_gauge",package="test"} 0
+hercules_metric_up{metric="test_working_counter",package="test"} 1
+`
+	assert.NoError(t, promtestutil.CollectAndCompare(tel, strings.NewReader(expected), "hercules_metric_up"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMetricRegistry_MaterializeTimesOut(t *testing.T) {
+	definitions := metric.Definitions{
+		Gauge: []*metric.Definition{
+			{
+				Name:     "slow_gauge",
+				Help:     "Test gauge metric",
+				SQL:      "SELECT slow",
+				Timeout:  herculestypes.Duration(10 * time.Millisecond),
+				Metadata: metric.Metadata{PackageName: "test"},
+			},
+		},
+	}
+	reg := registry.NewMetricRegistry(definitions)
+
+	conn, mock, _ := testutil.GetMockedConnection()
+	mock.ExpectQuery("SELECT slow").
+		WillDelayFor(time.Second).
+		WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(1))
+
+	err := reg.Materialize(t.Context(), conn)
+	var materializationErr *registry.MaterializationError
+	require.ErrorAs(t, err, &materializationErr)
+	assert.Equal(t, "test_slow_gauge", materializationErr.Metric)
+	assert.ErrorContains(t, err, "query timed out after 10ms")
+}
+
+func TestMetricRegistry_OpenMetricsFamilies(t *testing.T) {
+	definitions := metric.Definitions{
+		Gauge: []*metric.Definition{
+			{Name: "gauge", SQL: "SELECT 1", Metadata: metric.Metadata{PackageName: "test"}},
+		},
+		Info: []*metric.Definition{
+			{Name: "warehouse", SQL: "SELECT 1", Metadata: metric.Metadata{PackageName: "test"}},
+		},
+		StateSet: []*metric.Definition{
+			{Name: "status", SQL: "SELECT 1", StateColumn: "state", Metadata: metric.Metadata{PackageName: "test"}},
+		},
+	}
+
+	reg := registry.NewMetricRegistry(definitions)
+
+	assert.Contains(t, reg.Info, "test_warehouse_info")
+	assert.Contains(t, reg.StateSet, "test_status")
+	assert.Equal(t, openmetrics.Families{
+		"test_warehouse_info": {Name: "test_warehouse", Type: openmetrics.InfoType},
+		"test_status":         {Name: "test_status", Type: openmetrics.StateSetType},
+	}, reg.OpenMetricsFamilies())
+}
+
+func TestMetricRegistry_Reload(t *testing.T) {
+	gauge := func(name string, sql db.SQL) *metric.Definition {
+		return &metric.Definition{Name: name, Help: "Test gauge metric", SQL: sql, Metadata: metric.Metadata{PackageName: "test"}}
+	}
+	tel := telemetry.New()
+	reg := registry.NewMetricRegistry(metric.Definitions{
+		Gauge: []*metric.Definition{gauge("unchanged", "SELECT 1"), gauge("changed", "SELECT 2"), gauge("removed", "SELECT 3")},
+	})
+	reg.Instrument(tel)
+
+	reloaded := reg.Reload(metric.Definitions{
+		Gauge: []*metric.Definition{gauge("unchanged", "SELECT 1"), gauge("changed", "SELECT 20"), gauge("added", "SELECT 4")},
+	})
+
+	assert.Same(t, reg.Gauge["test_unchanged"], reloaded.Gauge["test_unchanged"])
+	assert.NotSame(t, reg.Gauge["test_changed"], reloaded.Gauge["test_changed"])
+	assert.Equal(t, db.SQL("SELECT 20"), reloaded.Gauge["test_changed"].Definition.SQL)
+	assert.Contains(t, reloaded.Gauge, "test_added")
+	assert.NotContains(t, reloaded.Gauge, "test_removed")
+	assert.NotSame(t, reg.Gatherer(), reloaded.Gatherer())
+	// Only the removed metric stops being tracked
+	assert.Equal(t, 2, promtestutil.CollectAndCount(tel, "hercules_metric_up"))
+}
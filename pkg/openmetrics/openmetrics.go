@@ -0,0 +1,184 @@
+// Package openmetrics exposes metric types that the Prometheus client library cannot encode to
+// scrapers negotiating OpenMetrics.
+package openmetrics
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/rs/zerolog/log"
+)
+
+// OpenMetrics types without a Prometheus client library equivalent.
+const (
+	InfoType     = "info"
+	StateSetType = "stateset"
+)
+
+// Family is the OpenMetrics name and type of a metric family gathered as a gauge.
+type Family struct {
+	Name string
+	Type string
+}
+
+// Families maps the names metric families are gathered under to how they are exposed to
+// OpenMetrics scrapers.
+type Families map[string]Family
+
+// Merge adds every family of families.
+func (f Families) Merge(families Families) {
+	for name, family := range families {
+		f[name] = family
+	}
+}
+
+// Handler serves the metrics of gatherer to scrapers negotiating OpenMetrics, exposing families
+// with their OpenMetrics name and type. Every other request is served by next.
+func Handler(next http.Handler, gatherer prometheus.Gatherer, families Families) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		format := expfmt.NegotiateIncludingOpenMetrics(r.Header)
+		if len(families) == 0 || format.FormatType() != expfmt.TypeOpenMetrics {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Like the Prometheus handler, serve whatever could be gathered so a single broken metric
+		// does not fail the entire scrape
+		gathered, err := gatherer.Gather()
+		if err != nil {
+			log.Error().Err(err).Msg("error gathering metrics")
+			if len(gathered) == 0 {
+				http.Error(w, "could not gather metrics: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", string(format))
+		var body io.Writer = w
+		if acceptsGzip(r.Header.Values("Accept-Encoding")) {
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			defer gz.Close()
+			body = gz
+		}
+		if err := families.encode(body, gathered); err != nil {
+			log.Error().Err(err).Msg("could not write openmetrics exposition")
+		}
+	})
+}
+
+// acceptsGzip reports whether the Accept-Encoding header values accept gzip, either by name or
+// through the * wildcard, with a nonzero quality. gzip takes precedence over the wildcard.
+func acceptsGzip(values []string) bool {
+	gzipQuality, wildcardQuality := -1.0, -1.0
+	for _, value := range values {
+		for _, coding := range strings.Split(value, ",") {
+			name, params, _ := strings.Cut(coding, ";")
+			quality := 1.0
+			for _, param := range strings.Split(params, ";") {
+				key, q, ok := strings.Cut(strings.TrimSpace(param), "=")
+				if !ok || !strings.EqualFold(strings.TrimSpace(key), "q") {
+					continue
+				}
+				parsed, err := strconv.ParseFloat(strings.TrimSpace(q), 64)
+				if err != nil {
+					parsed = 0
+				}
+				quality = parsed
+			}
+			switch name = strings.TrimSpace(name); {
+			case strings.EqualFold(name, "gzip"):
+				gzipQuality = quality
+			case name == "*":
+				wildcardQuality = quality
+			}
+		}
+	}
+	if gzipQuality >= 0 {
+		return gzipQuality > 0
+	}
+	return wildcardQuality > 0
+}
+
+// encode writes gathered to w in the OpenMetrics text format. Families in f are encoded with their
+// OpenMetrics name and type, and every other family as the Prometheus client library would.
+func (f Families) encode(w io.Writer, gathered []*dto.MetricFamily) error {
+	buf := bufio.NewWriter(w)
+	for _, mf := range gathered {
+		var err error
+		if family, ok := f[mf.GetName()]; ok {
+			err = family.encode(buf, mf)
+		} else {
+			_, err = expfmt.MetricFamilyToOpenMetrics(buf, mf)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := expfmt.FinalizeOpenMetrics(buf); err != nil {
+		return err
+	}
+	return buf.Flush()
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// encode writes the gauges of mf as a family of type f.Type named f.Name. Samples keep the name
+// they were gathered under, which for info metrics ends in _info.
+func (f Family) encode(w *bufio.Writer, mf *dto.MetricFamily) error {
+	if mf.GetType() != dto.MetricType_GAUGE {
+		return fmt.Errorf("%s family %s is gathered as a %s rather than a gauge", f.Type, mf.GetName(), mf.GetType())
+	}
+	if mf.Help != nil {
+		fmt.Fprintf(w, "# HELP %s %s\n", f.Name, escaper.Replace(mf.GetHelp()))
+	}
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.Name, f.Type)
+	for _, m := range mf.GetMetric() {
+		w.WriteString(mf.GetName())
+		if len(m.GetLabel()) > 0 {
+			w.WriteByte('{')
+			for i, label := range m.GetLabel() {
+				if i > 0 {
+					w.WriteByte(',')
+				}
+				fmt.Fprintf(w, `%s="%s"`, label.GetName(), escaper.Replace(label.GetValue()))
+			}
+			w.WriteByte('}')
+		}
+		w.WriteByte(' ')
+		w.WriteString(formatFloat(m.GetGauge().GetValue()))
+		if m.TimestampMs != nil {
+			w.WriteByte(' ')
+			w.WriteString(formatFloat(float64(m.GetTimestampMs()) / 1000))
+		}
+		if _, err := w.WriteString("\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatFloat formats f as OpenMetrics requires, always distinguishing floats from integers.
+func formatFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, "e.") {
+		s += ".0"
+	}
+	return s
+}
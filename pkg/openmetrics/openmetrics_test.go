@@ -0,0 +1,105 @@
+// Package openmetrics_test contains tests for the openmetrics package
+package openmetrics_test
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jakthom/hercules/pkg/openmetrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const openMetricsAccept = "application/openmetrics-text; version=1.0.0"
+
+func testHandler(t *testing.T) http.Handler {
+	t.Helper()
+	reg := prometheus.NewRegistry()
+	info := prometheus.NewGauge(prometheus.GaugeOpts{Name: "warehouse_info", Help: "Warehouse metadata", ConstLabels: prometheus.Labels{"size": "large"}})
+	info.Set(1)
+	state := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "status", Help: "Status\n# TYPE status gauge"}, []string{"status"})
+	state.WithLabelValues("ok").Set(1)
+	depth := prometheus.NewGauge(prometheus.GaugeOpts{Name: "queue_depth", Help: "Queue depth"})
+	depth.Set(3)
+	require.NoError(t, reg.Register(info))
+	require.NoError(t, reg.Register(state))
+	require.NoError(t, reg.Register(depth))
+
+	return openmetrics.Handler(promhttp.HandlerFor(reg, promhttp.HandlerOpts{EnableOpenMetrics: true}), reg, openmetrics.Families{
+		"warehouse_info": {Name: "warehouse", Type: openmetrics.InfoType},
+		"status":         {Name: "status", Type: openmetrics.StateSetType},
+	})
+}
+
+func scrape(t *testing.T, handler http.Handler, header http.Header) (*http.Response, string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header = header
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	res := rec.Result()
+	defer res.Body.Close()
+	body := res.Body
+	if res.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(body)
+		require.NoError(t, err)
+		body = gz
+	}
+	b, err := io.ReadAll(body)
+	require.NoError(t, err)
+	return res, string(b)
+}
+
+func TestHandler_EncodesOpenMetricsTypes(t *testing.T) {
+	res, body := scrape(t, testHandler(t), http.Header{"Accept": {openMetricsAccept}})
+
+	assert.Contains(t, res.Header.Get("Content-Type"), "application/openmetrics-text")
+	assert.Contains(t, body, "# HELP warehouse Warehouse metadata\n# TYPE warehouse info\n")
+	assert.Contains(t, body, `warehouse_info{size="large"} 1.0`)
+	assert.Contains(t, body, "# HELP status Status\\n# TYPE status gauge\n# TYPE status stateset\n")
+	assert.Contains(t, body, `status{status="ok"} 1.0`)
+	assert.Contains(t, body, "# TYPE queue_depth gauge\nqueue_depth 3.0\n")
+	assert.True(t, strings.HasSuffix(body, "# EOF\n"))
+}
+
+func TestHandler_CompressesExposition(t *testing.T) {
+	res, body := scrape(t, testHandler(t), http.Header{"Accept": {openMetricsAccept}, "Accept-Encoding": {"gzip"}})
+
+	assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+	assert.Contains(t, body, "# TYPE warehouse info\n")
+}
+
+func TestHandler_NegotiatesCompression(t *testing.T) {
+	for _, tt := range []struct {
+		acceptEncoding string
+		gzipped        bool
+	}{
+		{acceptEncoding: "gzip, deflate", gzipped: true},
+		{acceptEncoding: "deflate;q=1.0, GZIP;q=0.5", gzipped: true},
+		{acceptEncoding: "*", gzipped: true},
+		{acceptEncoding: "gzip;q=0", gzipped: false},
+		{acceptEncoding: "gzip; q=0.0, *;q=1", gzipped: false},
+		{acceptEncoding: "*;q=0", gzipped: false},
+		{acceptEncoding: "identity", gzipped: false},
+	} {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			res, body := scrape(t, testHandler(t), http.Header{"Accept": {openMetricsAccept}, "Accept-Encoding": {tt.acceptEncoding}})
+
+			assert.Equal(t, tt.gzipped, res.Header.Get("Content-Encoding") == "gzip")
+			assert.Contains(t, body, "# TYPE warehouse info\n")
+		})
+	}
+}
+
+func TestHandler_LeavesPrometheusTextUntouched(t *testing.T) {
+	_, body := scrape(t, testHandler(t), http.Header{})
+
+	assert.Contains(t, body, "# TYPE warehouse_info gauge\n")
+	assert.Contains(t, body, "# TYPE status gauge\n")
+}
@@ -0,0 +1,124 @@
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	statuspb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// maxErrorBodyBytes bounds how much of an error response is reported.
+const maxErrorBodyBytes = 512
+
+// client sends export requests to an endpoint over a single protocol.
+type client interface {
+	export(ctx context.Context, request *collectorpb.ExportMetricsServiceRequest) (*collectorpb.ExportMetricsServiceResponse, error)
+	close() error
+}
+
+// newClient returns a client for the protocol of config. Connections are established lazily.
+func newClient(config Config) (client, error) {
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if config.protocol() == HTTPProtobufProtocol {
+		if endpoint.Path == "" || endpoint.Path == "/" {
+			endpoint.Path = metricsPath
+		}
+		return &httpClient{url: endpoint.String(), headers: config.headers(), client: &http.Client{}}, nil
+	}
+
+	creds := credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	if endpoint.Scheme == "http" {
+		creds = insecure.NewCredentials()
+	}
+	conn, err := grpc.NewClient(endpoint.Host, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+	return &grpcClient{
+		conn:     conn,
+		service:  collectorpb.NewMetricsServiceClient(conn),
+		metadata: metadata.New(config.headers()),
+	}, nil
+}
+
+// grpcClient exports over gRPC.
+type grpcClient struct {
+	conn     *grpc.ClientConn
+	service  collectorpb.MetricsServiceClient
+	metadata metadata.MD
+}
+
+func (c *grpcClient) export(ctx context.Context, request *collectorpb.ExportMetricsServiceRequest) (*collectorpb.ExportMetricsServiceResponse, error) {
+	return c.service.Export(metadata.NewOutgoingContext(ctx, c.metadata), request)
+}
+
+func (c *grpcClient) close() error {
+	return c.conn.Close()
+}
+
+// httpClient exports binary protobuf over HTTP.
+type httpClient struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func (c *httpClient) export(ctx context.Context, request *collectorpb.ExportMetricsServiceRequest) (*collectorpb.ExportMetricsServiceResponse, error) {
+	body, err := proto.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range c.headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "hercules")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodyBytes))
+		// Endpoints describe errors as a protobuf status, unless a proxy in between failed
+		status := &statuspb.Status{}
+		if resp.Header.Get("Content-Type") == "application/x-protobuf" && proto.Unmarshal(message, status) == nil {
+			message = []byte(status.GetMessage())
+		}
+		return nil, fmt.Errorf("endpoint returned %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	body, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	response := &collectorpb.ExportMetricsServiceResponse{}
+	if err = proto.Unmarshal(body, response); err != nil {
+		return nil, fmt.Errorf("could not decode response: %w", err)
+	}
+	return response, nil
+}
+
+func (c *httpClient) close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,106 @@
+package otlp
+
+import (
+	"errors"
+	"net/url"
+	"time"
+
+	herculestypes "github.com/jakthom/hercules/pkg/types"
+)
+
+// Protocol is the transport metrics are exported over.
+type Protocol string
+
+const (
+	GRPCProtocol         Protocol = "grpc"
+	HTTPProtobufProtocol Protocol = "http/protobuf"
+)
+
+// Temporality is whether sums and histograms report totals since the series started or only what
+// changed since the previous export.
+type Temporality string
+
+const (
+	CumulativeTemporality Temporality = "cumulative"
+	DeltaTemporality      Temporality = "delta"
+)
+
+const (
+	// DefaultTimeout is how long a single export may take.
+	DefaultTimeout = 10 * time.Second
+	// metricsPath is where HTTP endpoints receive metrics unless the endpoint sets a path.
+	metricsPath = "/v1/metrics"
+)
+
+// Config configures exporting metrics to an OTLP endpoint, such as an OpenTelemetry collector.
+type Config struct {
+	// The URL of the endpoint, e.g. http://collector:4317 for gRPC or http://collector:4318 for
+	// HTTP. gRPC connections use TLS unless the scheme is http. HTTP endpoints default to the
+	// /v1/metrics path.
+	Endpoint string   `json:"endpoint"`
+	Protocol Protocol `json:"protocol,omitempty"` // Defaults to grpc.
+	// How often metrics are exported. Defaults to the instance-wide materialization interval.
+	IntervalSeconds int                    `json:"intervalSeconds,omitempty"`
+	Timeout         herculestypes.Duration `json:"timeout,omitempty"`
+	Temporality     Temporality            `json:"temporality,omitempty"` // Defaults to cumulative.
+	// Sent with every export, e.g. to authenticate.
+	Headers map[string]herculestypes.Secret `json:"headers,omitempty"`
+}
+
+// Validate checks the configuration for settings metrics cannot be exported with. Every problem
+// is reported as a *herculestypes.FieldError locating the field.
+func (c *Config) Validate() error {
+	var errs []error
+	if c.Endpoint == "" {
+		errs = append(errs, herculestypes.FieldErrorf("endpoint", "endpoint is required"))
+	} else if u, err := url.Parse(c.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, herculestypes.FieldErrorf("endpoint", "%q is not an http or https URL", c.Endpoint))
+	}
+	switch c.Protocol {
+	case "", GRPCProtocol, HTTPProtobufProtocol:
+	default:
+		errs = append(errs, herculestypes.FieldErrorf("protocol", "unknown protocol %q, expected grpc or http/protobuf", c.Protocol))
+	}
+	if c.IntervalSeconds < 0 {
+		errs = append(errs, herculestypes.FieldErrorf("intervalSeconds", "intervalSeconds must not be negative"))
+	}
+	if c.Timeout < 0 {
+		errs = append(errs, herculestypes.FieldErrorf("timeout", "timeout must not be negative"))
+	}
+	switch c.Temporality {
+	case "", CumulativeTemporality, DeltaTemporality:
+	default:
+		errs = append(errs, herculestypes.FieldErrorf("temporality", "unknown temporality %q, expected cumulative or delta", c.Temporality))
+	}
+	return errors.Join(errs...)
+}
+
+func (c *Config) protocol() Protocol {
+	if c.Protocol != "" {
+		return c.Protocol
+	}
+	return GRPCProtocol
+}
+
+func (c *Config) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return c.Timeout.Duration()
+	}
+	return DefaultTimeout
+}
+
+func (c *Config) temporality() Temporality {
+	if c.Temporality != "" {
+		return c.Temporality
+	}
+	return CumulativeTemporality
+}
+
+// headers returns the headers sent with every export, resolved from the environment.
+func (c *Config) headers() map[string]string {
+	headers := make(map[string]string, len(c.Headers))
+	for name, value := range c.Headers {
+		headers[name] = value.Value()
+	}
+	return headers
+}
@@ -0,0 +1,288 @@
+package otlp
+
+import (
+	"maps"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rs/zerolog/log"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+)
+
+// ScopeName is the instrumentation scope every metric is exported under.
+const ScopeName = "github.com/jakthom/hercules"
+
+// Resource is a set of metrics exported with the same resource attributes. Labels of the metrics
+// that repeat a resource attribute are only exported as the resource attribute.
+type Resource struct {
+	Attributes map[string]string
+	Gatherer   prometheus.Gatherer
+}
+
+// cumulative is the state of a sum or histogram series as of an export.
+type cumulative struct {
+	start   uint64 // When the series was first seen or last reset, in Unix nanoseconds.
+	time    uint64 // When the series was exported, in Unix nanoseconds.
+	value   float64
+	count   uint64
+	sum     float64
+	buckets []uint64 // Counts of each histogram bucket, not including lower buckets.
+}
+
+// resets reports whether the series restarted counting since previous. Histograms are recomputed
+// from the rows of every materialization, so any bucket or the sum going down counts as a restart
+// even when the count went up.
+func (c cumulative) resets(previous cumulative) bool {
+	if c.value < previous.value || c.count < previous.count || c.sum < previous.sum ||
+		len(c.buckets) != len(previous.buckets) {
+		return true
+	}
+	for i := range c.buckets {
+		if c.buckets[i] < previous.buckets[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// converter converts gathered families to OTLP metrics of a temporality. Sums and histograms are
+// reported against the state of their series as of the previous export, and the state as of the
+// current export is kept in next until the export succeeds.
+type converter struct {
+	temporality Temporality
+	started     uint64 // When exporting started, which series first seen are reported from.
+	now         uint64
+	previous    map[string]cumulative
+	next        map[string]cumulative
+}
+
+// resourceMetrics gathers and converts the metrics of resource, or returns nil if it has none.
+func (c *converter) resourceMetrics(resource Resource) *metricspb.ResourceMetrics {
+	families, err := resource.Gatherer.Gather()
+	if err != nil {
+		// Gatherers report failures alongside every family they could gather
+		log.Warn().Err(err).Msg("could not gather every metric")
+	}
+	var metrics []*metricspb.Metric
+	for _, family := range families {
+		if metric := c.metric(family, resource.Attributes); metric != nil {
+			metrics = append(metrics, metric)
+		}
+	}
+	if len(metrics) == 0 {
+		return nil
+	}
+	return &metricspb.ResourceMetrics{
+		Resource: &resourcepb.Resource{Attributes: attributes(resource.Attributes, nil)},
+		ScopeMetrics: []*metricspb.ScopeMetrics{{
+			Scope:   &commonpb.InstrumentationScope{Name: ScopeName},
+			Metrics: metrics,
+		}},
+	}
+}
+
+// metric converts a family, or returns nil if it has no data points to report. Gauges and
+// untyped metrics become gauges, counters monotonic sums, and histograms and summaries their
+// OTLP counterparts.
+func (c *converter) metric(family *dto.MetricFamily, resourceAttributes map[string]string) *metricspb.Metric {
+	metric := &metricspb.Metric{Name: family.GetName(), Description: family.GetHelp()}
+	points := 0
+	switch family.GetType() {
+	case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+		gauge := &metricspb.Gauge{}
+		for _, m := range family.GetMetric() {
+			value := m.GetGauge().GetValue()
+			if family.GetType() == dto.MetricType_UNTYPED {
+				value = m.GetUntyped().GetValue()
+			}
+			gauge.DataPoints = append(gauge.DataPoints, &metricspb.NumberDataPoint{
+				Attributes:   attributes(labels(m), resourceAttributes),
+				TimeUnixNano: c.timestamp(m),
+				Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: value},
+			})
+		}
+		points = len(gauge.DataPoints)
+		metric.Data = &metricspb.Metric_Gauge{Gauge: gauge}
+	case dto.MetricType_COUNTER:
+		sum := &metricspb.Sum{IsMonotonic: true, AggregationTemporality: c.aggregationTemporality()}
+		for _, m := range family.GetMetric() {
+			current := cumulative{value: m.GetCounter().GetValue()}
+			baseline, ok := c.baseline(key(family, m), current)
+			if !ok {
+				continue
+			}
+			sum.DataPoints = append(sum.DataPoints, &metricspb.NumberDataPoint{
+				Attributes:        attributes(labels(m), resourceAttributes),
+				StartTimeUnixNano: c.startTime(baseline, m),
+				TimeUnixNano:      c.timestamp(m),
+				Value:             &metricspb.NumberDataPoint_AsDouble{AsDouble: current.value - baseline.value},
+			})
+		}
+		points = len(sum.DataPoints)
+		metric.Data = &metricspb.Metric_Sum{Sum: sum}
+	case dto.MetricType_HISTOGRAM:
+		histogram := &metricspb.Histogram{AggregationTemporality: c.aggregationTemporality()}
+		for _, m := range family.GetMetric() {
+			bounds, current := histogramState(m.GetHistogram())
+			baseline, ok := c.baseline(key(family, m), current)
+			if !ok {
+				continue
+			}
+			counts := make([]uint64, len(current.buckets))
+			for i := range counts {
+				counts[i] = current.buckets[i]
+				if i < len(baseline.buckets) {
+					counts[i] -= baseline.buckets[i]
+				}
+			}
+			sum := current.sum - baseline.sum
+			histogram.DataPoints = append(histogram.DataPoints, &metricspb.HistogramDataPoint{
+				Attributes:        attributes(labels(m), resourceAttributes),
+				StartTimeUnixNano: c.startTime(baseline, m),
+				TimeUnixNano:      c.timestamp(m),
+				Count:             current.count - baseline.count,
+				Sum:               &sum,
+				BucketCounts:      counts,
+				ExplicitBounds:    bounds,
+			})
+		}
+		points = len(histogram.DataPoints)
+		metric.Data = &metricspb.Metric_Histogram{Histogram: histogram}
+	case dto.MetricType_SUMMARY:
+		// Summaries have no temporality, and are always reported since the series started
+		summary := &metricspb.Summary{}
+		for _, m := range family.GetMetric() {
+			point := &metricspb.SummaryDataPoint{
+				Attributes:        attributes(labels(m), resourceAttributes),
+				StartTimeUnixNano: min(c.started, c.timestamp(m)),
+				TimeUnixNano:      c.timestamp(m),
+				Count:             m.GetSummary().GetSampleCount(),
+				Sum:               m.GetSummary().GetSampleSum(),
+			}
+			for _, q := range m.GetSummary().GetQuantile() {
+				point.QuantileValues = append(point.QuantileValues, &metricspb.SummaryDataPoint_ValueAtQuantile{
+					Quantile: q.GetQuantile(),
+					Value:    q.GetValue(),
+				})
+			}
+			summary.DataPoints = append(summary.DataPoints, point)
+		}
+		points = len(summary.DataPoints)
+		metric.Data = &metricspb.Metric_Summary{Summary: summary}
+	default:
+		return nil
+	}
+	if points == 0 {
+		return nil
+	}
+	return metric
+}
+
+// baseline records the current state of a series and returns the state its data point is reported
+// against: nothing since the series started for cumulative temporality, and the state as of the
+// previous export for delta temporality. The time of the baseline is the start of the data point.
+// Series seen for the first time are not reported with delta temporality, as what changed before
+// is unknown.
+func (c *converter) baseline(key string, current cumulative) (cumulative, bool) {
+	previous, seen := c.previous[key]
+	reset := seen && current.resets(previous)
+	switch {
+	case !seen:
+		current.start = c.started
+	case reset:
+		current.start = previous.time
+	default:
+		current.start = previous.start
+	}
+	current.time = c.now
+	c.next[key] = current
+
+	switch {
+	case c.temporality == CumulativeTemporality:
+		return cumulative{time: current.start}, true
+	case !seen:
+		return cumulative{}, false
+	case reset:
+		return cumulative{time: previous.time}, true
+	default:
+		return previous, true
+	}
+}
+
+func (c *converter) aggregationTemporality() metricspb.AggregationTemporality {
+	if c.temporality == DeltaTemporality {
+		return metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+	}
+	return metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+}
+
+// timestamp returns the time of a sample, which is the export time unless the sample has its own.
+func (c *converter) timestamp(m *dto.Metric) uint64 {
+	if m.TimestampMs != nil {
+		return uint64(m.GetTimestampMs()) * 1e6
+	}
+	return c.now
+}
+
+// startTime returns the start of the data point reported against baseline, which never follows
+// the time of the sample.
+func (c *converter) startTime(baseline cumulative, m *dto.Metric) uint64 {
+	return min(baseline.time, c.timestamp(m))
+}
+
+// histogramState returns the explicit bounds of a histogram and its state, with the count of
+// every bucket, including the implicit +Inf bucket, no longer including lower buckets.
+func histogramState(h *dto.Histogram) ([]float64, cumulative) {
+	state := cumulative{count: h.GetSampleCount(), sum: h.GetSampleSum()}
+	var bounds []float64
+	var below uint64
+	for _, bucket := range h.GetBucket() {
+		if math.IsInf(bucket.GetUpperBound(), 1) {
+			continue
+		}
+		bounds = append(bounds, bucket.GetUpperBound())
+		state.buckets = append(state.buckets, bucket.GetCumulativeCount()-below)
+		below = bucket.GetCumulativeCount()
+	}
+	state.buckets = append(state.buckets, state.count-below)
+	return bounds, state
+}
+
+// key identifies the series of a metric across exports.
+func key(family *dto.MetricFamily, m *dto.Metric) string {
+	var b strings.Builder
+	b.WriteString(family.GetName())
+	for _, pair := range m.GetLabel() {
+		b.WriteString("\xff" + pair.GetName() + "=" + pair.GetValue())
+	}
+	return b.String()
+}
+
+func labels(m *dto.Metric) map[string]string {
+	labels := make(map[string]string, len(m.GetLabel()))
+	for _, pair := range m.GetLabel() {
+		labels[pair.GetName()] = pair.GetValue()
+	}
+	return labels
+}
+
+// attributes converts labels to attributes sorted by key, leaving out labels that repeat a
+// resource attribute.
+func attributes(labels map[string]string, resourceAttributes map[string]string) []*commonpb.KeyValue {
+	var kvs []*commonpb.KeyValue
+	for _, name := range slices.Sorted(maps.Keys(labels)) {
+		if value, ok := resourceAttributes[name]; ok && value == labels[name] {
+			continue
+		}
+		kvs = append(kvs, &commonpb.KeyValue{
+			Key:   name,
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: labels[name]}},
+		})
+	}
+	return kvs
+}
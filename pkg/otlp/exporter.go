@@ -0,0 +1,122 @@
+// Package otlp exports metrics to OpenTelemetry collectors and other OTLP endpoints.
+package otlp
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jakthom/hercules/pkg/telemetry"
+	"github.com/rs/zerolog/log"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+)
+
+// Exporter exports the metrics of a set of resources to an OTLP endpoint. A failed export is not
+// retried, but the next export covers what changed since the last successful one, so no delta is
+// lost while the endpoint is unavailable.
+type Exporter struct {
+	config    Config
+	resources func() []Resource // Called on every export, so resources may change between exports.
+	client    client
+	telemetry *telemetry.Telemetry
+	started   time.Time
+	mu        sync.Mutex            // Serializes exports, and guards series.
+	series    map[string]cumulative // As of the last successful export.
+	cancel    context.CancelFunc
+	done      chan struct{} // Closed once the background loop returns.
+}
+
+// New returns an exporter of the metrics of resources to the endpoint of config.
+func New(config Config, resources func() []Resource) (*Exporter, error) {
+	c, err := newClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("could not create %s client: %w", config.protocol(), err)
+	}
+	return &Exporter{
+		config:    config,
+		resources: resources,
+		client:    c,
+		started:   time.Now(),
+		series:    map[string]cumulative{},
+	}, nil
+}
+
+// Instrument records the outcome of every export.
+func (e *Exporter) Instrument(t *telemetry.Telemetry) {
+	e.telemetry = t
+}
+
+// Start exports once, and then on every interval in the background until Stop is called.
+func (e *Exporter) Start(interval time.Duration) {
+	var ctx context.Context
+	ctx, e.cancel = context.WithCancel(context.Background())
+	e.done = make(chan struct{})
+	go func() {
+		defer close(e.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := e.Export(ctx); err != nil && ctx.Err() == nil {
+				log.Error().Err(err).Msg("could not export metrics via OTLP")
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop halts exporting, interrupting an in-flight export, and closes the connection to the endpoint.
+func (e *Exporter) Stop() {
+	if e.cancel != nil {
+		e.cancel()
+		<-e.done
+		e.cancel = nil
+	}
+	if err := e.client.close(); err != nil {
+		log.Error().Err(err).Msg("could not close OTLP connection")
+	}
+}
+
+// Export gathers the metrics of every resource and exports them in a single request.
+func (e *Exporter) Export(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	c := &converter{
+		temporality: e.config.temporality(),
+		started:     uint64(e.started.UnixNano()),
+		now:         uint64(now.UnixNano()),
+		previous:    e.series,
+		next:        map[string]cumulative{},
+	}
+	request := &collectorpb.ExportMetricsServiceRequest{}
+	for _, resource := range e.resources() {
+		if resourceMetrics := c.resourceMetrics(resource); resourceMetrics != nil {
+			request.ResourceMetrics = append(request.ResourceMetrics, resourceMetrics)
+		}
+	}
+	if len(request.ResourceMetrics) == 0 {
+		e.series = c.next
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.config.timeout())
+	defer cancel()
+	response, err := e.client.export(ctx, request)
+	e.telemetry.OTLPExported(e.config.Endpoint, now, err)
+	if err != nil {
+		return fmt.Errorf("could not export to %s: %w", e.config.Endpoint, err)
+	}
+	e.series = c.next
+	if partial := response.GetPartialSuccess(); partial.GetRejectedDataPoints() > 0 || partial.GetErrorMessage() != "" {
+		log.Warn().Int64("rejected", partial.GetRejectedDataPoints()).Str("endpoint", e.config.Endpoint).
+			Msg("OTLP endpoint rejected data points: " + partial.GetErrorMessage())
+	}
+	log.Trace().Int("resources", len(request.ResourceMetrics)).Dur("duration", time.Since(now)).Msg("exported metrics via OTLP")
+	return nil
+}
@@ -0,0 +1,333 @@
+// Package otlp_test contains tests for the otlp package
+package otlp_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jakthom/hercules/pkg/otlp"
+	herculestypes "github.com/jakthom/hercules/pkg/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// receiver stands in for an OTLP endpoint over both HTTP and gRPC, recording every request it
+// accepts and failing while failing is set.
+type receiver struct {
+	collectorpb.UnimplementedMetricsServiceServer
+	t        *testing.T
+	mu       sync.Mutex
+	failing  bool
+	requests []*collectorpb.ExportMetricsServiceRequest
+	headers  []map[string]string
+}
+
+func (r *receiver) record(request *collectorpb.ExportMetricsServiceRequest, headers map[string]string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failing {
+		return false
+	}
+	r.requests = append(r.requests, request)
+	r.headers = append(r.headers, headers)
+	return true
+}
+
+func (r *receiver) setFailing(failing bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failing = failing
+}
+
+func (r *receiver) last() *collectorpb.ExportMetricsServiceRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	require.NotEmpty(r.t, r.requests)
+	return r.requests[len(r.requests)-1]
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	assert.Equal(r.t, "/v1/metrics", req.URL.Path)
+	assert.Equal(r.t, "application/x-protobuf", req.Header.Get("Content-Type"))
+	body, err := io.ReadAll(req.Body)
+	require.NoError(r.t, err)
+	request := &collectorpb.ExportMetricsServiceRequest{}
+	require.NoError(r.t, proto.Unmarshal(body, request))
+	if !r.record(request, map[string]string{"authorization": req.Header.Get("Authorization")}) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	response, err := proto.Marshal(&collectorpb.ExportMetricsServiceResponse{})
+	require.NoError(r.t, err)
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	_, _ = w.Write(response)
+}
+
+func (r *receiver) Export(ctx context.Context, request *collectorpb.ExportMetricsServiceRequest) (*collectorpb.ExportMetricsServiceResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	headers := map[string]string{}
+	if values := md.Get("authorization"); len(values) > 0 {
+		headers["authorization"] = values[0]
+	}
+	if !r.record(request, headers) {
+		return nil, context.DeadlineExceeded
+	}
+	return &collectorpb.ExportMetricsServiceResponse{}, nil
+}
+
+func newHTTPReceiver(t *testing.T) (*receiver, string) {
+	r := &receiver{t: t}
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return r, server.URL
+}
+
+func newGRPCReceiver(t *testing.T) (*receiver, string) {
+	r := &receiver{t: t}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer()
+	collectorpb.RegisterMetricsServiceServer(server, r)
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+	return r, "http://" + listener.Addr().String()
+}
+
+// newExporter returns an exporter of a single resource holding the metrics of reg.
+func newExporter(t *testing.T, config otlp.Config, reg prometheus.Gatherer) *otlp.Exporter {
+	resource := otlp.Resource{
+		Attributes: map[string]string{"hercules.package.name": "test", "hercules": "edge"},
+		Gatherer:   reg,
+	}
+	exporter, err := otlp.New(config, func() []otlp.Resource { return []otlp.Resource{resource} })
+	require.NoError(t, err)
+	t.Cleanup(exporter.Stop)
+	return exporter
+}
+
+func attributes(kvs []*commonpb.KeyValue) map[string]string {
+	attributes := map[string]string{}
+	for _, kv := range kvs {
+		attributes[kv.GetKey()] = kv.GetValue().GetStringValue()
+	}
+	return attributes
+}
+
+// metrics returns the metrics of the only resource of request by name.
+func metrics(t *testing.T, request *collectorpb.ExportMetricsServiceRequest) map[string]*metricspb.Metric {
+	require.Len(t, request.GetResourceMetrics(), 1)
+	scopeMetrics := request.GetResourceMetrics()[0].GetScopeMetrics()
+	require.Len(t, scopeMetrics, 1)
+	assert.Equal(t, otlp.ScopeName, scopeMetrics[0].GetScope().GetName())
+	byName := map[string]*metricspb.Metric{}
+	for _, metric := range scopeMetrics[0].GetMetrics() {
+		byName[metric.GetName()] = metric
+	}
+	return byName
+}
+
+func newRegistry(t *testing.T) *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	constLabels := prometheus.Labels{"hercules": "edge", "warehouse": "compute"}
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_gauge", Help: "Gauge help", ConstLabels: constLabels})
+	gauge.Set(7)
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_total", Help: "Counter help", ConstLabels: constLabels})
+	counter.Add(5)
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_seconds", Help: "Histogram help", Buckets: []float64{1, 5}})
+	for _, v := range []float64{0.5, 3, 10} {
+		histogram.Observe(v)
+	}
+	summary := prometheus.NewSummary(prometheus.SummaryOpts{Name: "test_summary", Help: "Summary help", Objectives: map[float64]float64{0.5: 0.05}})
+	summary.Observe(2)
+	reg.MustRegister(gauge, counter, histogram, summary)
+	return reg
+}
+
+func TestExporter_HTTP(t *testing.T) {
+	r, endpoint := newHTTPReceiver(t)
+	config := otlp.Config{
+		Endpoint: endpoint,
+		Protocol: otlp.HTTPProtobufProtocol,
+		Headers:  map[string]herculestypes.Secret{"Authorization": "Bearer token"},
+	}
+	exporter := newExporter(t, config, newRegistry(t))
+
+	require.NoError(t, exporter.Export(context.Background()))
+	request := r.last()
+	assert.Equal(t, "Bearer token", r.headers[0]["authorization"])
+	assert.Equal(t, map[string]string{"hercules.package.name": "test", "hercules": "edge"},
+		attributes(request.GetResourceMetrics()[0].GetResource().GetAttributes()))
+
+	byName := metrics(t, request)
+	require.Len(t, byName, 4)
+
+	gauge := byName["test_gauge"]
+	assert.Equal(t, "Gauge help", gauge.GetDescription())
+	require.Len(t, gauge.GetGauge().GetDataPoints(), 1)
+	gaugePoint := gauge.GetGauge().GetDataPoints()[0]
+	assert.InDelta(t, 7.0, gaugePoint.GetAsDouble(), 1e-12)
+	// Labels repeating a resource attribute are only exported on the resource
+	assert.Equal(t, map[string]string{"warehouse": "compute"}, attributes(gaugePoint.GetAttributes()))
+
+	sum := byName["test_total"].GetSum()
+	assert.True(t, sum.GetIsMonotonic())
+	assert.Equal(t, metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE, sum.GetAggregationTemporality())
+	require.Len(t, sum.GetDataPoints(), 1)
+	assert.InDelta(t, 5.0, sum.GetDataPoints()[0].GetAsDouble(), 1e-12)
+	assert.LessOrEqual(t, sum.GetDataPoints()[0].GetStartTimeUnixNano(), sum.GetDataPoints()[0].GetTimeUnixNano())
+
+	histogram := byName["test_seconds"].GetHistogram()
+	require.Len(t, histogram.GetDataPoints(), 1)
+	histogramPoint := histogram.GetDataPoints()[0]
+	assert.Equal(t, []float64{1, 5}, histogramPoint.GetExplicitBounds())
+	assert.Equal(t, []uint64{1, 1, 1}, histogramPoint.GetBucketCounts())
+	assert.Equal(t, uint64(3), histogramPoint.GetCount())
+	assert.InDelta(t, 13.5, histogramPoint.GetSum(), 1e-12)
+
+	summary := byName["test_summary"].GetSummary()
+	require.Len(t, summary.GetDataPoints(), 1)
+	summaryPoint := summary.GetDataPoints()[0]
+	assert.Equal(t, uint64(1), summaryPoint.GetCount())
+	require.Len(t, summaryPoint.GetQuantileValues(), 1)
+	assert.InDelta(t, 0.5, summaryPoint.GetQuantileValues()[0].GetQuantile(), 1e-12)
+	assert.InDelta(t, 2.0, summaryPoint.GetQuantileValues()[0].GetValue(), 1e-12)
+}
+
+func TestExporter_GRPC(t *testing.T) {
+	r, endpoint := newGRPCReceiver(t)
+	config := otlp.Config{
+		Endpoint: endpoint,
+		Headers:  map[string]herculestypes.Secret{"authorization": "$OTLP_TOKEN"},
+	}
+	t.Setenv("OTLP_TOKEN", "Bearer token")
+	exporter := newExporter(t, config, newRegistry(t))
+
+	require.NoError(t, exporter.Export(context.Background()))
+	assert.Equal(t, "Bearer token", r.headers[0]["authorization"])
+	assert.Len(t, metrics(t, r.last()), 4)
+}
+
+func TestExporter_DeltaTemporality(t *testing.T) {
+	r, endpoint := newGRPCReceiver(t)
+	var total float64
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{Name: "test_total", Help: "Counter help"},
+		func() float64 { return total }))
+	exporter := newExporter(t, otlp.Config{Endpoint: endpoint, Temporality: otlp.DeltaTemporality}, reg)
+	ctx := context.Background()
+
+	// The first export only records a baseline, leaving nothing to export
+	total = 5
+	require.NoError(t, exporter.Export(ctx))
+	assert.Empty(t, r.requests)
+
+	point := func() *metricspb.NumberDataPoint {
+		sum := metrics(t, r.last())["test_total"].GetSum()
+		assert.Equal(t, metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA, sum.GetAggregationTemporality())
+		require.Len(t, sum.GetDataPoints(), 1)
+		return sum.GetDataPoints()[0]
+	}
+	total = 8
+	require.NoError(t, exporter.Export(ctx))
+	assert.InDelta(t, 3.0, point().GetAsDouble(), 1e-12)
+	previous := point().GetTimeUnixNano()
+
+	// What changed while the endpoint was unavailable is reported by the next successful export
+	r.setFailing(true)
+	total = 10
+	require.Error(t, exporter.Export(ctx))
+	r.setFailing(false)
+	total = 12
+	require.NoError(t, exporter.Export(ctx))
+	assert.InDelta(t, 4.0, point().GetAsDouble(), 1e-12)
+	assert.Equal(t, previous, point().GetStartTimeUnixNano())
+
+	// A counter that restarted counting is reported from zero
+	total = 1
+	require.NoError(t, exporter.Export(ctx))
+	assert.InDelta(t, 1.0, point().GetAsDouble(), 1e-12)
+}
+
+// histogramCollector collects a const histogram of whatever buckets, count, and sum are set.
+type histogramCollector struct {
+	desc    *prometheus.Desc
+	buckets map[float64]uint64
+	count   uint64
+	sum     float64
+}
+
+func (c *histogramCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *histogramCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstHistogram(c.desc, c.count, c.sum, c.buckets)
+}
+
+func TestExporter_DeltaHistogramResets(t *testing.T) {
+	r, endpoint := newGRPCReceiver(t)
+	histogram := &histogramCollector{desc: prometheus.NewDesc("test_seconds", "Histogram help", nil, nil)}
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(histogram)
+	exporter := newExporter(t, otlp.Config{Endpoint: endpoint, Temporality: otlp.DeltaTemporality}, reg)
+	ctx := context.Background()
+
+	histogram.buckets, histogram.count, histogram.sum = map[float64]uint64{1: 2, 5: 2}, 3, 10
+	require.NoError(t, exporter.Export(ctx))
+
+	// Recomputed from the current rows, the lowest bucket shrank while the count grew
+	histogram.buckets, histogram.count, histogram.sum = map[float64]uint64{1: 1, 5: 3}, 4, 12
+	require.NoError(t, exporter.Export(ctx))
+	points := metrics(t, r.last())["test_seconds"].GetHistogram().GetDataPoints()
+	require.Len(t, points, 1)
+	assert.Equal(t, []uint64{1, 2, 1}, points[0].GetBucketCounts())
+	assert.Equal(t, uint64(4), points[0].GetCount())
+	assert.InDelta(t, 12.0, points[0].GetSum(), 1e-12)
+}
+
+func TestExporter_StartAndStop(t *testing.T) {
+	r, endpoint := newHTTPReceiver(t)
+	exporter := newExporter(t, otlp.Config{Endpoint: endpoint, Protocol: otlp.HTTPProtobufProtocol}, newRegistry(t))
+
+	exporter.Start(time.Hour)
+	assert.Eventually(t, func() bool {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return len(r.requests) == 1
+	}, time.Second, time.Millisecond)
+	exporter.Stop()
+}
+
+func TestConfig_Validate(t *testing.T) {
+	assert.NoError(t, (&otlp.Config{Endpoint: "https://collector:4317"}).Validate())
+
+	config := otlp.Config{
+		Endpoint:        "collector:4317",
+		Protocol:        "http/json",
+		IntervalSeconds: -1,
+		Temporality:     "cumulativ",
+	}
+	var messages []string
+	for _, fieldError := range herculestypes.FieldErrors(config.Validate()) {
+		messages = append(messages, fieldError.Error())
+	}
+	assert.Equal(t, []string{
+		`endpoint: "collector:4317" is not an http or https URL`,
+		`protocol: unknown protocol "http/json", expected grpc or http/protobuf`,
+		`intervalSeconds: intervalSeconds must not be negative`,
+		`temporality: unknown temporality "cumulativ", expected cumulative or delta`,
+	}, messages)
+}
@@ -0,0 +1,225 @@
+// Package packagetest runs the tests declared by packages against their fixtures.
+package packagetest
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	herculespackage "github.com/jakthom/hercules/pkg/herculesPackage"
+	"github.com/jakthom/hercules/pkg/metric"
+	registry "github.com/jakthom/hercules/pkg/metricRegistry"
+	"github.com/jakthom/hercules/pkg/source"
+	"github.com/marcboeker/go-duckdb/v2"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+)
+
+// Result is the outcome of a single package test.
+type Result struct {
+	Package  string
+	Test     string
+	Failures []string // Why the test failed, empty if it passed.
+}
+
+// Passed reports whether every expectation of the test was met.
+func (r Result) Passed() bool {
+	return len(r.Failures) == 0
+}
+
+// Options configure how tests are run.
+type Options struct {
+	// Update writes the exposition of every metric to its golden file instead of comparing them.
+	Update bool
+}
+
+// Run runs every test of pkg, each in its own in-memory database.
+func Run(ctx context.Context, pkg herculespackage.Package, opts Options) []Result {
+	results := make([]Result, 0, len(pkg.Tests))
+	for _, test := range pkg.Tests {
+		result := Result{Package: string(pkg.Name), Test: test.Name}
+		if err := runTest(ctx, pkg, test, opts, &result); err != nil {
+			result.Failures = append(result.Failures, err.Error())
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// dir returns the directory fixture and golden files of pkg are relative to.
+func dir(pkg herculespackage.Package) string {
+	if strings.HasPrefix(pkg.Location, "http") {
+		return ""
+	}
+	return filepath.Dir(pkg.Location)
+}
+
+// runTest initializes pkg with the fixtures of test and checks every metric expectation of the
+// test, recording unmet expectations in result. Errors initializing the package are returned.
+func runTest(ctx context.Context, pkg herculespackage.Package, test herculespackage.Test, opts Options, result *Result) error {
+	connector, err := duckdb.NewConnector("", nil)
+	if err != nil {
+		return err
+	}
+	// Closing the pool closes the connector, discarding the database
+	pool := sql.OpenDB(connector)
+	defer pool.Close()
+
+	// Sources are replaced by their fixtures and refreshed only once
+	pkg.Sources = slices.Clone(pkg.Sources)
+	for i := range pkg.Sources {
+		s := &pkg.Sources[i]
+		s.RefreshIntervalSeconds = 0
+		if fixture, ok := test.Fixtures[s.Name]; ok {
+			s.Type, _ = source.FileType(fixture)
+			s.Source = filepath.Join(dir(pkg), fixture)
+		}
+	}
+	// Metrics are tested without a metric prefix or global labels, so expectations hold anywhere
+	pkg.Metadata = metric.Metadata{PackageName: string(pkg.Name)}
+	_, err = pkg.Reload(&herculespackage.Package{}, pool)
+	defer pkg.Cleanup()
+	if err != nil {
+		return fmt.Errorf("could not initialize package: %w", err)
+	}
+
+	conn, err := pool.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	for _, expectation := range test.Metrics {
+		if err = checkMetric(ctx, conn, pkg, expectation, opts); err != nil {
+			result.Failures = append(result.Failures, fmt.Sprintf("metric %s: %s", expectation.Name, err))
+		}
+	}
+	return nil
+}
+
+// checkMetric materializes the metric of an expectation and compares what it produced to what
+// the expectation states.
+func checkMetric(ctx context.Context, conn *sql.Conn, pkg herculespackage.Package, expectation herculespackage.MetricExpectation, opts Options) error {
+	definitions := pkg.Metrics.Only(expectation.Name)
+	typed := definitions.Typed()
+	switch {
+	case len(typed) == 0:
+		return fmt.Errorf("package %s has no metric %s", pkg.Name, expectation.Name)
+	case !typed[0].Definition.IsEnabled():
+		return errors.New("metric is disabled")
+	}
+	r := registry.NewMetricRegistry(definitions)
+	if err := r.Materialize(ctx, conn); err != nil {
+		return err
+	}
+	families, err := r.Gatherer().Gather()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	if len(expectation.Samples) > 0 {
+		errs = append(errs, compareSamples(typed[0].Definition.FullName(), expectation.Samples, families))
+	}
+	if expectation.Golden != "" {
+		errs = append(errs, compareGolden(filepath.Join(dir(pkg), expectation.Golden), families, opts))
+	}
+	return errors.Join(errs...)
+}
+
+// compareSamples compares the expected samples to the samples of families, regardless of order.
+// Expected samples without a name are named name.
+func compareSamples(name string, expected []herculespackage.ExpectedSample, families []*dto.MetricFamily) error {
+	want := make([]string, 0, len(expected))
+	for _, sample := range expected {
+		sampleName := sample.Name
+		if sampleName == "" {
+			sampleName = name
+		}
+		want = append(want, formatSample(sampleName, sample.Labels, sample.Value))
+	}
+	samples, err := expfmt.ExtractSamples(&expfmt.DecodeOptions{Timestamp: model.Now()}, families...)
+	if err != nil {
+		return err
+	}
+	got := make([]string, 0, len(samples))
+	for _, sample := range samples {
+		sampleLabels := make(map[string]string, len(sample.Metric))
+		for labelName, value := range sample.Metric {
+			if labelName != model.MetricNameLabel {
+				sampleLabels[string(labelName)] = string(value)
+			}
+		}
+		got = append(got, formatSample(string(sample.Metric[model.MetricNameLabel]), sampleLabels, float64(sample.Value)))
+	}
+	slices.Sort(want)
+	slices.Sort(got)
+	return diff("samples differ", want, got)
+}
+
+// compareGolden compares the text exposition of families to the golden file at path, or writes
+// the exposition to the file if opts.Update is set.
+func compareGolden(path string, families []*dto.MetricFamily, opts Options) error {
+	var exposition bytes.Buffer
+	encoder := expfmt.NewEncoder(&exposition, expfmt.NewFormat(expfmt.TypeTextPlain))
+	for _, family := range families {
+		if err := encoder.Encode(family); err != nil {
+			return err
+		}
+	}
+	if opts.Update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		return os.WriteFile(path, exposition.Bytes(), 0o600)
+	}
+	golden, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("golden file %s does not exist, run with -update to create it", path)
+	} else if err != nil {
+		return err
+	}
+	return diff("exposition differs from "+path, lines(string(golden)), lines(exposition.String()))
+}
+
+// formatSample formats a sample as a line of the text exposition format, without a timestamp.
+func formatSample(name string, sampleLabels map[string]string, value float64) string {
+	pairs := make([]string, 0, len(sampleLabels))
+	for _, labelName := range slices.Sorted(maps.Keys(sampleLabels)) {
+		pairs = append(pairs, labelName+"="+strconv.Quote(sampleLabels[labelName]))
+	}
+	return name + "{" + strings.Join(pairs, ",") + "} " + strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func lines(s string) []string {
+	return strings.Split(strings.TrimRight(s, "\n"), "\n")
+}
+
+// diff returns an error headed by header listing the lines only wanted, prefixed by -, and the
+// lines only got, prefixed by +, or nil if want and got hold the same lines.
+func diff(header string, want []string, got []string) error {
+	remaining := slices.Clone(got)
+	var b strings.Builder
+	for _, line := range want {
+		if i := slices.Index(remaining, line); i >= 0 {
+			remaining = slices.Delete(remaining, i, i+1)
+			continue
+		}
+		b.WriteString("\n  - " + line)
+	}
+	for _, line := range remaining {
+		b.WriteString("\n  + " + line)
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s (-expected +actual):%s", header, b.String())
+}
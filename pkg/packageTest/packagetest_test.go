@@ -0,0 +1,90 @@
+// Package packagetest_test contains tests for the packagetest package
+package packagetest_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	herculespackage "github.com/jakthom/hercules/pkg/herculesPackage"
+	packagetest "github.com/jakthom/hercules/pkg/packageTest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPackage = `
+name: test
+sources:
+  - name: queries
+    type: parquet
+    source: s3://bucket/queries.parquet
+    materialize: true
+    refreshIntervalSeconds: 5
+metrics:
+  gauge:
+    - name: query_count
+      sql: select user_name as user, count(*) as value from queries group by all;
+tests:
+  - name: counts queries by user
+    fixtures:
+      queries: fixtures/queries.csv
+    metrics:
+      - name: query_count
+        samples:
+          - labels: { user: alice }
+            value: 2
+          - labels: { user: bob }
+            value: %s
+      - name: test_query_count
+        golden: golden/query_count.prom
+`
+
+// loadPackage writes a package expecting bob to have run bobQueries queries, along with its
+// fixture, and loads it.
+func loadPackage(t *testing.T, dir string, bobQueries string) herculespackage.Package {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "fixtures"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "fixtures", "queries.csv"), []byte("user_name\nalice\nalice\nbob\n"), 0o600))
+	path := filepath.Join(dir, "package.yml")
+	require.NoError(t, os.WriteFile(path, []byte(fmt.Sprintf(testPackage, bobQueries)), 0o600))
+
+	config := herculespackage.PackageConfig{Package: path}
+	pkg, err := config.GetPackage()
+	require.NoError(t, err)
+	return pkg
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	pkg := loadPackage(t, dir, "1")
+
+	// Golden files do not exist until they are updated
+	results := packagetest.Run(t.Context(), pkg, packagetest.Options{})
+	require.Len(t, results, 1)
+	require.Len(t, results[0].Failures, 1)
+	assert.Contains(t, results[0].Failures[0], "run with -update to create it")
+
+	results = packagetest.Run(t.Context(), pkg, packagetest.Options{Update: true})
+	require.True(t, results[0].Passed(), results[0].Failures)
+	golden, err := os.ReadFile(filepath.Join(dir, "golden", "query_count.prom"))
+	require.NoError(t, err)
+	assert.Contains(t, string(golden), `test_query_count{user="bob"} 1`)
+
+	results = packagetest.Run(t.Context(), pkg, packagetest.Options{})
+	assert.Equal(t, []packagetest.Result{{Package: "test", Test: "counts queries by user"}}, results)
+}
+
+func TestRunReportsDifferences(t *testing.T) {
+	dir := t.TempDir()
+	packagetest.Run(t.Context(), loadPackage(t, dir, "1"), packagetest.Options{Update: true})
+
+	results := packagetest.Run(t.Context(), loadPackage(t, dir, "3"), packagetest.Options{})
+	require.Len(t, results, 1)
+	assert.False(t, results[0].Passed())
+	assert.Equal(t, []string{
+		"metric query_count: samples differ (-expected +actual):\n" +
+			"  - test_query_count{user=\"bob\"} 3\n" +
+			"  + test_query_count{user=\"bob\"} 1",
+	}, results[0].Failures)
+}
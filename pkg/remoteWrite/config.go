@@ -0,0 +1,141 @@
+package remotewrite
+
+import (
+	"errors"
+	"net/url"
+	"time"
+
+	herculestypes "github.com/jakthom/hercules/pkg/types"
+)
+
+const (
+	// DefaultTimeout is how long a single request to the endpoint may take.
+	DefaultTimeout = 30 * time.Second
+	// DefaultBackoff is how long to wait before retrying a failed request the first time.
+	DefaultBackoff = 500 * time.Millisecond
+	// DefaultMaxRetries is how many times a failed request is retried before it is left queued.
+	DefaultMaxRetries = 3
+	// DefaultMaxSamplesPerRequest bounds how many samples are sent in a single request.
+	DefaultMaxSamplesPerRequest = 2000
+	// DefaultQueueDirectory is where requests that could not be sent are kept.
+	DefaultQueueDirectory = "remote-write-queue"
+	// DefaultQueueMaxRequests bounds how many requests are kept while the endpoint is unavailable.
+	DefaultQueueMaxRequests = 1000
+
+	// maxBackoff caps the wait between retries.
+	maxBackoff = 30 * time.Second
+)
+
+// Config configures pushing samples to a Prometheus remote write endpoint. Remote write is
+// disabled unless a URL is configured.
+type Config struct {
+	URL string `json:"url"`
+	// How often samples are pushed. Defaults to the instance-wide materialization interval.
+	IntervalSeconds int                    `json:"intervalSeconds,omitempty"`
+	Timeout         herculestypes.Duration `json:"timeout,omitempty"`
+	// How many times a failed request is retried, waiting backoff before the first retry and
+	// twice as long before every further one.
+	MaxRetries           *int                   `json:"maxRetries,omitempty"`
+	Backoff              herculestypes.Duration `json:"backoff,omitempty"`
+	MaxSamplesPerRequest int                    `json:"maxSamplesPerRequest,omitempty"`
+	BasicAuth            BasicAuth              `json:"basicAuth,omitzero"`
+	BearerToken          herculestypes.Secret   `json:"bearerToken,omitempty"`
+	Queue                QueueConfig            `json:"queue,omitzero"`
+}
+
+// BasicAuth authenticates requests with a username and password.
+type BasicAuth struct {
+	Username string               `json:"username"`
+	Password herculestypes.Secret `json:"password"`
+}
+
+// QueueConfig configures where requests are kept while the endpoint is unavailable. Once the
+// queue is full, the oldest requests are dropped.
+type QueueConfig struct {
+	Directory   string `json:"directory,omitempty"`
+	MaxRequests int    `json:"maxRequests,omitempty"`
+}
+
+// Enabled reports whether samples should be pushed.
+func (c *Config) Enabled() bool {
+	return c.URL != ""
+}
+
+// Validate checks the configuration for settings samples cannot be pushed with. Every problem is
+// reported as a *herculestypes.FieldError locating the field. A disabled configuration is valid.
+func (c *Config) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+	var errs []error
+	if u, err := url.Parse(c.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, herculestypes.FieldErrorf("url", "%q is not an http or https URL", c.URL))
+	}
+	if c.IntervalSeconds < 0 {
+		errs = append(errs, herculestypes.FieldErrorf("intervalSeconds", "intervalSeconds must not be negative"))
+	}
+	if c.Timeout < 0 {
+		errs = append(errs, herculestypes.FieldErrorf("timeout", "timeout must not be negative"))
+	}
+	if c.MaxRetries != nil && *c.MaxRetries < 0 {
+		errs = append(errs, herculestypes.FieldErrorf("maxRetries", "maxRetries must not be negative"))
+	}
+	if c.Backoff < 0 {
+		errs = append(errs, herculestypes.FieldErrorf("backoff", "backoff must not be negative"))
+	}
+	if c.MaxSamplesPerRequest < 0 {
+		errs = append(errs, herculestypes.FieldErrorf("maxSamplesPerRequest", "maxSamplesPerRequest must not be negative"))
+	}
+	if c.BasicAuth.Username == "" && c.BasicAuth.Password != "" {
+		errs = append(errs, herculestypes.FieldErrorf("basicAuth.username", "username is required"))
+	}
+	if c.BasicAuth.Username != "" && c.BearerToken != "" {
+		errs = append(errs, herculestypes.FieldErrorf("bearerToken", "basicAuth and bearerToken are mutually exclusive"))
+	}
+	if c.Queue.MaxRequests < 0 {
+		errs = append(errs, herculestypes.FieldErrorf("queue.maxRequests", "maxRequests must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+func (c *Config) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return c.Timeout.Duration()
+	}
+	return DefaultTimeout
+}
+
+func (c *Config) maxRetries() int {
+	if c.MaxRetries != nil {
+		return *c.MaxRetries
+	}
+	return DefaultMaxRetries
+}
+
+func (c *Config) backoff() time.Duration {
+	if c.Backoff > 0 {
+		return c.Backoff.Duration()
+	}
+	return DefaultBackoff
+}
+
+func (c *Config) maxSamplesPerRequest() int {
+	if c.MaxSamplesPerRequest > 0 {
+		return c.MaxSamplesPerRequest
+	}
+	return DefaultMaxSamplesPerRequest
+}
+
+func (c *Config) queueDirectory() string {
+	if c.Queue.Directory != "" {
+		return c.Queue.Directory
+	}
+	return DefaultQueueDirectory
+}
+
+func (c *Config) queueMaxRequests() int {
+	if c.Queue.MaxRequests > 0 {
+		return c.Queue.MaxRequests
+	}
+	return DefaultQueueMaxRequests
+}
@@ -0,0 +1,69 @@
+package remotewrite
+
+import (
+	"math"
+	"slices"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers of the remote write 1.0 messages, see
+// https://github.com/prometheus/prometheus/blob/main/prompb/remote.proto and types.proto.
+const (
+	writeRequestTimeseries protowire.Number = 1
+	timeSeriesLabels       protowire.Number = 1
+	timeSeriesSamples      protowire.Number = 2
+	labelName              protowire.Number = 1
+	labelValue             protowire.Number = 2
+	sampleValue            protowire.Number = 1
+	sampleTimestamp        protowire.Number = 2
+)
+
+// label is a label of a time series, including the metric name as __name__.
+type label struct {
+	Name  string
+	Value string
+}
+
+// timeSeries is a series identified by its labels, sorted by name, with a single sample.
+type timeSeries struct {
+	Labels      []label
+	Value       float64
+	TimestampMs int64
+}
+
+// sortLabels sorts the labels of the series by name, as receivers require.
+func (s *timeSeries) sortLabels() {
+	slices.SortFunc(s.Labels, func(a, b label) int { return strings.Compare(a.Name, b.Name) })
+}
+
+// marshalWriteRequest returns the protobuf encoding of a WriteRequest holding series.
+func marshalWriteRequest(series []timeSeries) []byte {
+	var b []byte
+	for i := range series {
+		b = protowire.AppendTag(b, writeRequestTimeseries, protowire.BytesType)
+		b = protowire.AppendBytes(b, series[i].marshal())
+	}
+	return b
+}
+
+func (s *timeSeries) marshal() []byte {
+	var b []byte
+	for _, l := range s.Labels {
+		var encoded []byte
+		encoded = protowire.AppendTag(encoded, labelName, protowire.BytesType)
+		encoded = protowire.AppendString(encoded, l.Name)
+		encoded = protowire.AppendTag(encoded, labelValue, protowire.BytesType)
+		encoded = protowire.AppendString(encoded, l.Value)
+		b = protowire.AppendTag(b, timeSeriesLabels, protowire.BytesType)
+		b = protowire.AppendBytes(b, encoded)
+	}
+	var sample []byte
+	sample = protowire.AppendTag(sample, sampleValue, protowire.Fixed64Type)
+	sample = protowire.AppendFixed64(sample, math.Float64bits(s.Value))
+	sample = protowire.AppendTag(sample, sampleTimestamp, protowire.VarintType)
+	sample = protowire.AppendVarint(sample, uint64(s.TimestampMs))
+	b = protowire.AppendTag(b, timeSeriesSamples, protowire.BytesType)
+	return protowire.AppendBytes(b, sample)
+}
@@ -0,0 +1,107 @@
+package remotewrite
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// requestExtension ends the name of every queued request file.
+const requestExtension = ".snappy"
+
+// queue keeps compressed requests in a directory, one file per request, so requests that could
+// not be sent survive restarts. Files are named by sequence number and sent oldest first. Once
+// the queue holds max requests, the oldest are dropped. A queue is not safe for concurrent use.
+type queue struct {
+	dir   string
+	max   int
+	files []string // Oldest first.
+	next  uint64
+}
+
+// openQueue opens the queue kept in dir, creating the directory if needed and picking up the
+// requests left by a previous run.
+func openQueue(dir string, maxRequests int) (*queue, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("could not create queue directory: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read queue directory: %w", err)
+	}
+	q := &queue{dir: dir, max: maxRequests}
+	var sequences []uint64
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), requestExtension)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		if sequence, parseErr := strconv.ParseUint(name, 10, 64); parseErr == nil {
+			sequences = append(sequences, sequence)
+		}
+	}
+	slices.Sort(sequences)
+	for _, sequence := range sequences {
+		q.files = append(q.files, q.file(sequence))
+		q.next = sequence + 1
+	}
+	return q, nil
+}
+
+func (q *queue) file(sequence uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", sequence, requestExtension))
+}
+
+// Len returns how many requests are queued.
+func (q *queue) Len() int {
+	return len(q.files)
+}
+
+// Push queues a request, dropping the oldest requests if the queue is full, and returns how many
+// were dropped.
+func (q *queue) Push(request []byte) (int, error) {
+	file := q.file(q.next)
+	// Requests are written to a temporary file first, so a crash never leaves one half-written
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, request, 0o600); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		_ = os.Remove(tmp)
+		return 0, err
+	}
+	q.next++
+	q.files = append(q.files, file)
+	dropped := 0
+	for len(q.files) > q.max {
+		if err := q.Pop(); err != nil {
+			return dropped, err
+		}
+		dropped++
+	}
+	return dropped, nil
+}
+
+// Peek returns the oldest queued request, or false if the queue is empty.
+func (q *queue) Peek() ([]byte, bool, error) {
+	if len(q.files) == 0 {
+		return nil, false, nil
+	}
+	request, err := os.ReadFile(q.files[0])
+	return request, true, err
+}
+
+// Pop removes the oldest queued request.
+func (q *queue) Pop() error {
+	if len(q.files) == 0 {
+		return nil
+	}
+	if err := os.Remove(q.files[0]); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	q.files = q.files[1:]
+	return nil
+}
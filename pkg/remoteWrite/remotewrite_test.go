@@ -0,0 +1,311 @@
+// Package remotewrite_test contains tests for the remotewrite package
+package remotewrite_test
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	remotewrite "github.com/jakthom/hercules/pkg/remoteWrite"
+	herculestypes "github.com/jakthom/hercules/pkg/types"
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// series is a time series decoded from a remote write request.
+type series struct {
+	Labels      map[string]string
+	Value       float64
+	TimestampMs int64
+}
+
+// receiver stands in for a remote write endpoint, recording every request it accepts and
+// responding with the queued statuses before accepting requests.
+type receiver struct {
+	t        *testing.T
+	mu       sync.Mutex
+	statuses []int
+	attempts int
+	requests [][]series
+	headers  []http.Header
+}
+
+func newReceiver(t *testing.T, statuses ...int) (*receiver, *httptest.Server) {
+	r := &receiver{t: t, statuses: statuses}
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return r, server
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.attempts++
+	if len(r.statuses) > 0 {
+		status := r.statuses[0]
+		r.statuses = r.statuses[1:]
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	body, err := io.ReadAll(req.Body)
+	require.NoError(r.t, err)
+	decoded, err := snappy.Decode(nil, body)
+	require.NoError(r.t, err)
+	r.requests = append(r.requests, decodeWriteRequest(r.t, decoded))
+	r.headers = append(r.headers, req.Header.Clone())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// fail makes the receiver respond with status to the next n requests.
+func (r *receiver) fail(status int, n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for range n {
+		r.statuses = append(r.statuses, status)
+	}
+}
+
+func decodeWriteRequest(t *testing.T, b []byte) []series {
+	var decoded []series
+	for _, timeSeries := range fields(t, b) {
+		s := series{Labels: map[string]string{}}
+		for _, field := range fields(t, timeSeries.bytes) {
+			switch field.number {
+			case 1:
+				l := fields(t, field.bytes)
+				s.Labels[string(l[0].bytes)] = string(l[1].bytes)
+			case 2:
+				sample := fields(t, field.bytes)
+				s.Value = math.Float64frombits(sample[0].number64)
+				s.TimestampMs = int64(sample[1].number64)
+			}
+		}
+		decoded = append(decoded, s)
+	}
+	return decoded
+}
+
+type field struct {
+	number   protowire.Number
+	bytes    []byte
+	number64 uint64
+}
+
+func fields(t *testing.T, b []byte) []field {
+	var decoded []field
+	for len(b) > 0 {
+		number, typ, n := protowire.ConsumeTag(b)
+		require.GreaterOrEqual(t, n, 0)
+		b = b[n:]
+		f := field{number: number}
+		switch typ {
+		case protowire.BytesType:
+			f.bytes, n = protowire.ConsumeBytes(b)
+		case protowire.Fixed64Type:
+			f.number64, n = protowire.ConsumeFixed64(b)
+		case protowire.VarintType:
+			f.number64, n = protowire.ConsumeVarint(b)
+		default:
+			t.Fatalf("unexpected wire type %d", typ)
+		}
+		require.GreaterOrEqual(t, n, 0)
+		b = b[n:]
+		decoded = append(decoded, f)
+	}
+	return decoded
+}
+
+func newGauge(t *testing.T, reg *prometheus.Registry, name string) prometheus.Gauge {
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: name, Help: "Test gauge", ConstLabels: prometheus.Labels{"package": "test"}})
+	require.NoError(t, reg.Register(gauge))
+	return gauge
+}
+
+// newRegistry returns a registry with a single gauge.
+func newRegistry(t *testing.T) *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	newGauge(t, reg, "test_gauge")
+	return reg
+}
+
+func newConfig(t *testing.T, url string) remotewrite.Config {
+	maxRetries := 0
+	return remotewrite.Config{
+		URL:        url,
+		MaxRetries: &maxRetries,
+		Backoff:    herculestypes.Duration(time.Millisecond),
+		Queue:      remotewrite.QueueConfig{Directory: t.TempDir()},
+	}
+}
+
+func TestWriter_Write(t *testing.T) {
+	r, server := newReceiver(t)
+	reg := prometheus.NewRegistry()
+	newGauge(t, reg, "test_gauge").Set(42)
+
+	conf := newConfig(t, server.URL)
+	conf.BasicAuth = remotewrite.BasicAuth{Username: "hercules", Password: "secret"}
+	w, err := remotewrite.New(conf, reg)
+	require.NoError(t, err)
+
+	before := time.Now().UnixMilli()
+	require.NoError(t, w.Write(context.Background()))
+
+	require.Len(t, r.requests, 1)
+	require.Len(t, r.requests[0], 1)
+	s := r.requests[0][0]
+	assert.Equal(t, map[string]string{"__name__": "test_gauge", "package": "test"}, s.Labels)
+	assert.InDelta(t, 42.0, s.Value, 1e-12)
+	assert.GreaterOrEqual(t, s.TimestampMs, before)
+
+	headers := r.headers[0]
+	assert.Equal(t, "snappy", headers.Get("Content-Encoding"))
+	assert.Equal(t, "application/x-protobuf", headers.Get("Content-Type"))
+	assert.Equal(t, "0.1.0", headers.Get("X-Prometheus-Remote-Write-Version"))
+	username, password, ok := (&http.Request{Header: headers}).BasicAuth()
+	assert.True(t, ok)
+	assert.Equal(t, "hercules", username)
+	assert.Equal(t, "secret", password)
+	assert.Equal(t, 0, w.Queued())
+}
+
+func TestWriter_BearerTokenFromEnvironment(t *testing.T) {
+	r, server := newReceiver(t)
+	t.Setenv("REMOTE_WRITE_TOKEN", "token")
+	conf := newConfig(t, server.URL)
+	conf.BearerToken = "$REMOTE_WRITE_TOKEN"
+	w, err := remotewrite.New(conf, newRegistry(t))
+	require.NoError(t, err)
+
+	require.NoError(t, w.Write(context.Background()))
+	require.Len(t, r.headers, 1)
+	assert.Equal(t, "Bearer token", r.headers[0].Get("Authorization"))
+}
+
+func TestWriter_SplitsRequests(t *testing.T) {
+	r, server := newReceiver(t)
+	reg := prometheus.NewRegistry()
+	for _, name := range []string{"first", "second", "third"} {
+		newGauge(t, reg, name)
+	}
+	conf := newConfig(t, server.URL)
+	conf.MaxSamplesPerRequest = 2
+	w, err := remotewrite.New(conf, reg)
+	require.NoError(t, err)
+
+	require.NoError(t, w.Write(context.Background()))
+	require.Len(t, r.requests, 2)
+	assert.Len(t, r.requests[0], 2)
+	assert.Len(t, r.requests[1], 1)
+}
+
+func TestWriter_RetriesServerErrors(t *testing.T) {
+	r, server := newReceiver(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	conf := newConfig(t, server.URL)
+	maxRetries := 2
+	conf.MaxRetries = &maxRetries
+	w, err := remotewrite.New(conf, newRegistry(t))
+	require.NoError(t, err)
+
+	require.NoError(t, w.Write(context.Background()))
+	assert.Equal(t, 3, r.attempts)
+	assert.Len(t, r.requests, 1)
+	assert.Equal(t, 0, w.Queued())
+}
+
+func TestWriter_DropsRejectedRequests(t *testing.T) {
+	r, server := newReceiver(t, http.StatusBadRequest)
+	conf := newConfig(t, server.URL)
+	maxRetries := 2
+	conf.MaxRetries = &maxRetries
+	w, err := remotewrite.New(conf, newRegistry(t))
+	require.NoError(t, err)
+
+	require.NoError(t, w.Write(context.Background()))
+	assert.Equal(t, 1, r.attempts)
+	assert.Empty(t, r.requests)
+	assert.Equal(t, 0, w.Queued())
+}
+
+func TestWriter_QueuesDuringOutage(t *testing.T) {
+	r, server := newReceiver(t)
+	reg := prometheus.NewRegistry()
+	gauge := newGauge(t, reg, "test_gauge")
+	conf := newConfig(t, server.URL)
+	conf.Queue.MaxRequests = 2
+	w, err := remotewrite.New(conf, reg)
+	require.NoError(t, err)
+
+	// Every write fails, and only the latest two requests are kept
+	r.fail(http.StatusInternalServerError, 3)
+	for i := range 3 {
+		gauge.Set(float64(i))
+		err = w.Write(context.Background())
+		require.Error(t, err)
+		assert.True(t, strings.Contains(err.Error(), "500"), err.Error())
+	}
+	assert.Equal(t, 2, w.Queued())
+	assert.Empty(t, r.requests)
+
+	// Queued requests survive a restart and are sent oldest first once the endpoint recovers, the
+	// request of the write itself counting towards the bound
+	w, err = remotewrite.New(conf, reg)
+	require.NoError(t, err)
+	assert.Equal(t, 2, w.Queued())
+	gauge.Set(3)
+	require.NoError(t, w.Write(context.Background()))
+	assert.Equal(t, 0, w.Queued())
+
+	var values []float64
+	for _, request := range r.requests {
+		values = append(values, request[0].Value)
+	}
+	assert.Equal(t, []float64{2, 3}, values)
+}
+
+func TestWriter_StartAndStop(t *testing.T) {
+	r, server := newReceiver(t)
+	w, err := remotewrite.New(newConfig(t, server.URL), newRegistry(t))
+	require.NoError(t, err)
+
+	w.Start(time.Hour)
+	assert.Eventually(t, func() bool {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return len(r.requests) == 1
+	}, time.Second, time.Millisecond)
+	w.Stop()
+	w.Stop()
+}
+
+func TestConfig_Validate(t *testing.T) {
+	assert.NoError(t, (&remotewrite.Config{}).Validate(), "disabled configuration is valid")
+
+	maxRetries := -1
+	conf := remotewrite.Config{
+		URL:         "prometheus:9090",
+		MaxRetries:  &maxRetries,
+		BasicAuth:   remotewrite.BasicAuth{Username: "hercules"},
+		BearerToken: "token",
+		Queue:       remotewrite.QueueConfig{MaxRequests: -1},
+	}
+	var messages []string
+	for _, fieldError := range herculestypes.FieldErrors(conf.Validate()) {
+		messages = append(messages, fieldError.Error())
+	}
+	assert.Equal(t, []string{
+		`url: "prometheus:9090" is not an http or https URL`,
+		`maxRetries: maxRetries must not be negative`,
+		`bearerToken: basicAuth and bearerToken are mutually exclusive`,
+		`queue.maxRequests: maxRequests must not be negative`,
+	}, messages)
+}
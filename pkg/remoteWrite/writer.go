@@ -0,0 +1,247 @@
+// Package remotewrite pushes samples to a Prometheus remote write endpoint, for deployments
+// Prometheus cannot scrape.
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jakthom/hercules/pkg/telemetry"
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+	"github.com/rs/zerolog/log"
+)
+
+// maxErrorBodyBytes bounds how much of an error response is reported.
+const maxErrorBodyBytes = 512
+
+// Writer pushes the samples of a gatherer to a remote write endpoint. Requests that cannot be
+// sent are queued on disk and sent, oldest first, once the endpoint is available again.
+type Writer struct {
+	config    Config
+	gatherer  prometheus.Gatherer
+	client    *http.Client
+	queue     *queue
+	telemetry *telemetry.Telemetry
+	mu        sync.Mutex // Serializes writes, as the queue is not safe for concurrent use.
+	cancel    context.CancelFunc
+	done      chan struct{} // Closed once the background loop returns.
+}
+
+// rejectedError is returned for requests the endpoint rejected, which are not retried.
+type rejectedError struct {
+	err error
+}
+
+func (e *rejectedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *rejectedError) Unwrap() error {
+	return e.err
+}
+
+// New returns a writer pushing the samples of gatherer as configured, opening its queue.
+func New(config Config, gatherer prometheus.Gatherer) (*Writer, error) {
+	q, err := openQueue(config.queueDirectory(), config.queueMaxRequests())
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{
+		config:   config,
+		gatherer: gatherer,
+		client:   &http.Client{},
+		queue:    q,
+	}, nil
+}
+
+// Instrument records how many requests the writer sends, drops, and keeps queued.
+func (w *Writer) Instrument(t *telemetry.Telemetry) {
+	w.telemetry = t
+	t.RemoteWriteQueued(w.Queued())
+}
+
+// Queued returns how many requests are waiting to be sent.
+func (w *Writer) Queued() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.queue.Len()
+}
+
+// Start pushes samples once, and then on every interval in the background until Stop is called.
+func (w *Writer) Start(interval time.Duration) {
+	var ctx context.Context
+	ctx, w.cancel = context.WithCancel(context.Background())
+	w.done = make(chan struct{})
+	go func() {
+		defer close(w.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := w.Write(ctx); err != nil && ctx.Err() == nil {
+				log.Error().Err(err).Int("queued", w.Queued()).Msg("could not push samples - requests are kept queued")
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop halts pushing samples, interrupting an in-flight request, which is kept queued.
+func (w *Writer) Stop() {
+	if w.cancel == nil {
+		return
+	}
+	w.cancel()
+	<-w.done
+	w.cancel = nil
+}
+
+// Write gathers the current samples, queues them, and sends every queued request, oldest first.
+// Requests the endpoint rejects are dropped. Sending stops at the first request that still fails
+// once retried, leaving it and every later request queued.
+func (w *Writer) Write(ctx context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	defer func() { w.telemetry.RemoteWriteQueued(w.queue.Len()) }()
+
+	families, err := w.gatherer.Gather()
+	if err != nil {
+		// Gatherers report failures alongside every family they could gather
+		log.Warn().Err(err).Msg("could not gather every metric")
+	}
+	series, err := toTimeSeries(families, model.Now())
+	if err != nil {
+		log.Warn().Err(err).Msg("could not convert every metric to time series")
+	}
+	for batch := range slices.Chunk(series, w.config.maxSamplesPerRequest()) {
+		dropped, pushErr := w.queue.Push(snappy.Encode(nil, marshalWriteRequest(batch)))
+		if dropped > 0 {
+			log.Warn().Int("dropped", dropped).Msg("remote write queue is full - dropped the oldest requests")
+			w.telemetry.RemoteWriteDropped(dropped)
+		}
+		if pushErr != nil {
+			return fmt.Errorf("could not queue request: %w", pushErr)
+		}
+	}
+	return w.flush(ctx)
+}
+
+// flush sends queued requests, oldest first, until the queue is empty or a request fails.
+func (w *Writer) flush(ctx context.Context) error {
+	for {
+		request, ok, err := w.queue.Peek()
+		if !ok {
+			return nil
+		}
+		var rejected *rejectedError
+		if err == nil {
+			err = w.send(ctx, request)
+		} else {
+			// A request that cannot be read would block every later request
+			err = &rejectedError{fmt.Errorf("could not read queued request: %w", err)}
+		}
+		switch {
+		case errors.As(err, &rejected):
+			log.Error().Err(err).Msg("dropping request rejected by the remote write endpoint")
+			w.telemetry.RemoteWriteDropped(1)
+		case err != nil:
+			return err
+		default:
+			w.telemetry.RemoteWriteSent(time.Now())
+		}
+		if err = w.queue.Pop(); err != nil {
+			return fmt.Errorf("could not remove request from queue: %w", err)
+		}
+	}
+}
+
+// send posts request, retrying with exponential backoff unless the endpoint rejects it.
+func (w *Writer) send(ctx context.Context, request []byte) error {
+	backoff := w.config.backoff()
+	for attempt := 0; ; attempt++ {
+		err := w.post(ctx, request)
+		var rejected *rejectedError
+		if err == nil || errors.As(err, &rejected) || attempt >= w.config.maxRetries() {
+			return err
+		}
+		log.Warn().Err(err).Int("attempt", attempt+1).Dur("backoff", backoff).Msg("remote write failed - retrying")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// post sends a single compressed request. Rate limiting and server errors are returned as
+// retryable errors, and every other unsuccessful status as a *rejectedError.
+func (w *Writer) post(ctx context.Context, request []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, w.config.timeout())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.config.URL, bytes.NewReader(request))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "hercules")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	switch {
+	case w.config.BasicAuth.Username != "":
+		req.SetBasicAuth(w.config.BasicAuth.Username, w.config.BasicAuth.Password.Value())
+	case w.config.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+w.config.BearerToken.Value())
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodyBytes))
+	err = fmt.Errorf("remote write endpoint returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError {
+		return err
+	}
+	return &rejectedError{err}
+}
+
+// toTimeSeries converts families to one time series per sample, exactly as Prometheus would
+// scrape them. Samples without a timestamp are stamped with now.
+func toTimeSeries(families []*dto.MetricFamily, now model.Time) ([]timeSeries, error) {
+	samples, err := expfmt.ExtractSamples(&expfmt.DecodeOptions{Timestamp: now}, families...)
+	series := make([]timeSeries, 0, len(samples))
+	for _, sample := range samples {
+		s := timeSeries{
+			Labels:      make([]label, 0, len(sample.Metric)),
+			Value:       float64(sample.Value),
+			TimestampMs: int64(sample.Timestamp),
+		}
+		for name, value := range sample.Metric {
+			s.Labels = append(s.Labels, label{Name: string(name), Value: string(value)})
+		}
+		s.sortLabels()
+		series = append(series, s)
+	}
+	return series, err
+}
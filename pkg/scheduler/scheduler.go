@@ -0,0 +1,149 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"sync"
+	"time"
+
+	registry "github.com/jakthom/hercules/pkg/metricRegistry"
+	"github.com/rs/zerolog/log"
+)
+
+// Scheduler materializes metric registries in the background on a per-registry interval
+// so scrapes can be served from the latest snapshot instead of running queries.
+type Scheduler struct {
+	pool    *sql.DB
+	mu      sync.Mutex // Guards jobs and ctx.
+	jobs    []*job
+	ctx     context.Context // Set while the scheduler is running.
+	cancel  context.CancelFunc
+	running sync.WaitGroup
+}
+
+type job struct {
+	registry *registry.MetricRegistry
+	interval time.Duration
+	cancel   context.CancelFunc // Stops the job and interrupts its in-flight queries.
+	done     chan struct{}      // Closed once the job's loop returns.
+}
+
+// New returns a scheduler that checks out a connection from pool for every materialization.
+func New(pool *sql.DB) *Scheduler {
+	return &Scheduler{
+		pool: pool,
+	}
+}
+
+// Schedule adds a registry to be materialized every interval. Registries scheduled while the
+// scheduler is running are materialized once before Schedule returns.
+func (s *Scheduler) Schedule(r *registry.MetricRegistry, interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j := &job{registry: r, interval: interval}
+	s.jobs = append(s.jobs, j)
+	if s.ctx != nil {
+		s.materialize(s.ctx, r)
+		s.run(j)
+	}
+}
+
+// Unschedule stops materializing a registry, interrupting its in-flight queries, and waits for
+// its in-flight run to complete.
+func (s *Scheduler) Unschedule(r *registry.MetricRegistry) {
+	s.mu.Lock()
+	i := slices.IndexFunc(s.jobs, func(j *job) bool { return j.registry == r })
+	if i < 0 {
+		s.mu.Unlock()
+		return
+	}
+	j := s.jobs[i]
+	s.jobs = slices.Delete(s.jobs, i, i+1)
+	s.mu.Unlock()
+	j.stop()
+}
+
+// Start materializes every scheduled registry once, so a snapshot is available before the
+// first scrape, and then keeps refreshing each registry on its interval until Stop is called.
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	for _, j := range s.jobs {
+		s.materialize(s.ctx, j.registry)
+	}
+	for _, j := range s.jobs {
+		s.run(j)
+	}
+}
+
+// Materialize materializes every scheduled registry once in the foreground, without starting
+// background materialization.
+func (s *Scheduler) Materialize(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, j := range s.jobs {
+		s.materialize(ctx, j.registry)
+	}
+}
+
+// Stop halts all background materialization, interrupting in-flight queries, and waits for
+// in-flight runs to complete.
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	if s.cancel == nil {
+		s.mu.Unlock()
+		return
+	}
+	s.cancel()
+	s.ctx, s.cancel = nil, nil
+	s.mu.Unlock()
+	s.running.Wait()
+}
+
+// run starts materializing j on its interval until j or the scheduler is stopped.
+func (s *Scheduler) run(j *job) {
+	var ctx context.Context
+	ctx, j.cancel = context.WithCancel(s.ctx)
+	j.done = make(chan struct{})
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		defer close(j.done)
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.materialize(ctx, j.registry)
+			}
+		}
+	}()
+}
+
+func (j *job) stop() {
+	if j.cancel == nil {
+		return
+	}
+	j.cancel()
+	<-j.done
+}
+
+func (s *Scheduler) materialize(ctx context.Context, r *registry.MetricRegistry) {
+	start := time.Now()
+	conn, err := s.pool.Conn(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("could not check out connection")
+		return
+	}
+	defer conn.Close()
+	if err = r.Materialize(ctx, conn); err != nil {
+		// Individual failures are already reported by the registry
+		log.Debug().Int("failures", len(r.Snapshot().Errors)).Msg("registry materialized with errors")
+		return
+	}
+	log.Trace().Dur("duration", time.Since(start)).Msg("registry materialized")
+}
@@ -0,0 +1,154 @@
+// Package scheduler_test contains tests for the scheduler package
+package scheduler_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jakthom/hercules/pkg/metric"
+	registry "github.com/jakthom/hercules/pkg/metricRegistry"
+	"github.com/jakthom/hercules/pkg/scheduler"
+	"github.com/jakthom/hercules/pkg/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduler_StartMaterializesBeforeReturning(t *testing.T) {
+	reg := registry.NewMetricRegistry(metric.Definitions{
+		Gauge: []*metric.Definition{
+			{
+				Name:     "scheduled_gauge",
+				Help:     "Test gauge metric",
+				SQL:      "SELECT 1 AS value",
+				Metadata: metric.Metadata{PackageName: "scheduler"},
+			},
+		},
+	})
+
+	pool, mock, _ := testutil.GetMockedPool()
+	mock.ExpectQuery("SELECT 1 AS value").
+		WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(1))
+
+	s := scheduler.New(pool)
+	s.Schedule(reg, time.Hour)
+	s.Start()
+	defer s.Stop()
+
+	snapshot := reg.Snapshot()
+	require.NotNil(t, snapshot)
+	assert.Len(t, snapshot.Metrics, 1)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestScheduler_Materialize(t *testing.T) {
+	reg := registry.NewMetricRegistry(metric.Definitions{
+		Gauge: []*metric.Definition{
+			{
+				Name:     "exported_gauge",
+				Help:     "Test gauge metric",
+				SQL:      "SELECT 1 AS value",
+				Metadata: metric.Metadata{PackageName: "scheduler"},
+			},
+		},
+	})
+
+	pool, mock, _ := testutil.GetMockedPool()
+	mock.ExpectQuery("SELECT 1 AS value").
+		WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(1))
+
+	s := scheduler.New(pool)
+	s.Schedule(reg, 10*time.Millisecond)
+	s.Materialize(t.Context())
+
+	snapshot := reg.Snapshot()
+	require.NotNil(t, snapshot)
+	assert.Len(t, snapshot.Metrics, 1)
+	// Registries are not materialized again in the background
+	time.Sleep(30 * time.Millisecond)
+	assert.Same(t, snapshot, reg.Snapshot())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestScheduler_RematerializesOnInterval(t *testing.T) {
+	reg := registry.NewMetricRegistry(metric.Definitions{
+		Gauge: []*metric.Definition{
+			{
+				Name:     "interval_gauge",
+				Help:     "Test gauge metric",
+				SQL:      "SELECT 1 AS value",
+				Metadata: metric.Metadata{PackageName: "scheduler"},
+			},
+		},
+	})
+
+	pool, mock, _ := testutil.GetMockedPool()
+	mock.ExpectQuery("SELECT 1 AS value").
+		WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(1))
+	mock.ExpectQuery("SELECT 1 AS value").
+		WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(2))
+
+	s := scheduler.New(pool)
+	s.Schedule(reg, 10*time.Millisecond)
+	s.Start()
+	first := reg.Snapshot()
+
+	assert.Eventually(t, func() bool {
+		return reg.Snapshot() != first
+	}, time.Second, 5*time.Millisecond)
+	s.Stop()
+}
+
+func TestScheduler_ScheduleWhileRunning(t *testing.T) {
+	reg := registry.NewMetricRegistry(metric.Definitions{
+		Gauge: []*metric.Definition{
+			{
+				Name:     "late_gauge",
+				Help:     "Test gauge metric",
+				SQL:      "SELECT 1 AS value",
+				Metadata: metric.Metadata{PackageName: "scheduler"},
+			},
+		},
+	})
+
+	pool, mock, _ := testutil.GetMockedPool()
+	mock.ExpectQuery("SELECT 1 AS value").
+		WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(1))
+
+	s := scheduler.New(pool)
+	s.Start()
+	defer s.Stop()
+	s.Schedule(reg, time.Hour)
+
+	require.NotNil(t, reg.Snapshot())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestScheduler_Unschedule(t *testing.T) {
+	reg := registry.NewMetricRegistry(metric.Definitions{
+		Gauge: []*metric.Definition{
+			{
+				Name:     "unscheduled_gauge",
+				Help:     "Test gauge metric",
+				SQL:      "SELECT 1 AS value",
+				Metadata: metric.Metadata{PackageName: "scheduler"},
+			},
+		},
+	})
+
+	pool, mock, _ := testutil.GetMockedPool()
+	mock.ExpectQuery("SELECT 1 AS value").
+		WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(1))
+
+	s := scheduler.New(pool)
+	s.Schedule(reg, 10*time.Millisecond)
+	s.Start()
+	defer s.Stop()
+	s.Unschedule(reg)
+	first := reg.Snapshot()
+
+	// No further queries are expected once the registry is unscheduled
+	time.Sleep(50 * time.Millisecond)
+	assert.Same(t, first, reg.Snapshot())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -1,11 +1,18 @@
 package source
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"time"
 
 	"github.com/jakthom/hercules/pkg/db"
+	"github.com/jakthom/hercules/pkg/telemetry"
+	herculestypes "github.com/jakthom/hercules/pkg/types"
 	"github.com/rs/zerolog/log"
 )
 
@@ -19,13 +26,36 @@ const (
 	CSVSourceType     Type = "csv"
 )
 
+// FileType returns the type of source reading the file at path, by its extension.
+func FileType(path string) (Type, bool) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".parquet":
+		return ParquetSourceType, true
+	case ".csv":
+		return CSVSourceType, true
+	case ".json", ".ndjson", ".jsonl":
+		return JSONSourceType, true
+	default:
+		return "", false
+	}
+}
+
 type Source struct {
-	Name                   string    `json:"name"`
-	Type                   Type      `json:"type"`
-	Source                 string    `json:"source"`
-	Materialize            bool      `json:"materialize"` // Whether or not to materialize as a table.
-	RefreshIntervalSeconds int       `json:"refreshIntervalSeconds"`
-	stopChan               chan bool // Channel to stop the refresh goroutine.
+	Name                   string                 `json:"name"`
+	Type                   Type                   `json:"type"`
+	Source                 string                 `json:"source"`
+	Materialize            bool                   `json:"materialize"` // Whether or not to materialize as a table.
+	RefreshIntervalSeconds int                    `json:"refreshIntervalSeconds"`
+	Timeout                herculestypes.Duration `json:"timeout,omitempty"` // Defaults to the instance-wide source timeout.
+	stopChan               chan bool              // Channel to stop the refresh goroutine.
+	packageName            string
+	telemetry              *telemetry.Telemetry
+}
+
+// Instrument exports refresh timings for the source to t, labeled with the package it belongs to.
+func (s *Source) Instrument(pkg string, t *telemetry.Telemetry) {
+	s.packageName = pkg
+	s.telemetry = t
 }
 
 // Cleanup stops the background refresh process if it's running.
@@ -37,15 +67,67 @@ func (s *Source) Cleanup() {
 	}
 }
 
+// Validate checks the source for settings that cannot be refreshed. Every problem is reported as
+// a *herculestypes.FieldError locating the field.
+func (s *Source) Validate() error {
+	var errs []error
+	if s.Name == "" {
+		errs = append(errs, herculestypes.FieldErrorf("name", "name is required"))
+	}
+	switch s.Type {
+	case SQLSourceType, ParquetSourceType, JSONSourceType, CSVSourceType:
+	case "":
+		errs = append(errs, herculestypes.FieldErrorf("type", "type is required"))
+	default:
+		errs = append(errs, herculestypes.FieldErrorf("type", "unknown source type %q, expected one of sql, parquet, json, or csv", s.Type))
+	}
+	if s.Source == "" {
+		errs = append(errs, herculestypes.FieldErrorf("source", "source is required"))
+	}
+	if s.RefreshIntervalSeconds < 0 {
+		errs = append(errs, herculestypes.FieldErrorf("refreshIntervalSeconds", "refreshIntervalSeconds must not be negative"))
+	}
+	if s.Timeout < 0 {
+		errs = append(errs, herculestypes.FieldErrorf("timeout", "timeout must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// Remove stops refreshing a source that is no longer configured and stops exporting its refresh
+// timings. The view or table backing the source is left in place.
+func (s *Source) Remove() {
+	s.Cleanup()
+	s.telemetry.ForgetSource(s.packageName, s.Name)
+}
+
+// Equal reports whether s and other are configured identically, regardless of whether either
+// is being refreshed in the background.
+func (s *Source) Equal(other *Source) bool {
+	return reflect.DeepEqual(s.configuration(), other.configuration())
+}
+
+func (s *Source) configuration() Source {
+	return Source{
+		Name:                   s.Name,
+		Type:                   s.Type,
+		Source:                 s.Source,
+		Materialize:            s.Materialize,
+		RefreshIntervalSeconds: s.RefreshIntervalSeconds,
+		Timeout:                s.Timeout,
+	}
+}
+
 // SQL returns the SQL representation of the source.
 func (s *Source) SQL() db.SQL {
+	// File locations are read as string literals
+	location := strings.ReplaceAll(s.Source, "'", "''")
 	switch s.Type {
 	case ParquetSourceType:
-		return db.SQL(fmt.Sprintf("select * from read_parquet('%s')", s.Source))
+		return db.SQL(fmt.Sprintf("select * from read_parquet('%s')", location))
 	case CSVSourceType:
-		return db.SQL(fmt.Sprintf("select * from read_csv_auto('%s')", s.Source))
+		return db.SQL(fmt.Sprintf("select * from read_csv_auto('%s')", location))
 	case JSONSourceType:
-		return db.SQL(fmt.Sprintf("select * from read_json_auto('%s')", s.Source))
+		return db.SQL(fmt.Sprintf("select * from read_json_auto('%s')", location))
 	case SQLSourceType:
 		return db.SQL(s.Source)
 	default: // Default to sql
@@ -61,9 +143,9 @@ func (s *Source) createOrReplaceViewSQL() db.SQL {
 	return db.SQL("create or replace view " + s.Name + " as " + string(s.SQL()) + ";")
 }
 
-func (s *Source) refreshWithConn(conn *sql.Conn) error {
+func (s *Source) refreshWithConn(ctx context.Context, conn *sql.Conn) error {
 	if s.Materialize {
-		rows, err := db.RunSQLQuery(conn, s.createOrReplaceTableSQL())
+		rows, err := db.RunSQLQueryContext(ctx, conn, s.createOrReplaceTableSQL())
 		if err != nil {
 			return err
 		}
@@ -78,7 +160,7 @@ func (s *Source) refreshWithConn(conn *sql.Conn) error {
 		return nil
 	}
 
-	rows, err := db.RunSQLQuery(conn, s.createOrReplaceViewSQL())
+	rows, err := db.RunSQLQueryContext(ctx, conn, s.createOrReplaceViewSQL())
 	if err != nil {
 		return err
 	}
@@ -93,10 +175,58 @@ func (s *Source) refreshWithConn(conn *sql.Conn) error {
 	return nil
 }
 
-func (s *Source) initializeWithConnection(conn *sql.Conn) error {
-	err := s.refreshWithConn(conn)
+// refresh checks out a connection from the pool for a single refresh, interrupting the refresh
+// once the source's timeout elapses.
+func (s *Source) refresh(pool *sql.DB) error {
+	ctx := context.Background()
+	if timeout := s.Timeout.Duration(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	conn, err := pool.Conn(ctx)
 	if err != nil {
-		log.Fatal().Err(err).Interface("source", s.Name).Msg("could not refresh source")
+		return err
+	}
+	defer conn.Close()
+	start := time.Now()
+	err = s.refreshWithConn(ctx, conn)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("refresh timed out after %s: %w", s.Timeout.Duration(), err)
+	}
+	if s.telemetry != nil {
+		s.observeRefresh(conn, time.Since(start), err)
+	}
+	return err
+}
+
+func (s *Source) observeRefresh(conn *sql.Conn, duration time.Duration, err error) {
+	var rows *int64
+	if err == nil && s.Materialize {
+		var count int64
+		countErr := conn.QueryRowContext(context.Background(), "select count(*) from "+s.Name).Scan(&count)
+		if countErr != nil {
+			log.Debug().Err(countErr).Interface("source", s.Name).Msg("could not count source rows")
+		} else {
+			rows = &count
+		}
+	}
+	s.telemetry.SourceRefreshed(s.packageName, s.Name, duration, time.Now(), rows, err)
+}
+
+// Refresh re-creates the table or view of the source once, without starting background refreshes.
+func (s *Source) Refresh(pool *sql.DB) error {
+	if err := s.refresh(pool); err != nil {
+		return fmt.Errorf("could not refresh source %s: %w", s.Name, err)
+	}
+	return nil
+}
+
+// Initialize refreshes the source and, if it is a table with a refresh interval, starts
+// refreshing it in the background until Cleanup is called.
+func (s *Source) Initialize(pool *sql.DB) error {
+	if err := s.Refresh(pool); err != nil {
+		return err
 	}
 	// If the source is a table, materialize it on the predefined frequency. Views do not need to be refreshed.
 	if s.Materialize && s.RefreshIntervalSeconds > 0 {
@@ -110,12 +240,12 @@ func (s *Source) initializeWithConnection(conn *sql.Conn) error {
 					ticker.Stop()
 					return
 				case <-ticker.C:
-					go func(conn *sql.Conn, source *Source) {
-						refreshErr := source.refreshWithConn(conn)
+					go func(source *Source) {
+						refreshErr := source.refresh(pool)
 						if refreshErr != nil {
 							log.Debug().Interface("source", source.Name).Msg("could not refresh source")
 						}
-					}(conn, s)
+					}(s)
 				}
 			}
 		}()
@@ -130,11 +260,11 @@ func CleanupSources(sources []Source) {
 	}
 }
 
-func InitializeSourcesWithConnection(sources []Source, conn *sql.Conn) error {
+// InitializeSourcesWithPool refreshes every source and starts background refreshes. Each refresh
+// checks out its own connection from the pool.
+func InitializeSourcesWithPool(sources []Source, pool *sql.DB) error {
 	for i := range sources {
-		err := sources[i].initializeWithConnection(conn)
-		if err != nil {
-			log.Error().Err(err).Interface("source", sources[i].Name).Msg("could not initialize source")
+		if err := sources[i].Initialize(pool); err != nil {
 			return err
 		}
 	}
@@ -153,5 +283,5 @@ func (s *Source) CreateOrReplaceViewSQL() db.SQL {
 
 // TestHookRefreshWithConn exposes the refreshWithConn method for testing.
 func TestHookRefreshWithConn(s *Source, conn *sql.Conn) error {
-	return s.refreshWithConn(conn)
+	return s.refreshWithConn(context.Background(), conn)
 }
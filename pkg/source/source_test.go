@@ -2,12 +2,14 @@
 package source_test
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/jakthom/hercules/pkg/source"
 	"github.com/jakthom/hercules/pkg/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSource_Sql(t *testing.T) {
@@ -52,6 +54,15 @@ func TestSource_Sql(t *testing.T) {
 			},
 			expected: "select * from read_csv_auto('/path/to/file.csv')",
 		},
+		{
+			name: "Quoted file location",
+			source: source.Source{
+				Name:   "test_quoted",
+				Type:   source.ParquetSourceType,
+				Source: "/data/o'brien.parquet",
+			},
+			expected: "select * from read_parquet('/data/o''brien.parquet')",
+		},
 	}
 
 	for _, tt := range tests {
@@ -130,7 +141,7 @@ func TestSource_RefreshWithConn_View(t *testing.T) {
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
-func TestInitializeSourcesWithConnection(t *testing.T) {
+func TestInitializeSourcesWithPool(t *testing.T) {
 	sources := []source.Source{
 		{
 			Name:                   "source1",
@@ -147,7 +158,7 @@ func TestInitializeSourcesWithConnection(t *testing.T) {
 		},
 	}
 
-	conn, mock, _ := testutil.GetMockedConnection()
+	pool, mock, _ := testutil.GetMockedPool()
 
 	// Setup mock expectations - using Query instead of Exec to match RunSqlQuery implementation
 	mock.ExpectQuery("create or replace table source1 as SELECT 1;").
@@ -156,9 +167,25 @@ func TestInitializeSourcesWithConnection(t *testing.T) {
 		WillReturnRows(sqlmock.NewRows([]string{"result"}))
 
 	// Call the function
-	err := source.InitializeSourcesWithConnection(sources, conn)
+	err := source.InitializeSourcesWithPool(sources, pool)
 
 	// Verify
 	assert.NoError(t, err)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
+
+func TestInitializeSourcesWithPool_ReturnsErrors(t *testing.T) {
+	sources := []source.Source{
+		{Name: "broken", Type: source.SQLSourceType, Source: "SELECT * FROM missing"},
+		{Name: "skipped", Type: source.SQLSourceType, Source: "SELECT 1"},
+	}
+
+	pool, mock, _ := testutil.GetMockedPool()
+	mock.ExpectQuery("create or replace view broken as SELECT * FROM missing;").
+		WillReturnError(errors.New("table missing does not exist"))
+
+	err := source.InitializeSourcesWithPool(sources, pool)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "could not refresh source broken")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
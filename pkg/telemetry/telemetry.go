@@ -0,0 +1,273 @@
+package telemetry
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// Namespace prefixes every metric Hercules exports about itself.
+	Namespace = "hercules"
+
+	// PackageLabel is the label identifying the package a self-metric describes.
+	PackageLabel = "package"
+	// MetricLabel is the label identifying the metric definition a self-metric describes.
+	MetricLabel = "metric"
+	// SourceLabel is the label identifying the source a self-metric describes.
+	SourceLabel = "source"
+	// EndpointLabel is the label identifying the endpoint a self-metric describes.
+	EndpointLabel = "endpoint"
+)
+
+// Telemetry holds the metrics Hercules exports about its own work. A nil *Telemetry is valid
+// and records nothing, so instrumentation is always optional.
+type Telemetry struct {
+	metricUp            *prometheus.GaugeVec
+	metricLastSuccess   *prometheus.GaugeVec
+	metricErrors        *prometheus.CounterVec
+	metricQueryDuration *prometheus.HistogramVec
+	metricRows          *prometheus.HistogramVec
+	sourceDuration      *prometheus.HistogramVec
+	sourceLastRefresh   *prometheus.GaugeVec
+	sourceRows          *prometheus.GaugeVec
+	// Remote write metrics have no labels, and are only exported once remote write is in use.
+	remoteWriteSent        *prometheus.CounterVec
+	remoteWriteLastSuccess *prometheus.GaugeVec
+	remoteWriteDropped     *prometheus.CounterVec
+	remoteWriteQueued      *prometheus.GaugeVec
+	otlpExports            *prometheus.CounterVec
+	otlpFailedExports      *prometheus.CounterVec
+	otlpLastSuccess        *prometheus.GaugeVec
+}
+
+func New() *Telemetry {
+	metricLabels := []string{MetricLabel, PackageLabel}
+	sourceLabels := []string{SourceLabel, PackageLabel}
+	return &Telemetry{
+		metricUp: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "metric_up",
+			Help:      "Whether the last materialization of a metric definition succeeded (1) or failed (0).",
+		}, metricLabels),
+		metricLastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "metric_last_success_timestamp_seconds",
+			Help:      "Unix timestamp of the last successful materialization of a metric definition.",
+		}, metricLabels),
+		metricErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "metric_errors_total",
+			Help:      "Total number of failed materializations of a metric definition.",
+		}, metricLabels),
+		metricQueryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "metric_query_duration_seconds",
+			Help:      "Time taken to query and materialize a metric definition.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+		}, metricLabels),
+		metricRows: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "metric_query_rows",
+			Help:      "Number of rows returned by a metric definition's query.",
+			Buckets:   prometheus.ExponentialBuckets(1, 10, 8),
+		}, metricLabels),
+		sourceDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "source_refresh_duration_seconds",
+			Help:      "Time taken to refresh a source.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+		}, sourceLabels),
+		sourceLastRefresh: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "source_last_refresh_timestamp_seconds",
+			Help:      "Unix timestamp of the last successful refresh of a source.",
+		}, sourceLabels),
+		sourceRows: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "source_rows",
+			Help:      "Number of rows in a materialized source as of its last successful refresh.",
+		}, sourceLabels),
+		remoteWriteSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "remote_write_sent_requests_total",
+			Help:      "Total number of requests accepted by the remote write endpoint.",
+		}, nil),
+		remoteWriteLastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "remote_write_last_success_timestamp_seconds",
+			Help:      "Unix timestamp of the last request accepted by the remote write endpoint.",
+		}, nil),
+		remoteWriteDropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "remote_write_dropped_requests_total",
+			Help:      "Total number of remote write requests dropped because they were rejected or the queue was full.",
+		}, nil),
+		remoteWriteQueued: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "remote_write_queued_requests",
+			Help:      "Number of remote write requests waiting to be sent.",
+		}, nil),
+		otlpExports: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "otlp_exports_total",
+			Help:      "Total number of exports to an OTLP endpoint.",
+		}, []string{EndpointLabel}),
+		otlpFailedExports: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "otlp_failed_exports_total",
+			Help:      "Total number of failed exports to an OTLP endpoint.",
+		}, []string{EndpointLabel}),
+		otlpLastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "otlp_last_success_timestamp_seconds",
+			Help:      "Unix timestamp of the last successful export to an OTLP endpoint.",
+		}, []string{EndpointLabel}),
+	}
+}
+
+func (t *Telemetry) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		t.metricUp,
+		t.metricLastSuccess,
+		t.metricErrors,
+		t.metricQueryDuration,
+		t.metricRows,
+		t.sourceDuration,
+		t.sourceLastRefresh,
+		t.sourceRows,
+		t.remoteWriteSent,
+		t.remoteWriteLastSuccess,
+		t.remoteWriteDropped,
+		t.remoteWriteQueued,
+		t.otlpExports,
+		t.otlpFailedExports,
+		t.otlpLastSuccess,
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (t *Telemetry) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range t.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (t *Telemetry) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range t.collectors() {
+		c.Collect(ch)
+	}
+}
+
+// TrackMetric initializes the self-metrics of a metric definition so that it is exported
+// (as down) even before its first materialization.
+func (t *Telemetry) TrackMetric(pkg string, metric string) {
+	if t == nil {
+		return
+	}
+	t.metricUp.WithLabelValues(metric, pkg).Set(0)
+	t.metricErrors.WithLabelValues(metric, pkg).Add(0)
+}
+
+// MetricMaterialized records the outcome of a single metric materialization.
+func (t *Telemetry) MetricMaterialized(pkg string, metric string, at time.Time, err error) {
+	if t == nil {
+		return
+	}
+	if err != nil {
+		t.metricUp.WithLabelValues(metric, pkg).Set(0)
+		t.metricErrors.WithLabelValues(metric, pkg).Inc()
+		return
+	}
+	t.metricUp.WithLabelValues(metric, pkg).Set(1)
+	t.metricLastSuccess.WithLabelValues(metric, pkg).Set(float64(at.UnixNano()) / float64(time.Second))
+}
+
+// MetricQueried records how long a metric definition took to materialize and how many rows its query returned.
+func (t *Telemetry) MetricQueried(pkg string, metric string, duration time.Duration, rows int) {
+	if t == nil {
+		return
+	}
+	t.metricQueryDuration.WithLabelValues(metric, pkg).Observe(duration.Seconds())
+	t.metricRows.WithLabelValues(metric, pkg).Observe(float64(rows))
+}
+
+// SourceRefreshed records a single source refresh. The last refresh timestamp and row count are
+// only updated when the refresh succeeds; rows is nil for sources that are not materialized.
+func (t *Telemetry) SourceRefreshed(pkg string, source string, duration time.Duration, at time.Time, rows *int64, err error) {
+	if t == nil {
+		return
+	}
+	t.sourceDuration.WithLabelValues(source, pkg).Observe(duration.Seconds())
+	if err != nil {
+		return
+	}
+	t.sourceLastRefresh.WithLabelValues(source, pkg).Set(float64(at.UnixNano()) / float64(time.Second))
+	if rows != nil {
+		t.sourceRows.WithLabelValues(source, pkg).Set(float64(*rows))
+	}
+}
+
+// RemoteWriteSent records a request accepted by the remote write endpoint.
+func (t *Telemetry) RemoteWriteSent(at time.Time) {
+	if t == nil {
+		return
+	}
+	t.remoteWriteSent.WithLabelValues().Inc()
+	t.remoteWriteLastSuccess.WithLabelValues().Set(float64(at.UnixNano()) / float64(time.Second))
+}
+
+// RemoteWriteDropped records remote write requests that were dropped without being sent.
+func (t *Telemetry) RemoteWriteDropped(requests int) {
+	if t == nil {
+		return
+	}
+	t.remoteWriteDropped.WithLabelValues().Add(float64(requests))
+}
+
+// RemoteWriteQueued records how many remote write requests are waiting to be sent.
+func (t *Telemetry) RemoteWriteQueued(requests int) {
+	if t == nil {
+		return
+	}
+	t.remoteWriteQueued.WithLabelValues().Set(float64(requests))
+	t.remoteWriteSent.WithLabelValues().Add(0)
+	t.remoteWriteDropped.WithLabelValues().Add(0)
+}
+
+// OTLPExported records a single export to an OTLP endpoint.
+func (t *Telemetry) OTLPExported(endpoint string, at time.Time, err error) {
+	if t == nil {
+		return
+	}
+	t.otlpExports.WithLabelValues(endpoint).Inc()
+	t.otlpFailedExports.WithLabelValues(endpoint).Add(0)
+	if err != nil {
+		t.otlpFailedExports.WithLabelValues(endpoint).Inc()
+		return
+	}
+	t.otlpLastSuccess.WithLabelValues(endpoint).Set(float64(at.UnixNano()) / float64(time.Second))
+}
+
+// ForgetMetric stops exporting the self-metrics of a metric definition that no longer exists.
+func (t *Telemetry) ForgetMetric(pkg string, metric string) {
+	if t == nil {
+		return
+	}
+	t.metricUp.DeleteLabelValues(metric, pkg)
+	t.metricLastSuccess.DeleteLabelValues(metric, pkg)
+	t.metricErrors.DeleteLabelValues(metric, pkg)
+	t.metricQueryDuration.DeleteLabelValues(metric, pkg)
+	t.metricRows.DeleteLabelValues(metric, pkg)
+}
+
+// ForgetSource stops exporting the refresh timings of a source that no longer exists.
+func (t *Telemetry) ForgetSource(pkg string, source string) {
+	if t == nil {
+		return
+	}
+	t.sourceDuration.DeleteLabelValues(source, pkg)
+	t.sourceLastRefresh.DeleteLabelValues(source, pkg)
+	t.sourceRows.DeleteLabelValues(source, pkg)
+}
@@ -0,0 +1,181 @@
+// Package telemetry_test contains tests for the telemetry package
+package telemetry_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jakthom/hercules/pkg/telemetry"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTelemetry_TrackMetric(t *testing.T) {
+	tel := telemetry.New()
+	tel.TrackMetric("snowflake", "snowflake_query_status_count")
+
+	expected := `
+# HELP hercules_metric_up Whether the last materialization of a metric definition succeeded (1) or failed (0).
+# TYPE hercules_metric_up gauge
+hercules_metric_up{metric="snowflake_query_status_count",package="snowflake"} 0
+# HELP hercules_metric_errors_total Total number of failed materializations of a metric definition.
+# TYPE hercules_metric_errors_total counter
+hercules_metric_errors_total{metric="snowflake_query_status_count",package="snowflake"} 0
+`
+	assert.NoError(t, promtestutil.CollectAndCompare(tel, strings.NewReader(expected),
+		"hercules_metric_up", "hercules_metric_errors_total", "hercules_metric_last_success_timestamp_seconds"))
+}
+
+func TestTelemetry_MetricMaterialized(t *testing.T) {
+	tel := telemetry.New()
+	at := time.Unix(1700000000, 0)
+
+	tel.MetricMaterialized("snowflake", "broken", at, errors.New("boom"))
+	tel.MetricMaterialized("snowflake", "broken", at, errors.New("boom"))
+	tel.MetricMaterialized("snowflake", "working", at, nil)
+
+	expected := `
+# HELP hercules_metric_up Whether the last materialization of a metric definition succeeded (1) or failed (0).
+# TYPE hercules_metric_up gauge
+hercules_metric_up{metric="broken",package="snowflake"} 0
+hercules_metric_up{metric="working",package="snowflake"} 1
+# HELP hercules_metric_errors_total Total number of failed materializations of a metric definition.
+# TYPE hercules_metric_errors_total counter
+hercules_metric_errors_total{metric="broken",package="snowflake"} 2
+# HELP hercules_metric_last_success_timestamp_seconds Unix timestamp of the last successful materialization of a metric definition.
+# TYPE hercules_metric_last_success_timestamp_seconds gauge
+hercules_metric_last_success_timestamp_seconds{metric="working",package="snowflake"} 1.7e+09
+`
+	assert.NoError(t, promtestutil.CollectAndCompare(tel, strings.NewReader(expected),
+		"hercules_metric_up", "hercules_metric_errors_total", "hercules_metric_last_success_timestamp_seconds"))
+}
+
+func TestTelemetry_MetricQueried(t *testing.T) {
+	tel := telemetry.New()
+	tel.MetricQueried("snowflake", "slow", 2*time.Second, 150)
+	tel.MetricQueried("snowflake", "slow", 3*time.Second, 50)
+
+	assert.Equal(t, 1, promtestutil.CollectAndCount(tel, "hercules_metric_query_duration_seconds"))
+	assert.Equal(t, 1, promtestutil.CollectAndCount(tel, "hercules_metric_query_rows"))
+
+	expected := `
+# HELP hercules_metric_query_rows Number of rows returned by a metric definition's query.
+# TYPE hercules_metric_query_rows histogram
+hercules_metric_query_rows_bucket{metric="slow",package="snowflake",le="1"} 0
+hercules_metric_query_rows_bucket{metric="slow",package="snowflake",le="10"} 0
+hercules_metric_query_rows_bucket{metric="slow",package="snowflake",le="100"} 1
+hercules_metric_query_rows_bucket{metric="slow",package="snowflake",le="1000"} 2
+hercules_metric_query_rows_bucket{metric="slow",package="snowflake",le="10000"} 2
+hercules_metric_query_rows_bucket{metric="slow",package="snowflake",le="100000"} 2
+hercules_metric_query_rows_bucket{metric="slow",package="snowflake",le="1e+06"} 2
+hercules_metric_query_rows_bucket{metric="slow",package="snowflake",le="1e+07"} 2
+hercules_metric_query_rows_bucket{metric="slow",package="snowflake",le="+Inf"} 2
+hercules_metric_query_rows_sum{metric="slow",package="snowflake"} 200
+hercules_metric_query_rows_count{metric="slow",package="snowflake"} 2
+`
+	assert.NoError(t, promtestutil.CollectAndCompare(tel, strings.NewReader(expected), "hercules_metric_query_rows"))
+}
+
+func TestTelemetry_SourceRefreshed(t *testing.T) {
+	tel := telemetry.New()
+	at := time.Unix(1700000000, 0)
+	rows := int64(42)
+
+	tel.SourceRefreshed("nyc-taxi", "trips", time.Second, at, &rows, nil)
+	tel.SourceRefreshed("nyc-taxi", "trips", time.Second, at.Add(time.Hour), nil, errors.New("boom"))
+	tel.SourceRefreshed("nyc-taxi", "zones", time.Second, at, nil, nil)
+
+	expected := `
+# HELP hercules_source_last_refresh_timestamp_seconds Unix timestamp of the last successful refresh of a source.
+# TYPE hercules_source_last_refresh_timestamp_seconds gauge
+hercules_source_last_refresh_timestamp_seconds{package="nyc-taxi",source="trips"} 1.7e+09
+hercules_source_last_refresh_timestamp_seconds{package="nyc-taxi",source="zones"} 1.7e+09
+# HELP hercules_source_rows Number of rows in a materialized source as of its last successful refresh.
+# TYPE hercules_source_rows gauge
+hercules_source_rows{package="nyc-taxi",source="trips"} 42
+`
+	assert.NoError(t, promtestutil.CollectAndCompare(tel, strings.NewReader(expected),
+		"hercules_source_last_refresh_timestamp_seconds", "hercules_source_rows"))
+	assert.Equal(t, 2, promtestutil.CollectAndCount(tel, "hercules_source_refresh_duration_seconds"))
+}
+
+func TestTelemetry_Forget(t *testing.T) {
+	tel := telemetry.New()
+	at := time.Unix(1700000000, 0)
+	tel.MetricMaterialized("snowflake", "removed", at, nil)
+	tel.MetricQueried("snowflake", "removed", time.Second, 1)
+	tel.MetricMaterialized("snowflake", "kept", at, nil)
+	tel.SourceRefreshed("snowflake", "removed", time.Second, at, nil, nil)
+
+	tel.ForgetMetric("snowflake", "removed")
+	tel.ForgetSource("snowflake", "removed")
+
+	assert.Equal(t, 1, promtestutil.CollectAndCount(tel, "hercules_metric_up"))
+	assert.Equal(t, 0, promtestutil.CollectAndCount(tel, "hercules_metric_query_duration_seconds"))
+	assert.Equal(t, 0, promtestutil.CollectAndCount(tel, "hercules_source_refresh_duration_seconds"))
+}
+
+func TestTelemetry_RemoteWrite(t *testing.T) {
+	tel := telemetry.New()
+	assert.Equal(t, 0, promtestutil.CollectAndCount(tel, "hercules_remote_write_queued_requests"))
+
+	tel.RemoteWriteQueued(3)
+	tel.RemoteWriteSent(time.Unix(1700000000, 0))
+	tel.RemoteWriteDropped(2)
+
+	expected := `
+# HELP hercules_remote_write_sent_requests_total Total number of requests accepted by the remote write endpoint.
+# TYPE hercules_remote_write_sent_requests_total counter
+hercules_remote_write_sent_requests_total 1
+# HELP hercules_remote_write_last_success_timestamp_seconds Unix timestamp of the last request accepted by the remote write endpoint.
+# TYPE hercules_remote_write_last_success_timestamp_seconds gauge
+hercules_remote_write_last_success_timestamp_seconds 1.7e+09
+# HELP hercules_remote_write_dropped_requests_total Total number of remote write requests dropped because they were rejected or the queue was full.
+# TYPE hercules_remote_write_dropped_requests_total counter
+hercules_remote_write_dropped_requests_total 2
+# HELP hercules_remote_write_queued_requests Number of remote write requests waiting to be sent.
+# TYPE hercules_remote_write_queued_requests gauge
+hercules_remote_write_queued_requests 3
+`
+	assert.NoError(t, promtestutil.CollectAndCompare(tel, strings.NewReader(expected),
+		"hercules_remote_write_sent_requests_total", "hercules_remote_write_last_success_timestamp_seconds",
+		"hercules_remote_write_dropped_requests_total", "hercules_remote_write_queued_requests"))
+}
+
+func TestTelemetry_OTLPExported(t *testing.T) {
+	tel := telemetry.New()
+	tel.OTLPExported("http://collector:4317", time.Unix(1700000000, 0), nil)
+	tel.OTLPExported("http://collector:4317", time.Unix(1700000060, 0), errors.New("unavailable"))
+
+	expected := `
+# HELP hercules_otlp_exports_total Total number of exports to an OTLP endpoint.
+# TYPE hercules_otlp_exports_total counter
+hercules_otlp_exports_total{endpoint="http://collector:4317"} 2
+# HELP hercules_otlp_failed_exports_total Total number of failed exports to an OTLP endpoint.
+# TYPE hercules_otlp_failed_exports_total counter
+hercules_otlp_failed_exports_total{endpoint="http://collector:4317"} 1
+# HELP hercules_otlp_last_success_timestamp_seconds Unix timestamp of the last successful export to an OTLP endpoint.
+# TYPE hercules_otlp_last_success_timestamp_seconds gauge
+hercules_otlp_last_success_timestamp_seconds{endpoint="http://collector:4317"} 1.7e+09
+`
+	assert.NoError(t, promtestutil.CollectAndCompare(tel, strings.NewReader(expected),
+		"hercules_otlp_exports_total", "hercules_otlp_failed_exports_total", "hercules_otlp_last_success_timestamp_seconds"))
+}
+
+func TestTelemetry_NilIsNoop(t *testing.T) {
+	var tel *telemetry.Telemetry
+	assert.NotPanics(t, func() {
+		tel.TrackMetric("pkg", "metric")
+		tel.MetricMaterialized("pkg", "metric", time.Now(), nil)
+		tel.MetricQueried("pkg", "metric", time.Second, 1)
+		tel.SourceRefreshed("pkg", "source", time.Second, time.Now(), nil, nil)
+		tel.ForgetMetric("pkg", "metric")
+		tel.ForgetSource("pkg", "source")
+		tel.RemoteWriteSent(time.Now())
+		tel.RemoteWriteDropped(1)
+		tel.RemoteWriteQueued(1)
+		tel.OTLPExported("endpoint", time.Now(), nil)
+	})
+}
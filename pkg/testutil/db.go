@@ -18,3 +18,14 @@ func GetMockedConnection() (*sql.Conn, sqlmock.Sqlmock, error) {
 	defer db.Close()
 	return conn, mock, nil
 }
+
+// GetMockedPool returns a mocked connection pool. Every connection checked out of the pool
+// shares the same expectations.
+func GetMockedPool() (*sql.DB, sqlmock.Sqlmock, error) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		log.Fatal().Err(err).Msg("error '%s' was not expected when opening a stub database connection")
+		return nil, nil, err
+	}
+	return db, mock, nil
+}
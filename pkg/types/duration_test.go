@@ -0,0 +1,43 @@
+package herculestypes_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	herculestypes "github.com/jakthom/hercules/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDuration_Unmarshal(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected time.Duration
+	}{
+		{name: "Duration string", input: `"10m"`, expected: 10 * time.Minute},
+		{name: "Seconds", input: `30`, expected: 30 * time.Second},
+		{name: "Fractional seconds", input: `0.5`, expected: 500 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var d herculestypes.Duration
+			require.NoError(t, json.Unmarshal([]byte(tt.input), &d))
+			assert.Equal(t, tt.expected, d.Duration())
+		})
+	}
+}
+
+func TestDuration_UnmarshalInvalid(t *testing.T) {
+	var d herculestypes.Duration
+	assert.Error(t, json.Unmarshal([]byte(`"ten minutes"`), &d))
+	assert.Error(t, json.Unmarshal([]byte(`true`), &d))
+}
+
+func TestDuration_Marshal(t *testing.T) {
+	serialized, err := json.Marshal(herculestypes.Duration(90 * time.Second))
+	require.NoError(t, err)
+	assert.JSONEq(t, `"1m30s"`, string(serialized))
+}
@@ -0,0 +1,108 @@
+package herculestypes
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// FieldError is a problem with a single field of a configuration file or package. Path locates the
+// field within the file, e.g. metrics.histogram[2].buckets.
+type FieldError struct {
+	File string
+	Path string
+	Err  error
+}
+
+// FieldErrorf returns a *FieldError for the field at path, formatting its message with fmt.Errorf.
+func FieldErrorf(path string, format string, args ...any) error {
+	return &FieldError{Path: path, Err: fmt.Errorf(format, args...)}
+}
+
+func (e *FieldError) Error() string {
+	var b strings.Builder
+	if e.File != "" {
+		b.WriteString(e.File + ": ")
+	}
+	if e.Path != "" {
+		b.WriteString(e.Path + ": ")
+	}
+	b.WriteString(e.Err.Error())
+	return b.String()
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// Nest locates every error joined in err within the field at path. Errors that are not field
+// errors are located at path itself, and errors attributed to a file are left as they are.
+func Nest(err error, path string) error {
+	return mapFieldErrors(err, func(e FieldError) FieldError {
+		if e.File == "" {
+			e.Path = joinPath(path, e.Path)
+		}
+		return e
+	})
+}
+
+// InFile attributes every error joined in err that is not yet attributed to a file to file.
+func InFile(err error, file string) error {
+	return mapFieldErrors(err, func(e FieldError) FieldError {
+		if e.File == "" {
+			e.File = file
+		}
+		return e
+	})
+}
+
+// FieldErrors returns every error joined in err as a field error.
+func FieldErrors(err error) []*FieldError {
+	var fieldErrors []*FieldError
+	for _, e := range flatten(err) {
+		var fieldError *FieldError
+		if !errors.As(e, &fieldError) {
+			fieldError = &FieldError{Err: e}
+		}
+		fieldErrors = append(fieldErrors, fieldError)
+	}
+	return fieldErrors
+}
+
+func mapFieldErrors(err error, fn func(FieldError) FieldError) error {
+	var errs []error
+	for _, fieldError := range FieldErrors(err) {
+		mapped := fn(*fieldError)
+		errs = append(errs, &mapped)
+	}
+	return errors.Join(errs...)
+}
+
+// flatten returns the errors joined in err, recursively.
+func flatten(err error) []error {
+	if err == nil {
+		return nil
+	}
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []error{err}
+	}
+	var errs []error
+	for _, e := range joined.Unwrap() {
+		errs = append(errs, flatten(e)...)
+	}
+	return errs
+}
+
+func joinPath(parent string, path string) string {
+	switch {
+	case path == "":
+		return parent
+	case parent == "":
+		return path
+	case strings.HasPrefix(path, "["):
+		return parent + path
+	default:
+		return parent + "." + path
+	}
+}
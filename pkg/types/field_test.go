@@ -0,0 +1,30 @@
+package herculestypes_test
+
+import (
+	"errors"
+	"testing"
+
+	herculestypes "github.com/jakthom/hercules/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFieldError_NestAndInFile(t *testing.T) {
+	err := errors.Join(
+		herculestypes.FieldErrorf("buckets", "histogram requires buckets"),
+		herculestypes.Nest(herculestypes.FieldErrorf("name", "name is required"), "[1]"),
+		errors.New("not a field error"),
+	)
+	err = herculestypes.InFile(herculestypes.Nest(err, "metrics.histogram"), "package.yml")
+
+	fieldErrors := herculestypes.FieldErrors(err)
+	require.Len(t, fieldErrors, 3)
+	assert.Equal(t, "package.yml: metrics.histogram.buckets: histogram requires buckets", fieldErrors[0].Error())
+	assert.Equal(t, "metrics.histogram[1].name", fieldErrors[1].Path)
+	assert.Equal(t, "package.yml: metrics.histogram: not a field error", fieldErrors[2].Error())
+	assert.NoError(t, herculestypes.Nest(nil, "metrics"))
+
+	// Errors of another file are not located within the field
+	nested := herculestypes.FieldErrors(herculestypes.Nest(err, "packages[0]"))
+	assert.Equal(t, "metrics.histogram[1].name", nested[1].Path)
+}
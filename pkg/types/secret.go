@@ -0,0 +1,25 @@
+package herculestypes
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// Secret is a credential that is read from the environment variable it names if it starts with $,
+// and that is never logged.
+type Secret string
+
+// Value returns the secret, resolved from the environment.
+func (s Secret) Value() string {
+	if len(s) > 0 && s[0] == '$' {
+		return os.Getenv(string(s[1:]))
+	}
+	return string(s)
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	if s == "" {
+		return json.Marshal("")
+	}
+	return json.Marshal("<secret>")
+}
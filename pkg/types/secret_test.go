@@ -0,0 +1,23 @@
+package herculestypes_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	herculestypes "github.com/jakthom/hercules/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecret_Value(t *testing.T) {
+	t.Setenv("HERCULES_TEST_SECRET", "from-env")
+	assert.Equal(t, "literal", herculestypes.Secret("literal").Value())
+	assert.Equal(t, "from-env", herculestypes.Secret("$HERCULES_TEST_SECRET").Value())
+	assert.Empty(t, herculestypes.Secret("").Value())
+}
+
+func TestSecret_MarshalJSON(t *testing.T) {
+	encoded, err := json.Marshal(map[string]herculestypes.Secret{"set": "token", "unset": ""})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"set": "<secret>", "unset": ""}`, string(encoded))
+}
@@ -0,0 +1,124 @@
+// Package watcher notifies Hercules when its configuration or package files change.
+package watcher
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultDebounce is how long a watcher waits for changes to settle before notifying. Editors
+// often save a file with several writes, or by replacing it.
+const DefaultDebounce = 500 * time.Millisecond
+
+// Watcher calls a function once a burst of changes to any of a set of files settles. The
+// directories holding the files are watched rather than the files themselves, so files that are
+// replaced rather than written to keep being watched.
+type Watcher struct {
+	fs       *fsnotify.Watcher
+	debounce time.Duration
+	onChange func()
+
+	mu    sync.Mutex // Guards files, dirs, and timer.
+	files map[string]bool
+	dirs  map[string]bool
+	timer *time.Timer
+}
+
+// New returns a watcher that calls onChange debounce after the last change to a watched file.
+// No files are watched until Watch is called.
+func New(debounce time.Duration, onChange func()) (*Watcher, error) {
+	fs, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &Watcher{
+		fs:       fs,
+		debounce: debounce,
+		onChange: onChange,
+		files:    make(map[string]bool),
+		dirs:     make(map[string]bool),
+	}
+	go w.run()
+	return w, nil
+}
+
+// Watch replaces the watched files with files.
+func (w *Watcher) Watch(files []string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	watchedFiles := make(map[string]bool, len(files))
+	dirs := make(map[string]bool, len(files))
+	for _, file := range files {
+		path, err := filepath.Abs(file)
+		if err != nil {
+			return err
+		}
+		watchedFiles[path] = true
+		dirs[filepath.Dir(path)] = true
+	}
+	for dir := range w.dirs {
+		if !dirs[dir] {
+			if err := w.fs.Remove(dir); err != nil {
+				log.Debug().Err(err).Str("directory", dir).Msg("could not stop watching directory")
+			}
+		}
+	}
+	for dir := range dirs {
+		if w.dirs[dir] {
+			continue
+		}
+		if err := w.fs.Add(dir); err != nil {
+			return err
+		}
+	}
+	w.files, w.dirs = watchedFiles, dirs
+	log.Debug().Strs("files", files).Msg("watching files for changes")
+	return nil
+}
+
+// Close stops watching files. Pending notifications are dropped.
+func (w *Watcher) Close() error {
+	w.mu.Lock()
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.mu.Unlock()
+	return w.fs.Close()
+}
+
+func (w *Watcher) run() {
+	for {
+		select {
+		case event, ok := <-w.fs.Events:
+			if !ok {
+				return
+			}
+			w.handle(event)
+		case err, ok := <-w.fs.Errors:
+			if !ok {
+				return
+			}
+			log.Error().Err(err).Msg("error watching files")
+		}
+	}
+}
+
+func (w *Watcher) handle(event fsnotify.Event) {
+	if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) && !event.Has(fsnotify.Rename) && !event.Has(fsnotify.Remove) {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.files[filepath.Clean(event.Name)] {
+		return
+	}
+	log.Debug().Str("file", event.Name).Str("op", event.Op.String()).Msg("watched file changed")
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.timer = time.AfterFunc(w.debounce, w.onChange)
+}
@@ -0,0 +1,57 @@
+// Package watcher_test contains tests for the watcher package
+package watcher_test
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jakthom/hercules/pkg/watcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatcher_DebouncesChanges(t *testing.T) {
+	dir := t.TempDir()
+	watched := filepath.Join(dir, "hercules.yml")
+	ignored := filepath.Join(dir, "ignored.yml")
+	require.NoError(t, os.WriteFile(watched, []byte("name: a"), 0o600))
+
+	var changes atomic.Int32
+	w, err := watcher.New(50*time.Millisecond, func() { changes.Add(1) })
+	require.NoError(t, err)
+	defer w.Close()
+	require.NoError(t, w.Watch([]string{watched}))
+
+	require.NoError(t, os.WriteFile(ignored, []byte("name: b"), 0o600))
+	for _, name := range []string{"b", "c", "d"} {
+		require.NoError(t, os.WriteFile(watched, []byte("name: "+name), 0o600))
+	}
+
+	assert.Eventually(t, func() bool { return changes.Load() == 1 }, time.Second, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(1), changes.Load())
+}
+
+func TestWatcher_FollowsReplacedFiles(t *testing.T) {
+	dir := t.TempDir()
+	watched := filepath.Join(dir, "package.yml")
+	require.NoError(t, os.WriteFile(watched, []byte("name: a"), 0o600))
+
+	var changes atomic.Int32
+	w, err := watcher.New(10*time.Millisecond, func() { changes.Add(1) })
+	require.NoError(t, err)
+	defer w.Close()
+	require.NoError(t, w.Watch([]string{watched}))
+
+	// Editors commonly save by renaming a temporary file over the original
+	replacement := filepath.Join(dir, "package.yml.tmp")
+	require.NoError(t, os.WriteFile(replacement, []byte("name: b"), 0o600))
+	require.NoError(t, os.Rename(replacement, watched))
+	assert.Eventually(t, func() bool { return changes.Load() == 1 }, time.Second, 5*time.Millisecond)
+
+	require.NoError(t, os.WriteFile(watched, []byte("name: c"), 0o600))
+	assert.Eventually(t, func() bool { return changes.Load() == 2 }, time.Second, 5*time.Millisecond)
+}